@@ -0,0 +1,376 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, http.MethodGet, "/ping", nil, nil, nil)
+}
+
+// OpenAPI returns the OpenAPI 3 document of the server
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var doc json.RawMessage
+	err := c.call(ctx, http.MethodGet, "/openapi.json", nil, nil, &doc)
+	return doc, err
+}
+
+// Usage returns what the api key used this month against its quota
+func (c *Client) Usage(ctx context.Context) (UsageResp, error) {
+	var u UsageResp
+	err := c.call(ctx, http.MethodGet, "/usage", nil, nil, &u)
+	return u, err
+}
+
+// projects
+
+func (c *Client) CreateProject(ctx context.Context, req ProjectReq) (Project, error) {
+	var p Project
+	err := c.call(ctx, http.MethodPost, "/projects", nil, req, &p)
+	return p, err
+}
+
+func (c *Client) Projects(ctx context.Context) ([]Project, error) {
+	var out ProjectList
+	err := c.call(ctx, http.MethodGet, "/projects", nil, nil, &out)
+	return out.Projects, err
+}
+
+func (c *Client) Project(ctx context.Context, name string) (Project, error) {
+	var p Project
+	err := c.call(ctx, http.MethodGet, path("projects", name), nil, nil, &p)
+	return p, err
+}
+
+func (c *Client) DeleteProject(ctx context.Context, name string) error {
+	return c.call(ctx, http.MethodDelete, path("projects", name), nil, nil, nil)
+}
+
+// assets
+
+// Upload is a file sent in a multipart form
+type Upload struct {
+	Name string
+	Body io.Reader
+}
+
+// UploadAssets stores files in the asset area of kind, none is kept when one
+// of them is rejected
+func (c *Client) UploadAssets(ctx context.Context, project string, kind AssetKind, files ...Upload) ([]Asset, error) {
+	fields := url.Values{"kind": {string(kind)}}
+	parts := make([]formFile, 0, len(files))
+	for _, f := range files {
+		parts = append(parts, formFile{field: "file", Upload: f})
+	}
+	var out AssetList
+	err := c.form(ctx, path("projects", project, "assets"), fields, parts, &out)
+	return out.Assets, err
+}
+
+// Assets lists the assets of project, kind "" lists every kind
+func (c *Client) Assets(ctx context.Context, project string, kind AssetKind) ([]Asset, error) {
+	var query url.Values
+	if kind != "" {
+		query = url.Values{"kind": {string(kind)}}
+	}
+	var out AssetList
+	err := c.call(ctx, http.MethodGet, path("projects", project, "assets"), query, nil, &out)
+	return out.Assets, err
+}
+
+func (c *Client) DeleteAsset(ctx context.Context, project string, kind AssetKind, filename string) error {
+	return c.call(ctx, http.MethodDelete, path("projects", project, "assets", string(kind), filename), nil, nil, nil)
+}
+
+// narrations
+
+func (c *Client) Narrations(ctx context.Context, project string) ([]Narration, error) {
+	var out NarrationList
+	err := c.call(ctx, http.MethodGet, path("projects", project, "narrations"), nil, nil, &out)
+	return out.Narrations, err
+}
+
+func (c *Client) Narration(ctx context.Context, project, id string) (Narration, error) {
+	var nar Narration
+	err := c.call(ctx, http.MethodGet, path("projects", project, "narrations", id), nil, nil, &nar)
+	return nar, err
+}
+
+func (c *Client) InsertNarration(ctx context.Context, project string, req InsertNarrationReq) (Narration, error) {
+	var nar Narration
+	err := c.call(ctx, http.MethodPost, path("projects", project, "narrations"), nil, req, &nar)
+	return nar, err
+}
+
+func (c *Client) EditNarration(ctx context.Context, project, id string, req EditNarrationReq) (Narration, error) {
+	var nar Narration
+	err := c.call(ctx, http.MethodPatch, path("projects", project, "narrations", id), nil, req, &nar)
+	return nar, err
+}
+
+func (c *Client) DeleteNarration(ctx context.Context, project, id string) error {
+	return c.call(ctx, http.MethodDelete, path("projects", project, "narrations", id), nil, nil, nil)
+}
+
+func (c *Client) ReorderNarrations(ctx context.Context, project string, ids []string) ([]Narration, error) {
+	var out NarrationList
+	err := c.call(ctx, http.MethodPut, path("projects", project, "narrations", "order"), nil, ReorderNarrationsReq{IDs: ids}, &out)
+	return out.Narrations, err
+}
+
+// SplitNarration cuts narration id at rune offset at
+func (c *Client) SplitNarration(ctx context.Context, project, id string, at int) ([]Narration, error) {
+	var out NarrationList
+	err := c.call(ctx, http.MethodPost, path("projects", project, "narrations", id, "split"), nil, SplitNarrationReq{At: at}, &out)
+	return out.Narrations, err
+}
+
+func (c *Client) JoinNarrations(ctx context.Context, project string, ids []string, sep string) (Narration, error) {
+	var nar Narration
+	err := c.call(ctx, http.MethodPost, path("projects", project, "narrations", "join"), nil, JoinNarrationsReq{IDs: ids, Separator: sep}, &nar)
+	return nar, err
+}
+
+// submissions
+
+func (c *Client) submit(ctx context.Context, p string, req any, opts []RequestOption) (TaskAccepted, error) {
+	var out TaskAccepted
+	err := c.call(ctx, http.MethodPost, p, nil, req, &out, opts...)
+	return out, err
+}
+
+func (c *Client) GenScript(ctx context.Context, req GenScriptReq, opts ...RequestOption) (TaskAccepted, error) {
+	return c.submit(ctx, "/gen", req, opts)
+}
+
+func (c *Client) TTSSingle(ctx context.Context, req TTSGenSingleReq, opts ...RequestOption) (TaskAccepted, error) {
+	return c.submit(ctx, "/tts/single", req, opts)
+}
+
+func (c *Client) TTSAll(ctx context.Context, req TTSGenAllReq, opts ...RequestOption) (TaskAccepted, error) {
+	return c.submit(ctx, "/tts/all", req, opts)
+}
+
+func (c *Client) Concat(ctx context.Context, req ConcatReq, opts ...RequestOption) (TaskAccepted, error) {
+	return c.submit(ctx, "/concat", req, opts)
+}
+
+func (c *Client) Render(ctx context.Context, req RenderReq, opts ...RequestOption) (TaskAccepted, error) {
+	return c.submit(ctx, "/render", req, opts)
+}
+
+func (c *Client) Merge(ctx context.Context, req MergeReq, opts ...RequestOption) (TaskAccepted, error) {
+	return c.submit(ctx, "/merge", req, opts)
+}
+
+func (c *Client) Subtitle(ctx context.Context, req GenSubtitleReq, opts ...RequestOption) (TaskAccepted, error) {
+	return c.submit(ctx, "/subtitle", req, opts)
+}
+
+func (c *Client) BurnSubtitle(ctx context.Context, req BrunReq, opts ...RequestOption) (TaskAccepted, error) {
+	return c.submit(ctx, "/brun", req, opts)
+}
+
+func (c *Client) Episode(ctx context.Context, req EpisodeReq, opts ...RequestOption) (TaskAccepted, error) {
+	return c.submit(ctx, "/episodes", req, opts)
+}
+
+// MixdownReq uploads the narration and the background music, Filename is
+// a name in the project Folder or a path in the store
+type MixdownReq struct {
+	Folder      string
+	Filename    string
+	Audio       Upload
+	BGM         Upload
+	Loop        bool
+	CallbackURL string
+}
+
+func (c *Client) Mixdown(ctx context.Context, req MixdownReq, opts ...RequestOption) (TaskAccepted, error) {
+	fields := url.Values{
+		"filename": {req.Filename},
+		"loop":     {strconv.FormatBool(req.Loop)},
+	}
+	if req.Folder != "" {
+		fields.Set("folder", req.Folder)
+	}
+	if req.CallbackURL != "" {
+		fields.Set("callbackUrl", req.CallbackURL)
+	}
+	var out TaskAccepted
+	err := c.form(ctx, "/mix", fields, []formFile{
+		{field: "audio", Upload: req.Audio},
+		{field: "bgm", Upload: req.BGM},
+	}, &out, opts...)
+	return out, err
+}
+
+// SubmitJob submits a graph of tasks and returns the task ID of every name
+func (c *Client) SubmitJob(ctx context.Context, req JobReq, opts ...RequestOption) (map[string]string, error) {
+	var out JobAccepted
+	err := c.call(ctx, http.MethodPost, "/jobs", nil, req, &out, opts...)
+	return out.Tasks, err
+}
+
+// tasks
+
+func (c *Client) Task(ctx context.Context, id string) (TaskStatus, error) {
+	var st TaskStatus
+	err := c.call(ctx, http.MethodGet, path("tasks", id), nil, nil, &st)
+	return st, err
+}
+
+func (c *Client) Tasks(ctx context.Context, filter ListTasksReq) ([]TaskStatus, error) {
+	query := url.Values{}
+	if filter.Type != "" {
+		query.Set("type", filter.Type)
+	}
+	if filter.State != "" {
+		query.Set("state", filter.State)
+	}
+	var out TaskList
+	err := c.call(ctx, http.MethodGet, "/tasks", query, nil, &out)
+	return out.Tasks, err
+}
+
+// CancelTask stops a queued or running task and returns its status
+func (c *Client) CancelTask(ctx context.Context, id string) (TaskStatus, error) {
+	var st TaskStatus
+	err := c.call(ctx, http.MethodDelete, path("tasks", id), nil, nil, &st)
+	return st, err
+}
+
+func (c *Client) ResumeTask(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodPost, path("tasks", id, "resume"), nil, nil, nil)
+}
+
+// Wait polls task id every interval until it finished, a failed or cancelled
+// task is returned without error, check its State
+func (c *Client) Wait(ctx context.Context, id string, interval time.Duration) (TaskStatus, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		st, err := c.Task(ctx, id)
+		if err != nil {
+			return st, err
+		}
+		if st.State.Done() {
+			return st, nil
+		}
+		select {
+		case <-ctx.Done():
+			return st, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedules
+
+func (c *Client) AddSchedule(ctx context.Context, req ScheduleReq) (Schedule, error) {
+	var sc Schedule
+	err := c.call(ctx, http.MethodPost, "/schedules", nil, req, &sc)
+	return sc, err
+}
+
+func (c *Client) Schedules(ctx context.Context) ([]Schedule, error) {
+	var out ScheduleList
+	err := c.call(ctx, http.MethodGet, "/schedules", nil, nil, &out)
+	return out.Schedules, err
+}
+
+func (c *Client) Schedule(ctx context.Context, id string) (Schedule, error) {
+	var sc Schedule
+	err := c.call(ctx, http.MethodGet, path("schedules", id), nil, nil, &sc)
+	return sc, err
+}
+
+func (c *Client) PauseSchedule(ctx context.Context, id string) (Schedule, error) {
+	var sc Schedule
+	err := c.call(ctx, http.MethodPost, path("schedules", id, "pause"), nil, nil, &sc)
+	return sc, err
+}
+
+func (c *Client) ResumeSchedule(ctx context.Context, id string) (Schedule, error) {
+	var sc Schedule
+	err := c.call(ctx, http.MethodPost, path("schedules", id, "resume"), nil, nil, &sc)
+	return sc, err
+}
+
+func (c *Client) DeleteSchedule(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, path("schedules", id), nil, nil, nil)
+}
+
+// dead letters
+
+func (c *Client) DeadLetters(ctx context.Context) ([]TaskStatus, error) {
+	var out TaskList
+	err := c.call(ctx, http.MethodGet, "/dead-letters", nil, nil, &out)
+	return out.Tasks, err
+}
+
+// RetryDeadLetter resubmits a dead letter and returns the new task
+func (c *Client) RetryDeadLetter(ctx context.Context, id string) (TaskAccepted, error) {
+	var out TaskAccepted
+	err := c.call(ctx, http.MethodPost, path("dead-letters", id, "retry"), nil, nil, &out)
+	return out, err
+}
+
+func (c *Client) DiscardDeadLetter(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, path("dead-letters", id), nil, nil, nil)
+}
+
+type formFile struct {
+	field string
+	Upload
+}
+
+// form posts a multipart form, the files are streamed rather than buffered
+func (c *Client) form(ctx context.Context, p string, fields url.Values, files []formFile, out any, opts ...RequestOption) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeForm(mw, fields, files))
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, p, nil, pr, mw.FormDataContentType(), opts)
+	if err != nil {
+		_ = pr.Close()
+		return err
+	}
+	err = c.decode(req, out)
+	// unblocks the writer when the server answered before reading it all
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
+func writeForm(mw *multipart.Writer, fields url.Values, files []formFile) error {
+	for k, vs := range fields {
+		for _, v := range vs {
+			if err := mw.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range files {
+		if f.Body == nil {
+			return fmt.Errorf("upload %s has no body", f.Name)
+		}
+		w, err := mw.CreateFormFile(f.field, f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, f.Body); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
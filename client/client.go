@@ -0,0 +1,200 @@
+// Package client calls the http api of a comp0ser server: project and
+// narration editing, task submission, polling, event streams and downloads.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client is safe for concurrent use
+type Client struct {
+	base   *url.URL
+	apiKey string
+	hc     *http.Client
+}
+
+type Option func(*Client)
+
+// WithAPIKey authenticates every call with key as a bearer token
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithHTTPClient replaces http.DefaultClient, event streams need one without
+// a total timeout
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.hc = hc
+	}
+}
+
+// New makes a client of the server at baseURL, e.g. "http://localhost:8088"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("want an absolute http(s) url, got %q", baseURL)
+	}
+	c := &Client{
+		base: u,
+		hc:   http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// RequestOption adjusts a single call
+type RequestOption func(*http.Request)
+
+// WithIdempotencyKey makes a retried submission return the task of the first
+// one instead of running twice
+func WithIdempotencyKey(key string) RequestOption {
+	return func(r *http.Request) {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+}
+
+func WithHeader(key, value string) RequestOption {
+	return func(r *http.Request) {
+		r.Header.Set(key, value)
+	}
+}
+
+// Error is an answer outside 2xx
+type Error struct {
+	StatusCode int
+	Message    string
+	Detail     string
+	// RetryAfter is set on 429 and 503
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return fmt.Sprintf("comp0ser: %d %s", e.StatusCode, msg)
+}
+
+// IsStatus reports whether err is an *Error with status code
+func IsStatus(err error, code int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == code
+}
+
+// path joins the escaped segments of a route
+func path(segments ...string) string {
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+func (c *Client) newRequest(ctx context.Context, method, p string, query url.Values, body io.Reader, contentType string, opts []RequestOption) (*http.Request, error) {
+	u := *c.base
+	u.RawPath = strings.TrimRight(c.base.EscapedPath(), "/") + p
+	unescaped, err := url.PathUnescape(u.RawPath)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = unescaped
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+	return req, nil
+}
+
+// send runs req and turns an answer outside 2xx into an *Error, the caller
+// closes the body of a good answer
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	e := &Error{StatusCode: resp.StatusCode}
+	var body ErrorResp
+	if raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err == nil && json.Unmarshal(raw, &body) == nil {
+		e.Message, e.Detail = body.Error, body.Detail
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return nil, e
+}
+
+// call sends in as json and decodes the answer into out, nil in sends no
+// body and nil out drops the answer
+func (c *Client) call(ctx context.Context, method, p string, query url.Values, in, out any, opts ...RequestOption) error {
+	var (
+		body        io.Reader
+		contentType string
+	)
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(raw), "application/json"
+	}
+	req, err := c.newRequest(ctx, method, p, query, body, contentType, opts)
+	if err != nil {
+		return err
+	}
+	return c.decode(req, out)
+}
+
+func (c *Client) decode(req *http.Request, out any) error {
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
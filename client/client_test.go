@@ -0,0 +1,325 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"comp0ser/internal/auth"
+	"comp0ser/internal/cmd"
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/server"
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeWorker finishes every task it is given on the second poll
+type fakeWorker struct {
+	worker.Worker
+
+	mu    sync.Mutex
+	tasks map[string]*worker.TaskStatus
+	polls map[string]int
+}
+
+func newFakeWorker() *fakeWorker {
+	return &fakeWorker{
+		tasks: make(map[string]*worker.TaskStatus),
+		polls: make(map[string]int),
+	}
+}
+
+func (f *fakeWorker) Submit(ctx context.Context, typ worker.TaskType, payload any, opts ...worker.SubmitOption) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := fmt.Sprintf("%s-%d", typ, len(f.tasks))
+	f.tasks[id] = &worker.TaskStatus{ID: id, Type: typ, State: worker.TaskQueued, CreatedAt: time.Now()}
+	return id, nil
+}
+
+func (f *fakeWorker) Get(id string) (worker.TaskStatus, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	st, ok := f.tasks[id]
+	if !ok {
+		return worker.TaskStatus{}, false
+	}
+	f.polls[id]++
+	if f.polls[id] >= 2 {
+		st.State = worker.TaskSucceeded
+		st.Outputs = []string{"/store/ep1/out.mp4"}
+	}
+	return *st, true
+}
+
+func (f *fakeWorker) Subscribe(id string) (<-chan worker.Event, func()) {
+	ch := make(chan worker.Event, 2)
+	ch <- worker.Event{Type: worker.EventProgress, TaskID: id, Progress: &cmd.Progress{OutTime: 5, Percent: 50}}
+	ch <- worker.Event{Type: worker.EventState, TaskID: id, State: worker.TaskSucceeded}
+	return ch, func() {}
+}
+
+func newTestClient(t *testing.T, key string) *Client {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	keys, err := auth.New([]auth.Key{
+		{Name: "tool", Hash: auth.Hash("secret"), Scope: auth.ScopeSubmit},
+	}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wk := newFakeWorker()
+	srv := httptest.NewServer(server.Routes(context.Background(), server.Deps{
+		FS:   filestore.NewFileLocalStore(dir),
+		Auth: keys,
+		Probe: func(string) (*cmd.MediaInfo, error) {
+			return &cmd.MediaInfo{VideoCodec: "png", Width: 4, Height: 4}, nil
+		},
+		Worker:  wk,
+		TmpRoot: t.TempDir(),
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, WithAPIKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient_Projects(t *testing.T) {
+	c := newTestClient(t, "secret")
+	ctx := context.Background()
+
+	if _, err := c.CreateProject(ctx, ProjectReq{Name: "ep 1", Subject: "moon"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.InsertNarration(ctx, "ep 1", InsertNarrationReq{Text: "hello moon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.InsertNarration(ctx, "ep 1", InsertNarrationReq{Text: "bye"}); err != nil {
+		t.Fatal(err)
+	}
+	parts, err := c.SplitNarration(ctx, "ep 1", first.ID, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || parts[0].Text != "hello" || parts[1].Text != " moon" {
+		t.Fatalf("split: %+v", parts)
+	}
+
+	png := []byte("\x89PNG fake image")
+	assets, err := c.UploadAssets(ctx, "ep 1", AssetStill, Upload{Name: "cover.png", Body: bytes.NewReader(png)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 1 || assets[0].Path != "asset/still/cover.png" {
+		t.Fatalf("assets: %+v", assets)
+	}
+
+	info, err := c.StatFile(ctx, "ep 1", assets[0].Path)
+	if err != nil || info.Size != int64(len(png)) || info.ETag == "" {
+		t.Fatalf("stat: %+v %v", info, err)
+	}
+	body, err := c.OpenFile(ctx, "ep 1", assets[0].Path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail, _ := io.ReadAll(body)
+	_ = body.Close()
+	if string(tail) != string(png[5:]) {
+		t.Fatalf("ranged read %q", tail)
+	}
+
+	_, err = c.Narration(ctx, "ep 1", "missing")
+	if !IsStatus(err, http.StatusNotFound) {
+		t.Fatalf("want a 404, got %v", err)
+	}
+	// deleting a project needs the admin scope
+	var apiErr *Error
+	if err := c.DeleteProject(ctx, "ep 1"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("want a 403, got %v", err)
+	}
+}
+
+func TestClient_Tasks(t *testing.T) {
+	c := newTestClient(t, "secret")
+	ctx := context.Background()
+
+	if _, err := c.CreateProject(ctx, ProjectReq{Name: "ep1"}); err != nil {
+		t.Fatal(err)
+	}
+	acc, err := c.Render(ctx, RenderReq{Folder: "ep1", Dur: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.TaskID == "" {
+		t.Fatal("no task id")
+	}
+
+	st, err := c.Wait(ctx, acc.TaskID, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != TaskSucceeded || len(st.Outputs) != 1 {
+		t.Fatalf("wait: %+v", st)
+	}
+
+	acc, err = c.Concat(ctx, ConcatReq{Folder: "ep1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := c.TaskEvents(ctx, acc.TaskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+	var got []EventType
+	for {
+		e, err := events.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e.Type)
+	}
+	// the snapshot, then the events up to the terminal state
+	if len(got) != 3 || got[0] != EventState || got[1] != EventProgress || got[2] != EventState {
+		t.Fatalf("events %v", got)
+	}
+}
+
+func TestClient_Unauthorized(t *testing.T) {
+	c := newTestClient(t, "wrong")
+
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("ping is public: %v", err)
+	}
+	doc, err := c.OpenAPI(context.Background())
+	if err != nil || !strings.Contains(string(doc), `"openapi"`) {
+		t.Fatalf("openapi: %v", err)
+	}
+	_, err = c.Projects(context.Background())
+	if !IsStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("want a 401, got %v", err)
+	}
+}
+
+// TestWireTypes compares every wire type with the schema of the same name in
+// the OpenAPI document of the server
+func TestWireTypes(t *testing.T) {
+	c := newTestClient(t, "secret")
+	raw, err := c.OpenAPI(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Type string `json:"type"`
+				} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	types := []any{
+		ProjectReq{}, InsertNarrationReq{}, EditNarrationReq{}, ReorderNarrationsReq{}, SplitNarrationReq{}, JoinNarrationsReq{},
+		GenScriptReq{}, TTSGenSingleReq{}, TTSGenAllReq{}, ConcatReq{}, RenderReq{}, MergeReq{}, GenSubtitleReq{}, BrunReq{},
+		EpisodeReq{}, EpisodeRenderReq{}, EpisodeMixReq{}, EpisodeSubtitleReq{}, JobReq{}, JobTaskReq{}, ScheduleReq{},
+		ErrorResp{}, FieldError{}, TaskAccepted{}, JobAccepted{}, TaskList{}, ProjectList{}, AssetList{}, NarrationList{},
+		ScheduleList{}, UsageResp{}, Usage{}, Quota{}, Project{}, Prompt{}, Artifact{}, Asset{}, MediaInfo{}, Narration{},
+		TaskStatus{}, CallbackStatus{}, CallbackAttempt{}, Progress{}, Schedule{},
+	}
+	for _, v := range types {
+		typ := reflect.TypeOf(v)
+		t.Run(typ.Name(), func(t *testing.T) {
+			schema, ok := doc.Components.Schemas[typ.Name()]
+			if !ok {
+				t.Fatal("not in the openapi document")
+			}
+			got := jsonFields(typ)
+			var want []string
+			for name, prop := range schema.Properties {
+				want = append(want, name)
+				if kind, ok := got[name]; ok && kind != "" && prop.Type != "" && kind != prop.Type {
+					t.Errorf("%s is %s, the server says %s", name, kind, prop.Type)
+				}
+			}
+			names := make([]string, 0, len(got))
+			for name := range got {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			slices.Sort(want)
+			if !slices.Equal(names, want) {
+				t.Fatalf("fields %v, the server has %v", names, want)
+			}
+		})
+	}
+}
+
+// jsonFields maps the json names of struct t to their openapi type, ""
+// for the types the test does not compare
+func jsonFields(t reflect.Type) map[string]string {
+	fields := make(map[string]string)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" {
+			for k, v := range jsonFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		kind := ""
+		switch ft.Kind() {
+		case reflect.String:
+			kind = "string"
+		case reflect.Struct:
+			if ft == reflect.TypeFor[time.Time]() {
+				kind = "string"
+			}
+		case reflect.Bool:
+			kind = "boolean"
+		case reflect.Int, reflect.Int64:
+			kind = "integer"
+		case reflect.Float64:
+			kind = "number"
+		case reflect.Map:
+			kind = "object"
+		case reflect.Slice:
+			if ft != reflect.TypeFor[json.RawMessage]() {
+				kind = "array"
+			}
+		}
+		fields[name] = kind
+	}
+	return fields
+}
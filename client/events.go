@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// EventStream reads the server-sent events of a task or of every task
+type EventStream struct {
+	body io.ReadCloser
+	r    *bufio.Reader
+}
+
+// TaskEvents streams the events of task id, starting with its current state;
+// the stream ends with io.EOF after the terminal state
+func (c *Client) TaskEvents(ctx context.Context, id string) (*EventStream, error) {
+	return c.events(ctx, path("tasks", id, "events"))
+}
+
+// Events streams the events of every task until ctx is done
+func (c *Client) Events(ctx context.Context) (*EventStream, error) {
+	return c.events(ctx, "/events")
+}
+
+func (c *Client) events(ctx context.Context, p string) (*EventStream, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, p, nil, nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{body: resp.Body, r: bufio.NewReader(resp.Body)}, nil
+}
+
+// Next blocks for the next event, keep-alive pings are skipped
+func (s *EventStream) Next() (Event, error) {
+	for {
+		name, data, err := s.read()
+		if err != nil {
+			return Event{}, err
+		}
+		if name == "ping" || data == "" {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return Event{}, fmt.Errorf("bad %s event: %w", name, err)
+		}
+		return e, nil
+	}
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
+
+// read returns the name and data of the next event block
+func (s *EventStream) read() (name, data string, err error) {
+	var lines []string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && (name != "" || len(lines) > 0) {
+				return name, strings.Join(lines, "\n"), nil
+			}
+			return "", "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if name == "" && len(lines) == 0 {
+				continue
+			}
+			return name, strings.Join(lines, "\n"), nil
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			name = value
+		case "data":
+			lines = append(lines, value)
+		}
+	}
+}
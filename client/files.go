@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FileInfo describes a project file without downloading it
+type FileInfo struct {
+	Size        int64
+	ContentType string
+	ETag        string
+	ModTime     time.Time
+}
+
+func filePath(project, rel string) string {
+	return path(append([]string{"projects", project, "files"}, strings.Split(strings.Trim(rel, "/"), "/")...)...)
+}
+
+// StatFile reads the size, type and ETag of a file in the project folder
+func (c *Client) StatFile(ctx context.Context, project, rel string) (FileInfo, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, filePath(project, rel), nil, nil, "", nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	_ = resp.Body.Close()
+
+	info := FileInfo{
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+// OpenFile reads a file of the project folder from byte offset on, an
+// artifact path such as "final.mp4" or "asset/bgm/theme.mp3"
+func (c *Client) OpenFile(ctx context.Context, project, rel string, offset int64) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, filePath(project, rel), nil, nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("server ignored the range of %s", rel)
+	}
+	return resp.Body, nil
+}
+
+// Download copies a file of the project folder to w
+func (c *Client) Download(ctx context.Context, project, rel string, w io.Writer) (int64, error) {
+	body, err := c.OpenFile(ctx, project, rel, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	return io.Copy(w, body)
+}
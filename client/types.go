@@ -0,0 +1,530 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// the wire types mirror the json of the server, TestWireTypes checks them
+// against its OpenAPI document so they cannot drift apart
+
+// IdempotencyKeyHeader makes a retried submission return the earlier task
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Callback is accepted by every submission, the server posts a signed
+// summary to the url once the task finished
+type Callback struct {
+	CallbackURL string `json:"callbackUrl,omitempty"`
+}
+
+// requests
+
+type ProjectReq struct {
+	Name     string `json:"name"`
+	Subject  string `json:"subject"`
+	Language string `json:"language"`
+	Voice    string `json:"voice"`
+	// TTS names the speech provider, empty uses the server default
+	TTS    string `json:"tts"`
+	Prompt Prompt `json:"prompt"`
+}
+
+// InsertNarrationReq appends a narration, or puts it at Index
+type InsertNarrationReq struct {
+	Text  string         `json:"text"`
+	Index *int           `json:"index,omitempty"`
+	Voice string         `json:"voice"`
+	Meta  map[string]any `json:"meta,omitempty"`
+}
+
+// EditNarrationReq changes the given fields only, a new text or voice drops
+// the synthesized audio
+type EditNarrationReq struct {
+	Text  *string        `json:"text,omitempty"`
+	Voice *string        `json:"voice,omitempty"`
+	Meta  map[string]any `json:"meta,omitempty"`
+}
+
+type ReorderNarrationsReq struct {
+	IDs []string `json:"ids"`
+}
+
+// SplitNarrationReq cuts the text at rune offset At
+type SplitNarrationReq struct {
+	At int `json:"at"`
+}
+
+type JoinNarrationsReq struct {
+	IDs       []string `json:"ids"`
+	Separator string   `json:"separator"`
+}
+
+type GenScriptReq struct {
+	Callback
+
+	RawText  string `json:"rawText"`
+	Subject  string `json:"subject"`
+	Segments int    `json:"segments"`
+	MinChars int    `json:"minChars"`
+	MaxChars int    `json:"maxChars"`
+	Focus    string `json:"focus"`
+	Hook     string `json:"hook"`
+	// Model is the llm text model, empty uses the server default
+	Model string `json:"model"`
+}
+
+type TTSGenSingleReq struct {
+	Callback
+
+	Folder string `json:"folder"`
+	NarID  string `json:"narId"`
+	TTS    string `json:"tts"`
+}
+
+type TTSGenAllReq struct {
+	Callback
+
+	Folder string `json:"folder"`
+	// TTS overrides the speech provider of the project
+	TTS string `json:"tts"`
+}
+
+type ConcatReq struct {
+	Callback
+
+	Folder string `json:"folder"`
+}
+
+type RenderReq struct {
+	Callback
+
+	Folder string `json:"folder"`
+	// Dur is the target length in seconds
+	Dur float64 `json:"dur"`
+	// TailCut seconds are cut off the end of every clip
+	TailCut float64 `json:"tailCut"`
+	// Loop repeats the clips until Dur is reached
+	Loop bool   `json:"loop"`
+	Out  string `json:"out"`
+}
+
+type MergeReq struct {
+	Callback
+
+	VideoPath string `json:"videoPath"`
+	AudioPath string `json:"audioPath"`
+	OutPath   string `json:"outPath"`
+}
+
+type GenSubtitleReq struct {
+	Callback
+
+	AudioPath  string `json:"audioPath"`
+	OutputPath string `json:"outputPath"`
+	Lang       string `json:"lang"`
+}
+
+type BrunReq struct {
+	Callback
+
+	VideoPath    string `json:"videoPath"`
+	SubtitlePath string `json:"subtitlePath"`
+	OutputPath   string `json:"outputPath"`
+}
+
+type EpisodeReq struct {
+	GenScriptReq
+
+	// TTS overrides the speech provider of the project
+	TTS string `json:"tts"`
+
+	Render EpisodeRenderReq `json:"render"`
+	Mix    EpisodeMixReq    `json:"mix"`
+	// Subtitle is nil for an episode without subtitles
+	Subtitle *EpisodeSubtitleReq `json:"subtitle,omitempty"`
+}
+
+type EpisodeRenderReq struct {
+	// Dur is the target length in seconds, 0 takes the narration length
+	Dur     float64 `json:"dur"`
+	TailCut float64 `json:"tailCut"`
+	Loop    bool    `json:"loop"`
+}
+
+type EpisodeMixReq struct {
+	// BGM is a path relative to the project folder
+	BGM string `json:"bgm"`
+	// Volume of the BGM, 0 takes 0.18
+	Volume float64 `json:"volume"`
+	Loop   bool    `json:"loop"`
+}
+
+type EpisodeSubtitleReq struct {
+	Lang string `json:"lang"`
+	// Burn writes the subtitles into the video
+	Burn bool `json:"burn"`
+}
+
+type JobReq struct {
+	Tasks []JobTaskReq `json:"tasks"`
+}
+
+type JobTaskReq struct {
+	Callback
+
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// names of other tasks in the job or ids of earlier tasks
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// payload of the task type, strings may use ${name.outputs[N]}
+	Payload json.RawMessage `json:"payload"`
+}
+
+type ScheduleReq struct {
+	Callback
+
+	Name    string          `json:"name"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+
+	// exactly one of cron and runAt, cron is evaluated in server local time
+	Cron  string     `json:"cron,omitempty"`
+	RunAt *time.Time `json:"runAt,omitempty"`
+
+	Paused bool `json:"paused"`
+}
+
+// ListTasksReq filters Tasks, an empty field matches every task
+type ListTasksReq struct {
+	Type  string
+	State string
+}
+
+// answers
+
+// ErrorResp is the body of every error response
+type ErrorResp struct {
+	Error  string `json:"error"`
+	Detail string `json:"detail,omitempty"`
+	// Fields lists every field of an invalid request
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError names one invalid field of a request, Field is the json or
+// form name with the path of nested objects, e.g. "render.dur"
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// TaskAccepted answers a submission, Duplicate is set when the
+// Idempotency-Key matched an earlier task
+type TaskAccepted struct {
+	TaskID    string `json:"taskID"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+// JobAccepted maps every task name of a job to its task ID
+type JobAccepted struct {
+	Tasks map[string]string `json:"tasks"`
+}
+
+type TaskList struct {
+	Tasks []TaskStatus `json:"tasks"`
+}
+
+type ProjectList struct {
+	Projects []Project `json:"projects"`
+}
+
+type AssetList struct {
+	Assets []Asset `json:"assets"`
+}
+
+type NarrationList struct {
+	Narrations []Narration `json:"narrations"`
+}
+
+type ScheduleList struct {
+	Schedules []Schedule `json:"schedules"`
+}
+
+// UsageResp is what the calling key used this month, Reset is when the
+// month ends
+type UsageResp struct {
+	Key   string    `json:"key"`
+	Scope Scope     `json:"scope"`
+	Usage Usage     `json:"usage"`
+	Quota Quota     `json:"quota"`
+	Reset time.Time `json:"reset"`
+}
+
+type Scope string
+
+const (
+	ScopeRead   Scope = "read"
+	ScopeSubmit Scope = "submit"
+	ScopeAdmin  Scope = "admin"
+)
+
+type Usage struct {
+	LLMCalls      int64   `json:"llmCalls"`
+	TTSChars      int64   `json:"ttsChars"`
+	RenderMinutes float64 `json:"renderMinutes"`
+}
+
+// Quota caps the usage of a key per calendar month, a zero field is
+// unlimited
+type Quota Usage
+
+// projects
+
+type Project struct {
+	Version int    `json:"v"`
+	Name    string `json:"name"`
+
+	Subject  string `json:"subject,omitempty"`
+	Language string `json:"language,omitempty"`
+	Voice    string `json:"voice,omitempty"`
+	TTS      string `json:"tts,omitempty"`
+	Prompt   Prompt `json:"prompt"`
+
+	Artifacts []Artifact `json:"artifacts"`
+	Assets    []Asset    `json:"assets,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Prompt keeps the script settings of a project
+type Prompt struct {
+	Model    string `json:"model,omitempty"`
+	Segments int    `json:"segments,omitempty"`
+	MinChars int    `json:"minChars,omitempty"`
+	MaxChars int    `json:"maxChars,omitempty"`
+	Focus    string `json:"focus,omitempty"`
+	Hook     string `json:"hook,omitempty"`
+}
+
+// Artifact is a file a task wrote into the project
+type Artifact struct {
+	// Path is relative to the project folder
+	Path      string    `json:"path"`
+	Kind      string    `json:"kind"`
+	TaskID    string    `json:"taskId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type AssetKind string
+
+const (
+	AssetVideo AssetKind = "video"
+	AssetStill AssetKind = "still"
+	AssetBGM   AssetKind = "bgm"
+)
+
+type Asset struct {
+	Kind AssetKind `json:"kind"`
+	Name string    `json:"name"`
+	// Path is relative to the project folder
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+
+	// Media is nil for a file copied in by hand that was never probed
+	Media *MediaInfo `json:"media,omitempty"`
+
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// MediaInfo is what ffprobe found in a file
+type MediaInfo struct {
+	Format   string  `json:"format"`
+	Duration float64 `json:"duration"`
+
+	VideoCodec string  `json:"videoCodec,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	FPS        float64 `json:"fps,omitempty"`
+
+	AudioCodec string `json:"audioCodec,omitempty"`
+	SampleRate int    `json:"sampleRate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+}
+
+type NarrationStatus string
+
+const (
+	NarrationPending     NarrationStatus = "pending"
+	NarrationSynthesized NarrationStatus = "synthesized"
+	NarrationFailed      NarrationStatus = "failed"
+)
+
+type Narration struct {
+	Version int    `json:"v"`
+	ID      string `json:"id"`
+	Text    string `json:"text"`
+
+	// AudioID names audio/<AudioID>.wav, empty until synthesized
+	AudioID string `json:"audio_id"`
+	// Duration of the audio in seconds
+	Duration float64         `json:"duration,omitempty"`
+	Voice    string          `json:"voice,omitempty"`
+	Status   NarrationStatus `json:"status"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Meta map[string]any `json:"meta,omitempty"`
+}
+
+// tasks
+
+type TaskType string
+
+const (
+	GenScript    TaskType = "script.gen"
+	GenTTSAll    TaskType = "tts.all.gen"
+	GenTTSSingle TaskType = "tts.single.gen"
+	Mixdown      TaskType = "mix.audio.bgm"
+	Concat       TaskType = "concat.wav"
+	Render       TaskType = "render.mp4"
+	Merge        TaskType = "m4a.merge.mp4"
+	GenSrt       TaskType = "audio.gen.srt"
+	Brun         TaskType = "mp4.brun.sub"
+	EpisodeBuild TaskType = "episode.build"
+)
+
+type TaskState string
+
+const (
+	TaskQueued    TaskState = "queued"
+	TaskWaiting   TaskState = "waiting"
+	TaskRunning   TaskState = "running"
+	TaskSucceeded TaskState = "succeeded"
+	TaskFailed    TaskState = "failed"
+	TaskCancelled TaskState = "cancelled"
+	// TaskInterrupted was running when the server stopped, it is queued
+	// again on the next start
+	TaskInterrupted TaskState = "interrupted"
+)
+
+// Done reports whether the task will not change state anymore
+func (s TaskState) Done() bool {
+	return s == TaskSucceeded || s == TaskFailed || s == TaskCancelled
+}
+
+// Priority orders the queue, -1 is low, 0 normal and 1 high
+type Priority int
+
+type TaskStatus struct {
+	ID      string    `json:"id"`
+	Type    TaskType  `json:"type"`
+	State   TaskState `json:"state"`
+	Error   string    `json:"error,omitempty"`
+	Outputs []string  `json:"outputs,omitempty"`
+
+	// Stage is the running stage of a pipeline task
+	Stage string `json:"stage,omitempty"`
+
+	Priority Priority `json:"priority"`
+
+	// Progress of the running ffmpeg command
+	Progress *Progress `json:"progress,omitempty"`
+
+	DependsOn []string          `json:"dependsOn,omitempty"`
+	Refs      map[string]string `json:"refs,omitempty"`
+
+	Attempts      int    `json:"attempts"`
+	DeadLetter    bool   `json:"deadLetter,omitempty"`
+	ResubmittedAs string `json:"resubmittedAs,omitempty"`
+
+	Callback *CallbackStatus `json:"callback,omitempty"`
+
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	PayloadHash    string `json:"payloadHash,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+type CallbackStatus struct {
+	URL       string            `json:"url"`
+	Delivered bool              `json:"delivered,omitempty"`
+	GaveUp    bool              `json:"gaveUp,omitempty"`
+	Attempts  []CallbackAttempt `json:"attempts,omitempty"`
+}
+
+type CallbackAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type Progress struct {
+	// OutTime is the position written so far in seconds
+	OutTime float64 `json:"outTime"`
+	// Speed is the encoding speed relative to realtime, 0 when unknown
+	Speed float64 `json:"speed,omitempty"`
+	Frame int64   `json:"frame,omitempty"`
+
+	// Percent is OutTime against the target duration, -1 when the duration
+	// is unknown
+	Percent float64 `json:"percent"`
+	Done    bool    `json:"done,omitempty"`
+}
+
+type EventType string
+
+const (
+	EventState    EventType = "state"
+	EventStage    EventType = "stage"
+	EventProgress EventType = "progress"
+	// EventSegment reports a synthesized or reused TTS segment
+	EventSegment EventType = "segment"
+	EventOutput  EventType = "output"
+)
+
+type Event struct {
+	Type     EventType `json:"type"`
+	TaskID   string    `json:"taskId"`
+	TaskType TaskType  `json:"taskType"`
+
+	State    TaskState `json:"state,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Stage    string    `json:"stage,omitempty"`
+	Progress *Progress `json:"progress,omitempty"`
+	Segment  string    `json:"segment,omitempty"`
+	Outputs  []string  `json:"outputs,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// schedules
+
+type Schedule struct {
+	ID      string          `json:"id"`
+	Name    string          `json:"name,omitempty"`
+	Type    TaskType        `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+
+	Cron  string     `json:"cron,omitempty"`
+	RunAt *time.Time `json:"runAt,omitempty"`
+
+	CallbackURL string `json:"callbackUrl,omitempty"`
+
+	Paused bool `json:"paused"`
+
+	// NextRun is nil for a paused schedule and a one-shot that already ran
+	NextRun    *time.Time `json:"nextRun,omitempty"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastTaskID string     `json:"lastTaskId,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	Runs       int        `json:"runs"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
+	"time"
 
 	"comp0ser/internal/app"
+	"comp0ser/internal/auth"
 	"comp0ser/internal/logging"
 )
 
@@ -17,6 +21,22 @@ var (
 	whisperBin, whisperModel string
 
 	port string
+
+	resumeInterrupted, ttsStream bool
+
+	workerCount int
+	concurrency string
+	priorities  string
+
+	callbackSecret, callbackHosts string
+
+	ttsProvider                                                string
+	openAITTSURL, openAITTSKey, openAITTSModel, openAITTSVoice string
+	localTTS, localTTSBin, localTTSModel                       string
+
+	apiKeysFile, hashKey string
+
+	drainTimeout time.Duration
 )
 
 func main() {
@@ -27,11 +47,34 @@ func main() {
 	flag.StringVar(&storeDir, "store_dir", envOr("STORE_DIR", "/store"), "file local store dir")
 	flag.StringVar(&port, "port", envOr("SERVER_PORT", "8088"), "http server port")
 	flag.StringVar(&voiceType, "voice_type", envOr("VOICE_TYPE", ""), "volc tts voice type field")
+	flag.BoolVar(&ttsStream, "tts_stream", envOr("TTS_STREAM", "false") == "true", "synthesize over the volc websocket stream")
+	flag.StringVar(&ttsProvider, "tts_provider", envOr("TTS_PROVIDER", "volc"), "default tts provider: volc|openai|local")
+	flag.StringVar(&openAITTSURL, "openai_tts_url", envOr("OPENAI_TTS_URL", ""), "openai compatible /v1/audio/speech url")
+	flag.StringVar(&openAITTSKey, "openai_tts_key", envOr("OPENAI_API_KEY", ""), "openai compatible tts api key")
+	flag.StringVar(&openAITTSModel, "openai_tts_model", envOr("OPENAI_TTS_MODEL", ""), "openai compatible tts model, default tts-1")
+	flag.StringVar(&openAITTSVoice, "openai_tts_voice", envOr("OPENAI_TTS_VOICE", ""), "openai compatible tts voice, default alloy")
+	flag.StringVar(&localTTS, "local_tts", envOr("LOCAL_TTS", ""), "offline tts engine: piper|espeak-ng")
+	flag.StringVar(&localTTSBin, "local_tts_bin", envOr("LOCAL_TTS_BIN", ""), "offline tts bin path, defaults to the engine name")
+	flag.StringVar(&localTTSModel, "local_tts_model", envOr("LOCAL_TTS_MODEL", ""), "piper .onnx model or espeak-ng voice")
 	flag.StringVar(&tmpRoot, "tmp_root", envOr("TMP_ROOT", "/tmp/comp0ser"), "temp file root")
 	flag.StringVar(&whisperBin, "whisper_bin", envOr("WHISPER_BIN", ""), "whisper bin path")
 	flag.StringVar(&whisperModel, "whisper_model", envOr("WHISPER_MODEL", ""), "whisper model path")
+	flag.BoolVar(&resumeInterrupted, "resume_interrupted", envOr("RESUME_INTERRUPTED", "false") == "true", "re-run tasks interrupted by the last shutdown")
+	flag.IntVar(&workerCount, "workers", envInt("WORKER_COUNT", 0), "number of tasks run at once")
+	flag.StringVar(&concurrency, "concurrency", envOr("TASK_CONCURRENCY", ""), "per task type caps, e.g. render.mp4=1,tts.all.gen=8")
+	flag.StringVar(&priorities, "priorities", envOr("TASK_PRIORITIES", ""), "per task type queue priorities, e.g. render.mp4=low,tts.single.gen=high")
+	flag.StringVar(&callbackSecret, "callback_secret", envOr("CALLBACK_SECRET", ""), "hmac key of completion webhook signatures")
+	flag.StringVar(&callbackHosts, "callback_hosts", envOr("CALLBACK_HOSTS", ""), "hosts completion webhooks may go to, e.g. hooks.example.com,.example.org, empty allows any")
+	flag.StringVar(&apiKeysFile, "api_keys", envOr("API_KEYS_FILE", ""), "json file of api keys, empty disables authentication")
+	flag.StringVar(&hashKey, "hash_key", "", "print the hash of an api key for the keys file and exit")
+	flag.DurationVar(&drainTimeout, "drain_timeout", envDuration("DRAIN_TIMEOUT", 30*time.Minute), "how long a shutdown waits for running tasks")
 	flag.Parse()
 
+	if hashKey != "" {
+		fmt.Println(auth.Hash(hashKey))
+		return
+	}
+
 	logger := logging.NewLogger(logLevel, logMode)
 	slog.SetDefault(logger)
 
@@ -43,9 +86,28 @@ func main() {
 		StoreDir:     storeDir,
 		Port:         port,
 		VoiceType:    voiceType,
+		TTSStream:    ttsStream,
 		TmpRoot:      tmpRoot,
 		WhisperBin:   whisperBin,
 		WhisperModel: whisperModel,
+
+		TTSProvider:    ttsProvider,
+		OpenAITTSURL:   openAITTSURL,
+		OpenAITTSKey:   openAITTSKey,
+		OpenAITTSModel: openAITTSModel,
+		OpenAITTSVoice: openAITTSVoice,
+		LocalTTS:       localTTS,
+		LocalTTSBin:    localTTSBin,
+		LocalTTSModel:  localTTSModel,
+
+		ResumeInterrupted: resumeInterrupted,
+		WorkerCount:       workerCount,
+		Concurrency:       concurrency,
+		Priorities:        priorities,
+		CallbackSecret:    callbackSecret,
+		CallbackHosts:     callbackHosts,
+		APIKeysFile:       apiKeysFile,
+		DrainTimeout:      drainTimeout,
 	}); err != nil {
 		slog.Error("application exit",
 			"err", err,
@@ -59,3 +121,17 @@ func envOr(key, def string) string {
 	}
 	return def
 }
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
@@ -6,13 +6,17 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime/debug"
 	"syscall"
 	"time"
 
+	"comp0ser/internal/auth"
 	"comp0ser/internal/cmd"
 	"comp0ser/internal/filestore"
 	"comp0ser/internal/llm"
+	"comp0ser/internal/sandbox"
+	"comp0ser/internal/scheduler"
 	"comp0ser/internal/server"
 	"comp0ser/internal/tts"
 	"comp0ser/internal/worker"
@@ -23,6 +27,23 @@ type Options struct {
 	LLMAPIKey string
 	TTSAPIKey string
 	VoiceType string
+	// TTSStream synthesizes over volc's websocket protocol, which streams
+	// long segments to disk instead of holding one http response
+	TTSStream bool
+
+	// TTSProvider is the speech provider of projects that name none:
+	// volc, openai or local
+	TTSProvider string
+	// the openai compatible provider is registered when a url or key is set
+	OpenAITTSURL   string
+	OpenAITTSKey   string
+	OpenAITTSModel string
+	OpenAITTSVoice string
+	// LocalTTS is the offline engine, piper or espeak-ng, empty leaves the
+	// local provider out
+	LocalTTS      string
+	LocalTTSBin   string
+	LocalTTSModel string
 
 	Model string
 
@@ -32,6 +53,31 @@ type Options struct {
 	WhisperBin   string
 	WhisperModel string
 
+	// ResumeInterrupted re-runs tasks that were running when the server last stopped
+	ResumeInterrupted bool
+
+	// WorkerCount is the number of tasks run at once, 0 keeps the default
+	WorkerCount int
+	// Concurrency caps running tasks per type, e.g. "render.mp4=1,tts.all.gen=8"
+	Concurrency string
+	// Priorities overrides the queue priority per type, e.g.
+	// "render.mp4=low,tts.single.gen=high"
+	Priorities string
+
+	// CallbackSecret signs completion webhooks
+	CallbackSecret string
+	// CallbackHosts allow-lists the hosts of completion webhooks, e.g.
+	// "hooks.example.com,.example.org", empty allows every host
+	CallbackHosts string
+
+	// APIKeysFile lists the api keys clients authenticate with, empty leaves
+	// the server open
+	APIKeysFile string
+
+	// DrainTimeout bounds how long a shutdown waits for running tasks before
+	// requeueing them
+	DrainTimeout time.Duration
+
 	Port string
 }
 
@@ -58,14 +104,6 @@ func run(ctx context.Context, opts Options) error {
 		return fmt.Errorf("create gemini client: %w", err)
 	}
 
-	ttsClient, err := tts.NewClient(
-		tts.WithAPIKey(opts.TTSAPIKey),
-		tts.WithVoiceType(opts.VoiceType),
-	)
-	if err != nil {
-		return fmt.Errorf("create tts client: %w", err)
-	}
-
 	fs := filestore.NewFileLocalStore(opts.StoreDir)
 	slog.Info("file local store init",
 		"dir", opts.StoreDir,
@@ -83,30 +121,200 @@ func run(ctx context.Context, opts Options) error {
 		Timeout: 60 * time.Minute,
 	}
 
-	wk := worker.New(worker.Config{
-		FS:       fs,
-		FF:       ff,
-		LLM:      llmClient,
-		TTS:      ttsClient,
-		Renderer: renderer,
-		Runner:   runner,
-		Whisper:  whisper,
-	})
+	concurrency, err := worker.ParseConcurrency(opts.Concurrency)
+	if err != nil {
+		return err
+	}
+	priorities, err := worker.ParsePriorities(opts.Priorities)
+	if err != nil {
+		return err
+	}
+
+	// makes the store dir and tmp root as well
+	sb, err := sandbox.New(opts.StoreDir, opts.TmpRoot)
+	if err != nil {
+		return fmt.Errorf("init path sandbox failed: %w", err)
+	}
 
-	if err := os.MkdirAll(opts.TmpRoot, 0o755); err != nil {
-		return fmt.Errorf("init tmp root failed: %w", err)
+	ttsProviders, err := newTTS(opts, runner)
+	if err != nil {
+		return fmt.Errorf("create tts providers: %w", err)
 	}
 
-	defer func() {
-		_ = os.RemoveAll(opts.TmpRoot)
-	}()
+	stateDir := filepath.Join(opts.StoreDir, ".comp0ser")
+
+	if opts.CallbackSecret == "" {
+		slog.Warn("no callback secret configured, completion webhooks are sent unsigned")
+	}
+	callbackHosts := worker.ParseCallbackHosts(opts.CallbackHosts)
+	if len(callbackHosts) == 0 {
+		slog.Warn("no callback hosts configured, completion webhooks may go to any host")
+	}
+
+	var keys *auth.Keyring
+	if opts.APIKeysFile != "" {
+		if keys, err = auth.Load(opts.APIKeysFile, stateDir); err != nil {
+			return fmt.Errorf("load api keys: %w", err)
+		}
+	} else {
+		slog.Warn("no api keys configured, every client has full access")
+	}
+
+	wk, err := worker.New(worker.Config{
+		WorkerCount:       opts.WorkerCount,
+		Concurrency:       concurrency,
+		Priorities:        priorities,
+		FS:                fs,
+		FF:                ff,
+		LLM:               llmClient,
+		TTS:               ttsProviders,
+		Renderer:          renderer,
+		Runner:            runner,
+		Whisper:           whisper,
+		StateDir:          stateDir,
+		ResumeInterrupted: opts.ResumeInterrupted,
+		CallbackSecret:    opts.CallbackSecret,
+		CallbackHosts:     callbackHosts,
+		Sandbox:           sb,
+	})
+	if err != nil {
+		return fmt.Errorf("create worker: %w", err)
+	}
+	wk.Start()
+
+	sched, err := scheduler.New(stateDir, wk)
+	if err != nil {
+		return fmt.Errorf("create scheduler: %w", err)
+	}
+	schedCtx, stopSched := context.WithCancel(ctx)
+	defer stopSched()
+	go sched.Run(schedCtx)
 
 	srv, err := server.New(opts.Port)
 	if err != nil {
+		wk.Shutdown()
 		return fmt.Errorf("server.New: %w", err)
 	}
 	slog.Info("server listening", "port", opts.Port)
 
-	srv.ServerHTTPHandler(ctx, server.Routes(ctx, wk, opts.TmpRoot))
-	return nil
+	// the server outlives ctx so that status requests keep working and new
+	// submissions get a 503 while the worker drains
+	srvCtx, stopServer := context.WithCancel(context.Background())
+	defer stopServer()
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- srv.ServerHTTPHandler(srvCtx, server.Routes(ctx, server.Deps{
+			FS:        fs,
+			Auth:      keys,
+			Probe:     cmd.Probe,
+			Sandbox:   sb,
+			TTS:       ttsProviders,
+			Worker:    wk,
+			Scheduler: sched,
+			TmpRoot:   opts.TmpRoot,
+
+			CallbackHosts: callbackHosts,
+		}))
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err = <-srvErr:
+		// the server died on its own, drain right away
+		srvErr <- err
+	}
+
+	slog.Info("draining", "timeout", opts.DrainTimeout)
+	stopSched()
+	sched.Wait()
+	drainCtx, cancel := context.WithTimeout(context.Background(), opts.DrainTimeout)
+	if err := wk.Drain(drainCtx); err != nil {
+		slog.Warn("drain deadline reached, running tasks were requeued", "err", err)
+	}
+	cancel()
+
+	stopServer()
+	err = <-srvErr
+
+	cleanTmpRoot(opts.TmpRoot, wk)
+	return err
+}
+
+// cleanTmpRoot removes the uploads of finished tasks, it keeps the directory
+// when a requeued task still needs its inputs on the next start
+func cleanTmpRoot(dir string, wk worker.Worker) {
+	pending := 0
+	for _, st := range wk.List(worker.TaskFilter{}) {
+		if !st.State.Done() {
+			pending++
+		}
+	}
+	if pending > 0 {
+		slog.Info("tmp root kept for unfinished tasks",
+			"dir", dir,
+			"tasks", pending,
+		)
+		return
+	}
+	_ = os.RemoveAll(dir)
+}
+
+// newTTS registers volc and whichever other providers are configured
+func newTTS(opts Options, runner *cmd.Runner) (*tts.Registry, error) {
+	def := opts.TTSProvider
+	if def == "" {
+		def = tts.ProviderVolc
+	}
+	reg := tts.NewRegistry(def)
+
+	volcOpts := []tts.Option{
+		tts.WithAPIKey(opts.TTSAPIKey),
+		tts.WithVoiceType(opts.VoiceType),
+	}
+	var volc tts.Client
+	var err error
+	if opts.TTSStream {
+		volc, err = tts.NewStreamClient(volcOpts...)
+	} else {
+		volc, err = tts.NewClient(volcOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	reg.Register(tts.ProviderVolc, volc)
+
+	if opts.OpenAITTSURL != "" || opts.OpenAITTSKey != "" {
+		openaiOpts := []tts.Option{tts.WithAPIKey(opts.OpenAITTSKey)}
+		if opts.OpenAITTSURL != "" {
+			openaiOpts = append(openaiOpts, tts.WithEndpoint(opts.OpenAITTSURL))
+		}
+		if opts.OpenAITTSModel != "" {
+			openaiOpts = append(openaiOpts, tts.WithModel(opts.OpenAITTSModel))
+		}
+		if opts.OpenAITTSVoice != "" {
+			openaiOpts = append(openaiOpts, tts.WithVoiceType(opts.OpenAITTSVoice))
+		}
+		openai, err := tts.NewOpenAIClient(openaiOpts...)
+		if err != nil {
+			return nil, err
+		}
+		reg.Register(tts.ProviderOpenAI, openai)
+	}
+
+	if opts.LocalTTS != "" {
+		if opts.LocalTTS != cmd.EnginePiper && opts.LocalTTS != cmd.EngineEspeak {
+			return nil, fmt.Errorf("unknown local tts engine %q", opts.LocalTTS)
+		}
+		speech := cmd.NewSpeech(opts.LocalTTS, opts.LocalTTSBin, opts.LocalTTSModel)
+		reg.Register(tts.ProviderLocal, tts.NewLocalClient(speech, runner, opts.TmpRoot))
+	}
+
+	if _, err := reg.Client(""); err != nil {
+		return nil, fmt.Errorf("default provider: %w", err)
+	}
+	slog.Info("tts providers",
+		"providers", reg.Names(),
+		"default", def,
+	)
+	return reg, nil
 }
@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const usageFile = "usage.json"
+
+var (
+	ErrBadKeys       = errors.New("bad api keys")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// Scope is what a key may do, every scope includes the ones below it
+type Scope string
+
+const (
+	ScopeRead   Scope = "read"
+	ScopeSubmit Scope = "submit"
+	ScopeAdmin  Scope = "admin"
+)
+
+var scopeRank = map[Scope]int{
+	ScopeRead:   1,
+	ScopeSubmit: 2,
+	ScopeAdmin:  3,
+}
+
+func (s Scope) Valid() bool {
+	_, ok := scopeRank[s]
+	return ok
+}
+
+// Allows reports whether a key of scope s may do what need requires
+func (s Scope) Allows(need Scope) bool {
+	return scopeRank[s] >= scopeRank[need]
+}
+
+// Usage counts the paid resources a request consumes
+type Usage struct {
+	LLMCalls      int64   `json:"llmCalls"`
+	TTSChars      int64   `json:"ttsChars"`
+	RenderMinutes float64 `json:"renderMinutes"`
+}
+
+func (u Usage) IsZero() bool {
+	return u == Usage{}
+}
+
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		LLMCalls:      u.LLMCalls + o.LLMCalls,
+		TTSChars:      u.TTSChars + o.TTSChars,
+		RenderMinutes: u.RenderMinutes + o.RenderMinutes,
+	}
+}
+
+func (u Usage) Sub(o Usage) Usage {
+	return Usage{
+		LLMCalls:      max(u.LLMCalls-o.LLMCalls, 0),
+		TTSChars:      max(u.TTSChars-o.TTSChars, 0),
+		RenderMinutes: max(u.RenderMinutes-o.RenderMinutes, 0),
+	}
+}
+
+// Quota caps the usage of a key per calendar month (UTC), a zero field is
+// unlimited
+type Quota Usage
+
+// exceeded names the first resource u goes over q on
+func (q Quota) exceeded(u Usage) string {
+	switch {
+	case q.LLMCalls > 0 && u.LLMCalls > q.LLMCalls:
+		return "llmCalls"
+	case q.TTSChars > 0 && u.TTSChars > q.TTSChars:
+		return "ttsChars"
+	case q.RenderMinutes > 0 && u.RenderMinutes > q.RenderMinutes:
+		return "renderMinutes"
+	}
+	return ""
+}
+
+// Key is a configured client, only the sha256 of its secret is kept
+type Key struct {
+	Name  string `json:"name"`
+	Hash  string `json:"hash"`
+	Scope Scope  `json:"scope"`
+	Quota Quota  `json:"quota"`
+}
+
+// QuotaError tells which resource a charge would overrun
+type QuotaError struct {
+	Key      string
+	Resource string
+	Used     Usage
+	Quota    Quota
+	// Reset is when the current period ends
+	Reset time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("key %s is out of %s until %s", e.Key, e.Resource, e.Reset.Format(time.RFC3339))
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Hash returns the form a secret is stored in the keys file
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Keyring holds the keys of a keys file and what each of them used this
+// month, the usage is kept in <dir>/usage.json
+type Keyring struct {
+	mu     sync.Mutex
+	byHash map[string]*Key
+	path   string
+
+	period string
+	usage  map[string]Usage
+
+	now func() time.Time
+}
+
+type usageState struct {
+	Period string           `json:"period"`
+	Usage  map[string]Usage `json:"usage"`
+}
+
+// Load reads the keys file, a json list of keys, and the usage of the
+// current month from dir
+func Load(keysFile, dir string) (*Keyring, error) {
+	raw, err := os.ReadFile(keysFile)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrBadKeys, keysFile, err)
+	}
+	k, err := New(keys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keysFile, err)
+	}
+
+	slog.Info("api keys loaded",
+		"path", keysFile,
+		"keys", len(keys),
+	)
+	return k, nil
+}
+
+func New(keys []Key, dir string) (*Keyring, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	k := &Keyring{
+		byHash: make(map[string]*Key, len(keys)),
+		path:   filepath.Join(dir, usageFile),
+		usage:  make(map[string]Usage),
+		now:    time.Now,
+	}
+
+	names := make(map[string]bool, len(keys))
+	for i := range keys {
+		key := &keys[i]
+		key.Hash = strings.ToLower(strings.TrimPrefix(key.Hash, "sha256:"))
+		switch {
+		case key.Name == "":
+			return nil, fmt.Errorf("%w: key %d has no name", ErrBadKeys, i)
+		case names[key.Name]:
+			return nil, fmt.Errorf("%w: duplicate key name %s", ErrBadKeys, key.Name)
+		case len(key.Hash) != sha256.Size*2:
+			return nil, fmt.Errorf("%w: key %s: want a hex sha256 hash", ErrBadKeys, key.Name)
+		case !key.Scope.Valid():
+			return nil, fmt.Errorf("%w: key %s: unknown scope %q", ErrBadKeys, key.Name, key.Scope)
+		}
+		if _, err := hex.DecodeString(key.Hash); err != nil {
+			return nil, fmt.Errorf("%w: key %s: want a hex sha256 hash", ErrBadKeys, key.Name)
+		}
+		if _, ok := k.byHash[key.Hash]; ok {
+			return nil, fmt.Errorf("%w: key %s reuses a secret", ErrBadKeys, key.Name)
+		}
+		names[key.Name] = true
+		k.byHash[key.Hash] = key
+	}
+
+	raw, err := os.ReadFile(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st usageState
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return nil, fmt.Errorf("bad %s: %w", k.path, err)
+	}
+	k.period = st.Period
+	if st.Usage != nil {
+		k.usage = st.Usage
+	}
+	return k, nil
+}
+
+// Lookup finds the key of a secret
+func (k *Keyring) Lookup(secret string) (*Key, bool) {
+	if secret == "" {
+		return nil, false
+	}
+	key, ok := k.byHash[Hash(secret)]
+	return key, ok
+}
+
+// Charge adds u to the usage of key, a charge that would overrun the quota
+// is not taken and returns a *QuotaError
+func (k *Keyring) Charge(key *Key, u Usage) error {
+	if u.IsZero() {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.rollover()
+	next := k.usage[key.Name].Add(u)
+	if res := key.Quota.exceeded(next); res != "" {
+		return &QuotaError{
+			Key:      key.Name,
+			Resource: res,
+			Used:     k.usage[key.Name],
+			Quota:    key.Quota,
+			Reset:    k.periodEnd(),
+		}
+	}
+	k.usage[key.Name] = next
+	k.persist()
+	return nil
+}
+
+// Refund gives back a charge whose request did not go through
+func (k *Keyring) Refund(key *Key, u Usage) {
+	if u.IsZero() {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.rollover()
+	k.usage[key.Name] = k.usage[key.Name].Sub(u)
+	k.persist()
+}
+
+// Usage returns what key used this month and when the month ends
+func (k *Keyring) Usage(key *Key) (Usage, time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.rollover()
+	return k.usage[key.Name], k.periodEnd()
+}
+
+// rollover starts from zero once the month changed
+func (k *Keyring) rollover() {
+	period := k.now().UTC().Format("2006-01")
+	if period != k.period {
+		k.period = period
+		k.usage = make(map[string]Usage)
+	}
+}
+
+func (k *Keyring) periodEnd() time.Time {
+	now := k.now().UTC()
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (k *Keyring) persist() {
+	raw, err := json.MarshalIndent(usageState{Period: k.period, Usage: k.usage}, "", "  ")
+	if err == nil {
+		tmp := k.path + ".tmp"
+		if err = os.WriteFile(tmp, raw, 0o644); err == nil {
+			err = os.Rename(tmp, k.path)
+		}
+	}
+	if err != nil {
+		slog.Warn("persist api key usage failed",
+			"path", k.path,
+			"err", err,
+		)
+	}
+}
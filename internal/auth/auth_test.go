@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyring_Lookup(t *testing.T) {
+	dir := t.TempDir()
+	keysFile := filepath.Join(dir, "keys.json")
+	body := `[
+		{"name": "ops", "hash": "sha256:` + Hash("ops-secret") + `", "scope": "admin"},
+		{"name": "dash", "hash": "` + Hash("dash-secret") + `", "scope": "read"}
+	]`
+	if err := os.WriteFile(keysFile, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := Load(keysFile, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, ok := k.Lookup("dash-secret")
+	if !ok || key.Name != "dash" {
+		t.Fatalf("lookup dash: %v %v", key, ok)
+	}
+	if key.Scope.Allows(ScopeSubmit) || !key.Scope.Allows(ScopeRead) {
+		t.Fatalf("read scope allows the wrong things")
+	}
+	if key, _ := k.Lookup("ops-secret"); !key.Scope.Allows(ScopeSubmit) {
+		t.Fatalf("admin scope must allow submit")
+	}
+	if _, ok := k.Lookup("nope"); ok {
+		t.Fatal("unknown secret found")
+	}
+	if _, ok := k.Lookup(""); ok {
+		t.Fatal("empty secret found")
+	}
+
+	for _, bad := range [][]Key{
+		{{Name: "a", Hash: "xyz", Scope: ScopeRead}},
+		{{Name: "a", Hash: Hash("a"), Scope: "root"}},
+		{{Name: "a", Hash: Hash("a"), Scope: ScopeRead}, {Name: "a", Hash: Hash("b"), Scope: ScopeRead}},
+		{{Name: "a", Hash: Hash("a"), Scope: ScopeRead}, {Name: "b", Hash: Hash("a"), Scope: ScopeRead}},
+	} {
+		if _, err := New(bad, dir); !errors.Is(err, ErrBadKeys) {
+			t.Errorf("%+v: want ErrBadKeys, got %v", bad, err)
+		}
+	}
+}
+
+func TestKeyring_Quota(t *testing.T) {
+	dir := t.TempDir()
+	keys := []Key{{
+		Name:  "ci",
+		Hash:  Hash("ci-secret"),
+		Scope: ScopeSubmit,
+		Quota: Quota{LLMCalls: 2, TTSChars: 1000},
+	}}
+	k, err := New(keys, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)
+	k.now = func() time.Time { return now }
+	key, _ := k.Lookup("ci-secret")
+
+	if err := k.Charge(key, Usage{LLMCalls: 1, TTSChars: 600, RenderMinutes: 90}); err != nil {
+		t.Fatal(err)
+	}
+	err = k.Charge(key, Usage{TTSChars: 500})
+	var qe *QuotaError
+	if !errors.As(err, &qe) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("want a quota error, got %v", err)
+	}
+	if qe.Resource != "ttsChars" || !qe.Reset.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected quota error: %+v", qe)
+	}
+
+	// a rejected charge takes nothing, a refund gives back
+	if err := k.Charge(key, Usage{LLMCalls: 1, TTSChars: 400}); err != nil {
+		t.Fatal(err)
+	}
+	k.Refund(key, Usage{LLMCalls: 1})
+	if u, _ := k.Usage(key); u != (Usage{LLMCalls: 1, TTSChars: 1000, RenderMinutes: 90}) {
+		t.Fatalf("usage %+v", u)
+	}
+
+	// usage survives a restart within the month
+	k2, err := New(keys, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2.now = k.now
+	key2, _ := k2.Lookup("ci-secret")
+	if u, _ := k2.Usage(key2); u.TTSChars != 1000 {
+		t.Fatalf("usage after reload %+v", u)
+	}
+
+	// and starts over the next month
+	now = now.AddDate(0, 1, 0)
+	if err := k2.Charge(key2, Usage{TTSChars: 1000}); err != nil {
+		t.Fatalf("new month: %v", err)
+	}
+}
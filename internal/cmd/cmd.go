@@ -1,13 +1,23 @@
 package cmd
 
-import "strings"
+import (
+	"io"
+	"strings"
+)
 
 type Cmd struct {
 	Bin  string
 	Args []string
+	// Stdin is fed to the command when set
+	Stdin io.Reader
 
 	Inputs  []string
 	Outputs []string
+
+	// OnProgress makes the runner pass -progress pipe:1 to ffmpeg and report
+	// every progress block, Duration in seconds is the target for Percent
+	OnProgress func(Progress)
+	Duration   float64
 }
 
 func (c *Cmd) String() string {
@@ -74,6 +74,7 @@ func (f *FFmpeg) ConcatWav(wavs []string, dir, filename string) (*Cmd, error) {
 		return nil, err
 	}
 
+	out := filepath.Join(dir, filename)
 	args := []string{
 		"-y",
 		"-hide_banner",
@@ -82,14 +83,14 @@ func (f *FFmpeg) ConcatWav(wavs []string, dir, filename string) (*Cmd, error) {
 		"-safe", "0",
 		"-i", list,
 		"-c:a", "copy",
-		filepath.Join(dir, filename),
+		out,
 	}
 
 	return &Cmd{
 		Bin:     f.Bin,
 		Args:    args,
 		Inputs:  wavs,
-		Outputs: []string{dir},
+		Outputs: []string{out},
 	}, nil
 }
 
@@ -232,7 +233,7 @@ func (f *FFmpeg) ConcatAssets(
 
 	items := make([]seg, 0, len(videos))
 	for _, v := range videos {
-		d, err := probeDurationSeconds(v)
+		d, err := ProbeDuration(v)
 		if err != nil {
 			return nil, fmt.Errorf("ffprobe failed: %s: %w", v, err)
 		}
@@ -320,14 +321,16 @@ func (f *FFmpeg) ConcatAssets(
 	)
 
 	return &Cmd{
-		Bin:     "ffmpeg",
-		Args:    args,
-		Inputs:  videos,
-		Outputs: []string{out},
+		Bin:      "ffmpeg",
+		Args:     args,
+		Inputs:   videos,
+		Outputs:  []string{out},
+		Duration: dur,
 	}, nil
 }
 
-func probeDurationSeconds(path string) (float64, error) {
+// ProbeDuration returns the duration of a media file in seconds
+func ProbeDuration(path string) (float64, error) {
 	cmd := exec.Command("ffprobe",
 		"-v", "error",
 		"-show_entries", "format=duration",
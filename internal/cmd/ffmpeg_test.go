@@ -21,3 +21,27 @@ func TestFFmpeg_Probe_Duration(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestParseProbe(t *testing.T) {
+	raw := []byte(`{
+		"streams": [
+			{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "avg_frame_rate": "30000/1001"},
+			{"codec_type": "audio", "codec_name": "aac", "sample_rate": "48000", "channels": 2}
+		],
+		"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "12.500000"}
+	}`)
+	info, err := parseProbe(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 12.5 || info.Width != 1920 || info.VideoCodec != "h264" || info.AudioCodec != "aac" || info.SampleRate != 48000 {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if info.FPS < 29.96 || info.FPS > 29.98 {
+		t.Fatalf("fps %v, want 29.97", info.FPS)
+	}
+
+	if _, err := parseProbe([]byte(`{"format": {}}`)); err == nil {
+		t.Fatal("want an error without a container format")
+	}
+}
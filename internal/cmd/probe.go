@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo is what ffprobe reports about a media file, fields of a stream
+// the file does not have stay zero
+type MediaInfo struct {
+	Format   string  `json:"format"`
+	Duration float64 `json:"duration"`
+
+	VideoCodec string  `json:"videoCodec,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	FPS        float64 `json:"fps,omitempty"`
+
+	AudioCodec string `json:"audioCodec,omitempty"`
+	SampleRate int    `json:"sampleRate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+}
+
+// Probe reads the container and first video and audio stream of path, it
+// fails for files ffprobe cannot read
+func Probe(path string) (*MediaInfo, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "format=format_name,duration:stream=codec_type,codec_name,width,height,avg_frame_rate,sample_rate,channels",
+		"-of", "json",
+		path,
+	)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe error: %v, output: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseProbe(out.Bytes())
+}
+
+func parseProbe(raw []byte) (*MediaInfo, error) {
+	var probe struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType    string `json:"codec_type"`
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			SampleRate   string `json:"sample_rate"`
+			Channels     int    `json:"channels"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, fmt.Errorf("parse ffprobe output: %w", err)
+	}
+	if probe.Format.FormatName == "" {
+		return nil, fmt.Errorf("ffprobe found no container format")
+	}
+
+	info := &MediaInfo{Format: probe.Format.FormatName}
+	// stills have no duration
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+
+	for _, st := range probe.Streams {
+		switch st.CodecType {
+		case "video":
+			if info.VideoCodec != "" {
+				continue
+			}
+			info.VideoCodec = st.CodecName
+			info.Width, info.Height = st.Width, st.Height
+			info.FPS = parseRate(st.AvgFrameRate)
+		case "audio":
+			if info.AudioCodec != "" {
+				continue
+			}
+			info.AudioCodec = st.CodecName
+			info.SampleRate, _ = strconv.Atoi(st.SampleRate)
+			info.Channels = st.Channels
+		}
+	}
+	return info, nil
+}
+
+// parseRate turns ffprobe's "30000/1001" into frames per second
+func parseRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		v, _ := strconv.ParseFloat(s, 64)
+		return v
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
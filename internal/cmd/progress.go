@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is one report of ffmpeg -progress
+type Progress struct {
+	// OutTime is the position written so far in seconds
+	OutTime float64 `json:"outTime"`
+	// Speed is the encoding speed relative to realtime, 0 when unknown
+	Speed float64 `json:"speed,omitempty"`
+	Frame int64   `json:"frame,omitempty"`
+
+	// Percent is OutTime against the target duration, -1 when the duration
+	// is unknown
+	Percent float64 `json:"percent"`
+	Done    bool    `json:"done,omitempty"`
+}
+
+// progressWriter parses the key=value blocks ffmpeg writes with
+// -progress pipe:1, every block ends with a progress=continue|end line
+type progressWriter struct {
+	duration float64
+	fn       func(Progress)
+
+	buf bytes.Buffer
+	cur Progress
+}
+
+func newProgressWriter(duration float64, fn func(Progress)) *progressWriter {
+	return &progressWriter{duration: duration, fn: fn}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// keep the partial line for the next write
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			return len(p), nil
+		}
+		w.parse(strings.TrimSpace(line))
+	}
+}
+
+func (w *progressWriter) parse(line string) {
+	key, val, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+
+	switch key {
+	case "out_time_us", "out_time_ms":
+		// out_time_ms is in microseconds as well
+		if us, err := strconv.ParseInt(val, 10, 64); err == nil && us >= 0 {
+			w.cur.OutTime = float64(us) / 1e6
+		}
+	case "out_time":
+		if d, ok := parseClock(val); ok {
+			w.cur.OutTime = d
+		}
+	case "speed":
+		if v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(val), "x"), 64); err == nil {
+			w.cur.Speed = v
+		}
+	case "frame":
+		if v, err := strconv.ParseInt(val, 10, 64); err == nil {
+			w.cur.Frame = v
+		}
+	case "progress":
+		w.cur.Done = val == "end"
+		w.cur.Percent = -1
+		if w.duration > 0 {
+			w.cur.Percent = min(100, w.cur.OutTime/w.duration*100)
+		}
+		if w.cur.Done {
+			w.cur.Percent = 100
+		}
+		w.fn(w.cur)
+	}
+}
+
+// parseClock parses HH:MM:SS.micro
+func parseClock(s string) (float64, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	return d.Seconds() + sec, true
+}
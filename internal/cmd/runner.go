@@ -31,6 +31,10 @@ func (e *RunError) Error() string {
 	return msg
 }
 
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
 func (r *Runner) Run(parent context.Context, cmd *Cmd) error {
 	if cmd == nil {
 		return errors.New("nil cmd")
@@ -47,11 +51,20 @@ func (r *Runner) Run(parent context.Context, cmd *Cmd) error {
 	}
 	defer cancel()
 
-	command := exec.CommandContext(ctx, cmd.Bin, cmd.Args...)
+	args := cmd.Args
+	if cmd.OnProgress != nil {
+		args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	}
+	command := exec.CommandContext(ctx, cmd.Bin, args...)
 
 	var stdoutBuf, stderrBuf bytes.Buffer
 	command.Stdout = &stdoutBuf
 	command.Stderr = &stderrBuf
+	command.Stdin = cmd.Stdin
+	if cmd.OnProgress != nil {
+		// stdout carries the progress stream only
+		command.Stdout = newProgressWriter(cmd.Duration, cmd.OnProgress)
+	}
 
 	err := command.Run()
 
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunner_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	r := &Runner{Timeout: time.Minute}
+	start := time.Now()
+	err := r.Run(ctx, &Cmd{Bin: "sleep", Args: []string{"10"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("child process was not killed")
+	}
+}
+
+func TestRunner_Progress(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "ffmpeg")
+	body := "#!/bin/sh\n" +
+		"[ \"$1\" = -progress ] || exit 1\n" +
+		"printf 'frame=10\\nout_time_us=5000000\\nspeed=2.5x\\nprogress=continue\\n'\n" +
+		"printf 'frame=20\\nout_time=00:00:10.000000\\nspeed=N/A\\nprogress=end\\n'\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Progress
+	r := &Runner{}
+	err := r.Run(context.Background(), &Cmd{
+		Bin:        script,
+		Args:       []string{"-y", "out.mp4"},
+		Duration:   20,
+		OnProgress: func(p Progress) { got = append(got, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Progress{
+		{OutTime: 5, Speed: 2.5, Frame: 10, Percent: 25},
+		{OutTime: 10, Speed: 2.5, Frame: 20, Percent: 100, Done: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("progress %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestProgressWriter_UnknownDuration(t *testing.T) {
+	var last Progress
+	w := newProgressWriter(0, func(p Progress) { last = p })
+	// blocks may be split across writes
+	w.Write([]byte("out_time_us=1500"))
+	w.Write([]byte("000\nprogress=continue\n"))
+	if last.OutTime != 1.5 || last.Percent != -1 {
+		t.Fatalf("got %+v", last)
+	}
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// offline text to speech engines
+const (
+	EnginePiper  = "piper"
+	EngineEspeak = "espeak-ng"
+)
+
+// Speech drives an offline text to speech engine, both read the text from
+// stdin and write a wav
+type Speech struct {
+	Engine string
+	Bin    string
+	// Model is the voice, the .onnx model of piper or the voice name of
+	// espeak-ng
+	Model string
+}
+
+func NewSpeech(engine, bin, model string) *Speech {
+	if bin == "" {
+		bin = engine
+	}
+	return &Speech{
+		Engine: engine,
+		Bin:    bin,
+		Model:  model,
+	}
+}
+
+// Synthesize speaks text with voice, an empty voice is Model. A piper voice
+// names a model next to Model, "en_US-amy-medium" stands for
+// en_US-amy-medium.onnx in its directory
+func (s *Speech) Synthesize(text, voice, outWavPath string) (*Cmd, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("text is empty")
+	}
+	if outWavPath == "" {
+		return nil, fmt.Errorf("outWavPath is empty")
+	}
+
+	model := s.Model
+	if voice != "" {
+		// the voice comes from a client, it is a name and not a flag or path
+		if strings.HasPrefix(voice, "-") || strings.HasPrefix(voice, ".") || strings.ContainsAny(voice, `/\`) {
+			return nil, fmt.Errorf("bad voice %q", voice)
+		}
+		model = voice
+		if s.Engine == EnginePiper {
+			model = filepath.Join(filepath.Dir(s.Model), strings.TrimSuffix(voice, ".onnx")+".onnx")
+		}
+	}
+
+	var args []string
+	var inputs []string
+	switch s.Engine {
+	case EnginePiper:
+		if s.Model == "" {
+			return nil, fmt.Errorf("piper needs a model")
+		}
+		args = []string{"--model", model, "--output_file", outWavPath}
+		inputs = append(inputs, model)
+	case EngineEspeak:
+		if model != "" {
+			args = append(args, "-v", model)
+		}
+		args = append(args, "-w", outWavPath, "--stdin")
+	default:
+		return nil, fmt.Errorf("unknown speech engine %q", s.Engine)
+	}
+
+	return &Cmd{
+		Bin:     s.Bin,
+		Args:    args,
+		Stdin:   strings.NewReader(text),
+		Inputs:  inputs,
+		Outputs: []string{outWavPath},
+	}, nil
+}
@@ -0,0 +1,286 @@
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"comp0ser/internal/cmd"
+)
+
+var (
+	ErrBadAsset      = errors.New("bad asset")
+	ErrAssetNotFound = errors.New("asset not found")
+)
+
+// AssetKind picks the folder of an upload, videos sit directly in asset/
+// where render picks up every mp4
+type AssetKind string
+
+const (
+	AssetVideo AssetKind = "video"
+	AssetStill AssetKind = "still"
+	AssetBGM   AssetKind = "bgm"
+)
+
+var assetKinds = []AssetKind{AssetVideo, AssetStill, AssetBGM}
+
+// uploadDir stages uploads inside the project folder, next to the asset area
+// render reads and on the same filesystem so the final rename is atomic
+const uploadDir = ".uploads"
+
+var assetExts = map[AssetKind][]string{
+	AssetVideo: {".mp4"},
+	AssetStill: {".png", ".jpg", ".jpeg", ".webp"},
+	AssetBGM:   {".mp3", ".m4a", ".aac", ".wav", ".flac", ".ogg"},
+}
+
+func (k AssetKind) Valid() bool {
+	return slices.Contains(assetKinds, k)
+}
+
+// dir is the folder of k relative to the project folder
+func (k AssetKind) dir() string {
+	if k == AssetVideo {
+		return "asset"
+	}
+	return path.Join("asset", string(k))
+}
+
+// Asset is a file uploaded into a project's asset area
+type Asset struct {
+	Kind AssetKind `json:"kind"`
+	Name string    `json:"name"`
+	// Path is relative to the project folder
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+
+	// Media is nil for a file copied in by hand that was never probed
+	Media *cmd.MediaInfo `json:"media,omitempty"`
+
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// ProbeFunc reads the media metadata of a file, cmd.Probe in production
+type ProbeFunc func(path string) (*cmd.MediaInfo, error)
+
+// Upload is one file of a SaveAssets batch
+type Upload struct {
+	Filename string
+	Body     io.Reader
+}
+
+// SaveAsset stores r as filename in the asset area of kind, a file probe
+// cannot read, or that lacks the streams kind needs, is rejected
+func (s *fileLocalStore) SaveAsset(name string, kind AssetKind, filename string, r io.Reader, probe ProbeFunc) (Asset, error) {
+	assets, err := s.SaveAssets(name, kind, []Upload{{Filename: filename, Body: r}}, probe)
+	if err != nil {
+		return Asset{}, err
+	}
+	return assets[0], nil
+}
+
+func (s *fileLocalStore) SaveAssets(name string, kind AssetKind, uploads []Upload, probe ProbeFunc) ([]Asset, error) {
+	if err := CheckProjectName(name); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(uploads))
+	for _, u := range uploads {
+		if err := checkAssetName(kind, u.Filename); err != nil {
+			return nil, err
+		}
+		if seen[u.Filename] {
+			return nil, fmt.Errorf("%w: %s is uploaded twice", ErrBadAsset, u.Filename)
+		}
+		seen[u.Filename] = true
+	}
+
+	dir := filepath.Join(s.dir, name, filepath.FromSlash(kind.dir()))
+	if info, err := os.Stat(filepath.Join(s.dir, name)); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrProjectNotFound, name)
+	}
+	staging := filepath.Join(s.dir, name, uploadDir)
+	for _, d := range []string{dir, staging} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	// write aside so a partial or rejected upload never shows up in the asset
+	// area, the extension stays for ffprobe
+	staged := make([]string, 0, len(uploads))
+	defer func() {
+		for _, tmp := range staged {
+			_ = os.Remove(tmp)
+		}
+	}()
+	assets := make([]Asset, 0, len(uploads))
+	for _, u := range uploads {
+		tmp, size, err := stageUpload(staging, u)
+		if tmp != "" {
+			staged = append(staged, tmp)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		media, err := probe(tmp)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrBadAsset, u.Filename, err)
+		}
+		if err := checkMedia(kind, media); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrBadAsset, u.Filename, err)
+		}
+		assets = append(assets, Asset{
+			Kind:       kind,
+			Name:       u.Filename,
+			Path:       path.Join(kind.dir(), u.Filename),
+			Size:       size,
+			Media:      media,
+			UploadedAt: time.Now(),
+		})
+	}
+
+	for i, a := range assets {
+		if err := os.Rename(staged[i], filepath.Join(dir, a.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.UpdateProject(name, func(p *Project) error {
+		for _, a := range assets {
+			p.Assets = slices.DeleteFunc(p.Assets, func(o Asset) bool { return o.Path == a.Path })
+			p.Assets = append(p.Assets, a)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+// stageUpload copies u into the staging dir, the temp file is returned even
+// when the copy fails so the caller removes it
+func stageUpload(staging string, u Upload) (string, int64, error) {
+	f, err := os.CreateTemp(staging, "upload-*"+filepath.Ext(u.Filename))
+	if err != nil {
+		return "", 0, err
+	}
+	size, err := io.Copy(f, u.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	return f.Name(), size, err
+}
+
+// Assets lists the asset area of a project, kind "" lists every kind
+func (s *fileLocalStore) Assets(name string, kind AssetKind) ([]Asset, error) {
+	p, err := s.Project(name)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]Asset, len(p.Assets))
+	for _, a := range p.Assets {
+		known[a.Path] = a
+	}
+
+	out := []Asset{}
+	for _, k := range assetKinds {
+		if kind != "" && k != kind {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(s.dir, name, filepath.FromSlash(k.dir())))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || checkAssetName(k, e.Name()) != nil {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			a, ok := known[path.Join(k.dir(), e.Name())]
+			if !ok {
+				a = Asset{
+					Kind:       k,
+					Name:       e.Name(),
+					Path:       path.Join(k.dir(), e.Name()),
+					UploadedAt: info.ModTime(),
+				}
+			}
+			a.Size = info.Size()
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (s *fileLocalStore) DeleteAsset(name string, kind AssetKind, filename string) error {
+	if err := CheckProjectName(name); err != nil {
+		return err
+	}
+	if err := checkAssetName(kind, filename); err != nil {
+		return err
+	}
+
+	rel := path.Join(kind.dir(), filename)
+	err := os.Remove(filepath.Join(s.dir, name, filepath.FromSlash(rel)))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrAssetNotFound, rel)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.UpdateProject(name, func(p *Project) error {
+		p.Assets = slices.DeleteFunc(p.Assets, func(o Asset) bool { return o.Path == rel })
+		return nil
+	})
+	return err
+}
+
+func checkAssetName(kind AssetKind, filename string) error {
+	if !kind.Valid() {
+		return fmt.Errorf("%w: unknown kind %q", ErrBadAsset, kind)
+	}
+	if filename == "" || strings.HasPrefix(filename, ".") || filename != filepath.Base(filename) || strings.ContainsAny(filename, `/\`) {
+		return fmt.Errorf("%w: bad file name %q", ErrBadAsset, filename)
+	}
+	if !slices.Contains(assetExts[kind], strings.ToLower(filepath.Ext(filename))) {
+		return fmt.Errorf("%w: a %s must be one of %s", ErrBadAsset, kind, strings.Join(assetExts[kind], " "))
+	}
+	return nil
+}
+
+// checkMedia makes sure a file has the streams its kind is used for
+func checkMedia(kind AssetKind, m *cmd.MediaInfo) error {
+	switch kind {
+	case AssetVideo:
+		if m.VideoCodec == "" || m.Duration <= 0 {
+			return fmt.Errorf("no video stream")
+		}
+	case AssetStill:
+		if m.VideoCodec == "" || m.Width == 0 || m.Height == 0 {
+			return fmt.Errorf("not an image")
+		}
+	case AssetBGM:
+		if m.AudioCodec == "" {
+			return fmt.Errorf("no audio stream")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrBadPath      = errors.New("bad path")
+	ErrFileNotFound = errors.New("file not found")
+)
+
+// Open opens the regular file at rel inside the project folder, paths that
+// leave the folder, through .. or a symlink, and hidden files are refused
+func (s *fileLocalStore) Open(name, rel string) (*os.File, error) {
+	if err := CheckProjectName(name); err != nil {
+		return nil, err
+	}
+	rel = strings.TrimPrefix(filepath.ToSlash(rel), "/")
+	if !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("%w: %q", ErrBadPath, rel)
+	}
+	for el := range strings.SplitSeq(rel, "/") {
+		if strings.HasPrefix(el, ".") {
+			return nil, fmt.Errorf("%w: %q is hidden", ErrBadPath, rel)
+		}
+	}
+
+	root, err := filepath.EvalSymlinks(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProjectNotFound, name)
+	}
+	real, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(rel)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, rel)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if inside, err := filepath.Rel(root, real); err != nil || !filepath.IsLocal(inside) {
+		return nil, fmt.Errorf("%w: %q leaves the project", ErrBadPath, rel)
+	}
+
+	f, err := os.Open(real)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		_ = f.Close()
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, rel)
+	}
+	return f, nil
+}
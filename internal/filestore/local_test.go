@@ -1,9 +1,14 @@
 package filestore
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"comp0ser/internal/cmd"
 )
 
 func TestFileLocalStore_New(t *testing.T) {
@@ -15,24 +20,30 @@ func TestFileLocalStore_New(t *testing.T) {
 	fmt.Println(filename)
 }
 
-func TestFileLocalStore_Add(t *testing.T) {
+func TestFileLocalStore_Update(t *testing.T) {
 	fs := NewFileLocalStore("/mnt/media/data")
-	if err := fs.Add("jupiter", "0000", map[string]any{"audio_id": "ttt"}, nil); err != nil {
+	if _, err := fs.Update("jupiter", "0000", func(nar *Narration) error {
+		nar.AudioID = "ttt"
+		return nil
+	}); err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestFileLocalStore_Append(t *testing.T) {
 	fs := NewFileLocalStore("store")
-	id, err := fs.Append("test", "", "hello wolrd", map[string]any{
-		"field_1": 10,
-		"field_2": "test",
+	nar, err := fs.Append("test", Narration{
+		Text: "hello wolrd",
+		Meta: map[string]any{
+			"field_1": 10,
+			"field_2": "test",
+		},
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	fmt.Println(id)
+	fmt.Println(nar.ID)
 }
 
 func TestFileLocalStore_Save(t *testing.T) {
@@ -58,3 +69,293 @@ func TestFileLocalStore_List(t *testing.T) {
 	}
 	fmt.Println(nars)
 }
+
+func TestFileLocalStore_MigrateV1(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileLocalStore(dir)
+	if _, err := fs.New("legacy"); err != nil {
+		t.Fatal(err)
+	}
+	v1 := `{"id":"0000","text":"first","audio_id":"0000"}
+{"id":"0001","text":"second","audio_id":"","field_1":10}
+`
+	file := filepath.Join(dir, "legacy", "narration.txt")
+	if err := os.WriteFile(file, []byte(v1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	nars, err := fs.List("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nars) != 2 {
+		t.Fatalf("got %d narrations, want 2", len(nars))
+	}
+	if nars[0].Status != NarrationSynthesized || nars[0].AudioID != "0000" {
+		t.Fatalf("unexpected first narration: %+v", nars[0])
+	}
+	if nars[1].Status != NarrationPending || nars[1].Meta["field_1"] != float64(10) {
+		t.Fatalf("unexpected second narration: %+v", nars[1])
+	}
+
+	if bak, err := os.ReadFile(file + ".bak"); err != nil || string(bak) != v1 {
+		t.Fatalf("backup: %q %v", bak, err)
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), `"v":2`) {
+		t.Fatalf("file not rewritten: %s", raw)
+	}
+
+	// malformed lines are an error, not a panic
+	if err := os.WriteFile(file, []byte(`{"id":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.List("legacy"); err == nil {
+		t.Fatal("want an error for a malformed line")
+	}
+}
+
+func TestFileLocalStore_Edit(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileLocalStore(dir)
+	if _, err := fs.New("edit"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := fs.Append("edit", Narration{ID: id, Text: id + id, AudioID: id, Status: NarrationSynthesized}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func() string {
+		nars, err := fs.List("edit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, nar := range nars {
+			out = append(out, nar.ID+":"+nar.Text)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if _, err := fs.Reorder("edit", []string{"c", "a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Reorder("edit", []string{"c", "c", "b"}); !errors.Is(err, ErrBadEdit) {
+		t.Fatalf("reorder with a repeated id: %v", err)
+	}
+	if got := ids(); got != "c:cc,a:aa,b:bb" {
+		t.Fatalf("after reorder: %s", got)
+	}
+
+	split, err := fs.Split("edit", "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if split[0].AudioID != "" || split[1].Status != NarrationPending {
+		t.Fatalf("split left audio behind: %+v", split)
+	}
+	tail := split[1].ID
+
+	joined, err := fs.Join("edit", []string{"a", tail, "b"}, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined.Text != "a-a-bb" {
+		t.Fatalf("joined text %q", joined.Text)
+	}
+	if _, err := fs.Join("edit", []string{"c", "c"}, ""); !errors.Is(err, ErrBadEdit) {
+		t.Fatalf("join of non consecutive ids: %v", err)
+	}
+
+	if err := fs.Delete("edit", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete("edit", "c"); !errors.Is(err, ErrNarrationNotFound) {
+		t.Fatalf("second delete: %v", err)
+	}
+	if got := ids(); got != "a:a-a-bb" {
+		t.Fatalf("after join and delete: %s", got)
+	}
+}
+
+func TestFileLocalStore_Projects(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileLocalStore(dir)
+
+	p, err := fs.CreateProject(Project{Name: "moon", Subject: "the moon", Language: "zh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != ManifestVersion || p.CreatedAt.IsZero() {
+		t.Fatalf("unexpected manifest: %+v", p)
+	}
+	if _, err := fs.CreateProject(Project{Name: "moon"}); !errors.Is(err, ErrProjectExists) {
+		t.Fatalf("create twice: %v", err)
+	}
+	for _, name := range []string{"", "..", ".comp0ser", "a/b"} {
+		if _, err := fs.CreateProject(Project{Name: name}); !errors.Is(err, ErrBadProjectName) {
+			t.Fatalf("create %q: %v", name, err)
+		}
+	}
+
+	// a folder from before manifests
+	if _, err := fs.New("legacy"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.AddArtifacts("moon", Artifact{Path: "moon.wav", Kind: "concat.wav"}); err != nil {
+		t.Fatal(err)
+	}
+	p, err = fs.AddArtifacts("moon", Artifact{Path: "moon.wav", Kind: "concat.wav", TaskID: "t2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Artifacts) != 1 || p.Artifacts[0].TaskID != "t2" {
+		t.Fatalf("artifacts: %+v", p.Artifacts)
+	}
+
+	projects, err := fs.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || projects[0].Name != "legacy" || projects[1].Subject != "the moon" {
+		t.Fatalf("projects: %+v", projects)
+	}
+
+	if err := fs.DeleteProject("moon"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Project("moon"); !errors.Is(err, ErrProjectNotFound) {
+		t.Fatalf("get deleted project: %v", err)
+	}
+}
+
+func TestFileLocalStore_Assets(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileLocalStore(dir)
+	if _, err := fs.CreateProject(Project{Name: "moon"}); err != nil {
+		t.Fatal(err)
+	}
+	probe := func(path string) (*cmd.MediaInfo, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		switch string(b) {
+		case "video", "video v2":
+			return &cmd.MediaInfo{Format: "mp4", Duration: 3, VideoCodec: "h264", Width: 1280, Height: 720}, nil
+		case "audio":
+			return &cmd.MediaInfo{Format: "mp3", Duration: 60, AudioCodec: "mp3"}, nil
+		}
+		return nil, fmt.Errorf("invalid data found when processing input")
+	}
+
+	a, err := fs.SaveAsset("moon", AssetVideo, "a.mp4", strings.NewReader("video"), probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Path != "asset/a.mp4" || a.Media.Width != 1280 || a.Size != 5 {
+		t.Fatalf("unexpected asset: %+v", a)
+	}
+	if _, err := fs.SaveAsset("moon", AssetBGM, "bgm.mp3", strings.NewReader("audio"), probe); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		kind     AssetKind
+		filename string
+		body     string
+	}{
+		{AssetVideo, "broken.mp4", "junk"},
+		{AssetVideo, "audio.mp4", "audio"},
+		{AssetVideo, "a.mov", "video"},
+		{AssetVideo, "../a.mp4", "video"},
+		{"font", "a.ttf", "video"},
+	} {
+		if _, err := fs.SaveAsset("moon", c.kind, c.filename, strings.NewReader(c.body), probe); !errors.Is(err, ErrBadAsset) {
+			t.Fatalf("save %s %s: %v", c.kind, c.filename, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "moon", "asset", "broken.mp4")); !os.IsNotExist(err) {
+		t.Fatalf("rejected upload left behind: %v", err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "moon", "asset", ".*")); len(left) != 0 {
+		t.Fatalf("uploads staged in the asset area: %v", left)
+	}
+	if left, _ := os.ReadDir(filepath.Join(dir, "moon", uploadDir)); len(left) != 0 {
+		t.Fatalf("staged uploads left behind: %v", left)
+	}
+
+	// a rejected file keeps the batch from replacing a.mp4
+	_, err = fs.SaveAssets("moon", AssetVideo, []Upload{
+		{Filename: "a.mp4", Body: strings.NewReader("video v2")},
+		{Filename: "b.mp4", Body: strings.NewReader("junk")},
+	}, probe)
+	if !errors.Is(err, ErrBadAsset) {
+		t.Fatalf("batch: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "moon", "asset", "a.mp4")); string(b) != "video" {
+		t.Fatalf("a.mp4 replaced by a rejected batch: %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "moon", "asset", "b.mp4")); !os.IsNotExist(err) {
+		t.Fatalf("rejected batch left b.mp4: %v", err)
+	}
+
+	assets, err := fs.Assets("moon", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 2 || assets[1].Path != "asset/bgm/bgm.mp3" || assets[1].Media == nil {
+		t.Fatalf("assets: %+v", assets)
+	}
+
+	if err := fs.DeleteAsset("moon", AssetVideo, "a.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.DeleteAsset("moon", AssetVideo, "a.mp4"); !errors.Is(err, ErrAssetNotFound) {
+		t.Fatalf("second delete: %v", err)
+	}
+	if assets, _ := fs.Assets("moon", AssetVideo); len(assets) != 0 {
+		t.Fatalf("video assets after delete: %+v", assets)
+	}
+}
+
+func TestFileLocalStore_Open(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileLocalStore(dir)
+	if _, err := fs.CreateProject(Project{Name: "moon"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "moon", "out.mp4"), []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "moon", "link.mp4")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Open("moon", "/out.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	for rel, want := range map[string]error{
+		"../moon/out.mp4": ErrBadPath,
+		"link.mp4":        ErrBadPath,
+		".narration.tmp":  ErrBadPath,
+		"missing.mp4":     ErrFileNotFound,
+		"audio":           ErrFileNotFound,
+	} {
+		if _, err := fs.Open("moon", rel); !errors.Is(err, want) {
+			t.Fatalf("open %s: got %v, want %v", rel, err, want)
+		}
+	}
+}
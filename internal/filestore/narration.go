@@ -0,0 +1,482 @@
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NarrationVersion is the schema version written to every narration line,
+// version 1 is the untyped {"id","text","audio_id",...} line written before
+// lines carried a version
+const NarrationVersion = 2
+
+const narrationFile = "narration.txt"
+
+var (
+	ErrProjectNotFound   = errors.New("project not found")
+	ErrNarrationNotFound = errors.New("narration not found")
+	ErrBadEdit           = errors.New("bad narration edit")
+)
+
+type NarrationStatus string
+
+const (
+	// NarrationPending has no audio or its audio is stale
+	NarrationPending     NarrationStatus = "pending"
+	NarrationSynthesized NarrationStatus = "synthesized"
+	NarrationFailed      NarrationStatus = "failed"
+)
+
+// Narration is one line of a project's narration.txt
+type Narration struct {
+	Version int    `json:"v"`
+	ID      string `json:"id"`
+	Text    string `json:"text"`
+
+	// AudioID names audio/<AudioID>.wav, empty until synthesized
+	AudioID string `json:"audio_id"`
+	// Duration of the audio in seconds
+	Duration float64         `json:"duration,omitempty"`
+	Voice    string          `json:"voice,omitempty"`
+	Status   NarrationStatus `json:"status"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Meta keeps fields the store does not know about
+	Meta map[string]any `json:"meta,omitempty"`
+}
+
+// Invalidate drops the audio of a narration whose text or voice changed, the
+// next tts run synthesizes it again
+func (n *Narration) Invalidate() {
+	n.AudioID = ""
+	n.Duration = 0
+	n.Status = NarrationPending
+}
+
+// legacyKeys are the fields a version 1 line kept at the top level
+var legacyKeys = map[string]bool{"id": true, "text": true, "audio_id": true}
+
+// decodeNarration parses a line of any known version, migrated reports
+// whether it was older than NarrationVersion
+func decodeNarration(line []byte, modTime time.Time) (nar Narration, migrated bool, err error) {
+	var head struct {
+		Version int `json:"v"`
+	}
+	if err := json.Unmarshal(line, &head); err != nil {
+		return Narration{}, false, err
+	}
+
+	switch head.Version {
+	case 0, 1:
+		nar, err = migrateV1(line, modTime)
+		return nar, true, err
+	case NarrationVersion:
+		err = json.Unmarshal(line, &nar)
+		return nar, false, err
+	default:
+		return Narration{}, false, fmt.Errorf("unknown narration version %d", head.Version)
+	}
+}
+
+// migrateV1 lifts a version 1 line, unknown fields move to Meta and the file
+// modification time stands in for the missing timestamps
+func migrateV1(line []byte, modTime time.Time) (Narration, error) {
+	var o map[string]any
+	if err := json.Unmarshal(line, &o); err != nil {
+		return Narration{}, err
+	}
+
+	nar := Narration{
+		Version:   NarrationVersion,
+		Status:    NarrationPending,
+		CreatedAt: modTime,
+		UpdatedAt: modTime,
+	}
+	if id, ok := o["id"]; ok && id != nil {
+		nar.ID = fmt.Sprint(id)
+	}
+	nar.Text, _ = o["text"].(string)
+	nar.AudioID, _ = o["audio_id"].(string)
+	if nar.AudioID != "" {
+		nar.Status = NarrationSynthesized
+	}
+	for k, v := range o {
+		if legacyKeys[k] {
+			continue
+		}
+		if nar.Meta == nil {
+			nar.Meta = make(map[string]any)
+		}
+		nar.Meta[k] = v
+	}
+	return nar, nil
+}
+
+func (s *fileLocalStore) narrationPath(name string) string {
+	return filepath.Join(s.dir, name, narrationFile)
+}
+
+// readNarrations loads a narration file, a file with older lines is
+// rewritten at the current version and the original kept as .bak
+func (s *fileLocalStore) readNarrations(name string) ([]Narration, error) {
+	if err := CheckProjectName(name); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(filepath.Join(s.dir, name)); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrProjectNotFound, name)
+	}
+	file := s.narrationPath(name)
+	raw, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		nars     []Narration
+		migrated bool
+	)
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		nar, old, err := decodeNarration(line, info.ModTime())
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", file, n, err)
+		}
+		migrated = migrated || old
+		nars = append(nars, nar)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if migrated {
+		if err := os.WriteFile(file+".bak", raw, 0o644); err != nil {
+			return nil, err
+		}
+		if err := s.writeNarrations(name, nars); err != nil {
+			return nil, err
+		}
+		slog.Info("narrations migrated",
+			"file", file,
+			"version", NarrationVersion,
+			"narrations", len(nars),
+		)
+	}
+	return nars, nil
+}
+
+// writeNarrations replaces the narration file through a rename
+func (s *fileLocalStore) writeNarrations(name string, nars []Narration) error {
+	dir := filepath.Join(s.dir, name)
+	tmp, err := os.CreateTemp(dir, ".narration.tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+	}()
+
+	w := bufio.NewWriter(tmp)
+	for _, nar := range nars {
+		b, err := json.Marshal(nar)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, s.narrationPath(name))
+}
+
+func (s *fileLocalStore) List(name string) ([]Narration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.readNarrations(name)
+}
+
+// Append adds nar at the end, an empty ID gets a generated one
+func (s *fileLocalStore) Append(name string, nar Narration) (Narration, error) {
+	return s.Insert(name, -1, nar)
+}
+
+// Insert puts nar before the narration at index at, a negative or too large
+// index appends
+func (s *fileLocalStore) Insert(name string, at int, nar Narration) (Narration, error) {
+	err := s.edit(name, func(nars []Narration) ([]Narration, error) {
+		var err error
+		if nar.ID == "" {
+			if nar.ID, err = generateID(); err != nil {
+				return nil, err
+			}
+		}
+		if indexOf(nars, nar.ID) >= 0 {
+			return nil, fmt.Errorf("%w: duplicate narration id %q", ErrBadEdit, nar.ID)
+		}
+
+		now := time.Now()
+		nar.Version = NarrationVersion
+		nar.CreatedAt, nar.UpdatedAt = now, now
+		if nar.Status == "" {
+			nar.Status = NarrationPending
+		}
+		if at < 0 || at > len(nars) {
+			at = len(nars)
+		}
+		return slices.Insert(nars, at, nar), nil
+	})
+	return nar, err
+}
+
+// Update applies fn to the narration with id and stores the result
+func (s *fileLocalStore) Update(name, id string, fn func(nar *Narration) error) (Narration, error) {
+	var out Narration
+	err := s.edit(name, func(nars []Narration) ([]Narration, error) {
+		i := indexOf(nars, id)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNarrationNotFound, id)
+		}
+		out = nars[i]
+		if err := fn(&out); err != nil {
+			return nil, err
+		}
+		out.ID = id
+		out.Version = NarrationVersion
+		out.UpdatedAt = time.Now()
+		nars[i] = out
+		return nars, nil
+	})
+	return out, err
+}
+
+// Delete removes a narration and its audio
+func (s *fileLocalStore) Delete(name, id string) error {
+	var audioID string
+	err := s.edit(name, func(nars []Narration) ([]Narration, error) {
+		i := indexOf(nars, id)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNarrationNotFound, id)
+		}
+		audioID = nars[i].AudioID
+		return slices.Delete(nars, i, i+1), nil
+	})
+	if err == nil {
+		s.removeAudio(name, audioID)
+	}
+	return err
+}
+
+// Reorder puts the narrations in the order of ids, which must name each of
+// them exactly once, the audio of every segment stays valid
+func (s *fileLocalStore) Reorder(name string, ids []string) ([]Narration, error) {
+	var out []Narration
+	err := s.edit(name, func(nars []Narration) ([]Narration, error) {
+		if len(ids) != len(nars) {
+			return nil, fmt.Errorf("%w: want %d ids, got %d", ErrBadEdit, len(nars), len(ids))
+		}
+		out = make([]Narration, 0, len(nars))
+		seen := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			i := indexOf(nars, id)
+			if i < 0 {
+				return nil, fmt.Errorf("%w: %s", ErrNarrationNotFound, id)
+			}
+			if seen[id] {
+				return nil, fmt.Errorf("%w: id %s given twice", ErrBadEdit, id)
+			}
+			seen[id] = true
+			out = append(out, nars[i])
+		}
+		return out, nil
+	})
+	return out, err
+}
+
+// Replace drops the narrations of name and their audio and stores nars in
+// their place, an empty ID gets a generated one
+func (s *fileLocalStore) Replace(name string, nars []Narration) ([]Narration, error) {
+	var dropped []Narration
+	out := make([]Narration, 0, len(nars))
+	err := s.edit(name, func(old []Narration) ([]Narration, error) {
+		now := time.Now()
+		for _, nar := range nars {
+			if nar.ID == "" {
+				var err error
+				if nar.ID, err = generateID(); err != nil {
+					return nil, err
+				}
+			}
+			if indexOf(out, nar.ID) >= 0 {
+				return nil, fmt.Errorf("%w: duplicate narration id %q", ErrBadEdit, nar.ID)
+			}
+			nar.Version = NarrationVersion
+			nar.CreatedAt, nar.UpdatedAt = now, now
+			nar.AudioID, nar.Duration = "", 0
+			nar.Status = NarrationPending
+			out = append(out, nar)
+		}
+		dropped = old
+		return out, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, nar := range dropped {
+		s.removeAudio(name, nar.AudioID)
+	}
+	return out, nil
+}
+
+// Split cuts a narration at rune offset at, the head keeps the ID and the tail
+// becomes a new narration right after it, both need synthesizing again
+func (s *fileLocalStore) Split(name, id string, at int) ([]Narration, error) {
+	var out []Narration
+	var audioID string
+	err := s.edit(name, func(nars []Narration) ([]Narration, error) {
+		i := indexOf(nars, id)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNarrationNotFound, id)
+		}
+		text := []rune(nars[i].Text)
+		if at <= 0 || at >= len(text) {
+			return nil, fmt.Errorf("%w: split offset %d outside 1-%d", ErrBadEdit, at, len(text)-1)
+		}
+		tailID, err := generateID()
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		head := nars[i]
+		audioID = head.AudioID
+		head.Text = string(text[:at])
+		head.UpdatedAt = now
+		head.Invalidate()
+
+		tail := head
+		tail.ID = tailID
+		tail.Text = string(text[at:])
+		tail.CreatedAt = now
+		tail.Meta = maps.Clone(head.Meta)
+
+		nars[i] = head
+		out = []Narration{head, tail}
+		return slices.Insert(nars, i+1, tail), nil
+	})
+	if err == nil {
+		s.removeAudio(name, audioID)
+	}
+	return out, err
+}
+
+// Join merges consecutive narrations into the first of ids, their texts
+// separated by sep
+func (s *fileLocalStore) Join(name string, ids []string, sep string) (Narration, error) {
+	var (
+		out    Narration
+		stale  []string
+		joined []string
+	)
+	err := s.edit(name, func(nars []Narration) ([]Narration, error) {
+		if len(ids) < 2 {
+			return nil, fmt.Errorf("%w: join needs at least two ids", ErrBadEdit)
+		}
+		first := indexOf(nars, ids[0])
+		if first < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNarrationNotFound, ids[0])
+		}
+		for k, id := range ids {
+			i := indexOf(nars, id)
+			if i < 0 {
+				return nil, fmt.Errorf("%w: %s", ErrNarrationNotFound, id)
+			}
+			if i != first+k {
+				return nil, fmt.Errorf("%w: %s does not follow %s", ErrBadEdit, id, ids[k-1])
+			}
+			joined = append(joined, nars[i].Text)
+			stale = append(stale, nars[i].AudioID)
+		}
+
+		out = nars[first]
+		out.Text = strings.Join(joined, sep)
+		out.UpdatedAt = time.Now()
+		out.Invalidate()
+		nars[first] = out
+		return slices.Delete(nars, first+1, first+len(ids)), nil
+	})
+	if err == nil {
+		for _, audioID := range stale {
+			s.removeAudio(name, audioID)
+		}
+	}
+	return out, err
+}
+
+// edit rewrites the narrations of name with fn under the store lock
+func (s *fileLocalStore) edit(name string, fn func(nars []Narration) ([]Narration, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nars, err := s.readNarrations(name)
+	if err != nil {
+		return err
+	}
+	nars, err = fn(nars)
+	if err != nil {
+		return err
+	}
+	return s.writeNarrations(name, nars)
+}
+
+// removeAudio deletes a wav no narration points to anymore
+func (s *fileLocalStore) removeAudio(name, audioID string) {
+	if audioID == "" {
+		return
+	}
+	file := filepath.Join(s.dir, name, "audio", audioID+".wav")
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("remove stale audio failed",
+			"file", file,
+			"err", err,
+		)
+	}
+}
+
+func indexOf(nars []Narration, id string) int {
+	return slices.IndexFunc(nars, func(n Narration) bool { return n.ID == id })
+}
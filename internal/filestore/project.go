@@ -0,0 +1,250 @@
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestVersion is the schema version of project.json
+const ManifestVersion = 1
+
+const manifestFile = "project.json"
+
+var (
+	ErrProjectExists  = errors.New("project already exists")
+	ErrBadProjectName = errors.New("bad project name")
+)
+
+// Project is the project.json manifest kept in every project folder
+type Project struct {
+	Version int    `json:"v"`
+	Name    string `json:"name"`
+
+	Subject  string `json:"subject,omitempty"`
+	Language string `json:"language,omitempty"`
+	Voice    string `json:"voice,omitempty"`
+	// TTS names the speech provider, empty uses the server default
+	TTS    string `json:"tts,omitempty"`
+	Prompt Prompt `json:"prompt"`
+
+	Artifacts []Artifact `json:"artifacts"`
+	// Assets keeps the probed metadata of uploaded assets
+	Assets []Asset `json:"assets,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Prompt holds the parameters the script of a project was generated with
+type Prompt struct {
+	Model    string `json:"model,omitempty"`
+	Segments int    `json:"segments,omitempty"`
+	MinChars int    `json:"minChars,omitempty"`
+	MaxChars int    `json:"maxChars,omitempty"`
+	Focus    string `json:"focus,omitempty"`
+	Hook     string `json:"hook,omitempty"`
+}
+
+// Artifact is a file a task generated inside the project folder
+type Artifact struct {
+	// Path is relative to the project folder
+	Path      string    `json:"path"`
+	Kind      string    `json:"kind"`
+	TaskID    string    `json:"taskId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CheckProjectName rejects names that are not a single plain directory
+func CheckProjectName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return fmt.Errorf("%w: %q", ErrBadProjectName, name)
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("%w: %q starts with a dot", ErrBadProjectName, name)
+	case strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, 0):
+		return fmt.Errorf("%w: %q is not a single path element", ErrBadProjectName, name)
+	}
+	return nil
+}
+
+func (s *fileLocalStore) manifestPath(name string) string {
+	return filepath.Join(s.dir, name, manifestFile)
+}
+
+// CreateProject makes the folder and manifest of a new project
+func (s *fileLocalStore) CreateProject(p Project) (Project, error) {
+	if err := CheckProjectName(p.Name); err != nil {
+		return Project{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(s.dir, p.Name)); err == nil {
+		return Project{}, fmt.Errorf("%w: %s", ErrProjectExists, p.Name)
+	}
+	if _, err := s.New(p.Name); err != nil {
+		return Project{}, err
+	}
+
+	now := time.Now()
+	p.Version = ManifestVersion
+	p.CreatedAt, p.UpdatedAt = now, now
+	p.Artifacts = []Artifact{}
+	if err := s.writeManifest(p); err != nil {
+		return Project{}, err
+	}
+	return p, nil
+}
+
+func (s *fileLocalStore) Project(name string) (Project, error) {
+	if err := CheckProjectName(name); err != nil {
+		return Project{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.readManifest(name)
+}
+
+// Projects lists every project in the store, folders made before manifests
+// existed included
+func (s *fileLocalStore) Projects() ([]Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Project
+	for _, e := range entries {
+		if !e.IsDir() || CheckProjectName(e.Name()) != nil {
+			continue
+		}
+		p, err := s.readManifest(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// UpdateProject applies fn to the manifest of name and stores the result
+func (s *fileLocalStore) UpdateProject(name string, fn func(p *Project) error) (Project, error) {
+	if err := CheckProjectName(name); err != nil {
+		return Project{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.readManifest(name)
+	if err != nil {
+		return Project{}, err
+	}
+	if err := fn(&p); err != nil {
+		return Project{}, err
+	}
+	p.Name = name
+	p.Version = ManifestVersion
+	p.UpdatedAt = time.Now()
+	if err := s.writeManifest(p); err != nil {
+		return Project{}, err
+	}
+	return p, nil
+}
+
+// AddArtifacts records files generated in the project folder, a path already
+// listed is replaced by its newer version
+func (s *fileLocalStore) AddArtifacts(name string, arts ...Artifact) (Project, error) {
+	return s.UpdateProject(name, func(p *Project) error {
+		for _, a := range arts {
+			if a.CreatedAt.IsZero() {
+				a.CreatedAt = time.Now()
+			}
+			p.Artifacts = slices.DeleteFunc(p.Artifacts, func(o Artifact) bool { return o.Path == a.Path })
+			p.Artifacts = append(p.Artifacts, a)
+		}
+		return nil
+	})
+}
+
+// DeleteProject removes the project folder with everything in it
+func (s *fileLocalStore) DeleteProject(name string) error {
+	if err := CheckProjectName(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Join(s.dir, name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%w: %s", ErrProjectNotFound, name)
+	}
+	return os.RemoveAll(dir)
+}
+
+// readManifest loads project.json, a folder without one gets a manifest
+// derived from the folder itself
+func (s *fileLocalStore) readManifest(name string) (Project, error) {
+	dir := filepath.Join(s.dir, name)
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return Project{}, fmt.Errorf("%w: %s", ErrProjectNotFound, name)
+	}
+
+	raw, err := os.ReadFile(s.manifestPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return Project{
+			Version:   ManifestVersion,
+			Name:      name,
+			Subject:   name,
+			Artifacts: []Artifact{},
+			CreatedAt: info.ModTime(),
+			UpdatedAt: info.ModTime(),
+		}, nil
+	}
+	if err != nil {
+		return Project{}, err
+	}
+
+	var p Project
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return Project{}, fmt.Errorf("bad %s: %w", s.manifestPath(name), err)
+	}
+	if p.Version > ManifestVersion {
+		return Project{}, fmt.Errorf("%s: unknown manifest version %d", s.manifestPath(name), p.Version)
+	}
+	p.Name = name
+	if p.Artifacts == nil {
+		p.Artifacts = []Artifact{}
+	}
+	return p, nil
+}
+
+func (s *fileLocalStore) writeManifest(p Project) error {
+	raw, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := s.manifestPath(p.Name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
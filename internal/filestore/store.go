@@ -1,13 +1,43 @@
 package filestore
 
-import "io"
+import (
+	"io"
+	"os"
+)
 
 type FileStore interface {
 	New(name string) (string, error)
-	Add(name, id string, set map[string]any, unset []string) error
-	Append(name, id, text string, extra map[string]any) (string, error)
 	Save(name, filename, ext string, r io.Reader) (string, string, error)
-	List(name string) ([]map[string]any, error)
+
+	// List returns the narrations of name in order, migrating an older
+	// narration file on the way
+	List(name string) ([]Narration, error)
+	Append(name string, nar Narration) (Narration, error)
+	Update(name, id string, fn func(nar *Narration) error) (Narration, error)
+	Insert(name string, at int, nar Narration) (Narration, error)
+	Delete(name, id string) error
+	Reorder(name string, ids []string) ([]Narration, error)
+	Split(name, id string, at int) ([]Narration, error)
+	Join(name string, ids []string, sep string) (Narration, error)
+	// Replace swaps every narration of name for nars, a generated script
+	Replace(name string, nars []Narration) ([]Narration, error)
+
+	CreateProject(p Project) (Project, error)
+	Project(name string) (Project, error)
+	Projects() ([]Project, error)
+	UpdateProject(name string, fn func(p *Project) error) (Project, error)
+	AddArtifacts(name string, arts ...Artifact) (Project, error)
+	DeleteProject(name string) error
+
+	SaveAsset(name string, kind AssetKind, filename string, r io.Reader, probe ProbeFunc) (Asset, error)
+	// SaveAssets stores a batch all or nothing, no file of the asset area is
+	// replaced before every upload probed fine
+	SaveAssets(name string, kind AssetKind, uploads []Upload, probe ProbeFunc) ([]Asset, error)
+	Assets(name string, kind AssetKind) ([]Asset, error)
+	DeleteAsset(name string, kind AssetKind, filename string) error
+
+	// Open opens a file inside the project folder for download
+	Open(name, rel string) (*os.File, error)
 
 	Dir() string
 }
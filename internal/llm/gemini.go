@@ -3,13 +3,34 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"strings"
 
 	"google.golang.org/genai"
 )
 
+// APIError wraps a request rejected by gemini with its http status
+type APIError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("request gemini: %v", e.Err)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the request may succeed when retried
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
 type Config struct {
 	APIKey string
 }
@@ -63,6 +84,10 @@ func (g *GeminiClient) GenScript(ctx context.Context, model, content, prompt str
 		},
 	)
 	if err != nil {
+		var apiErr genai.APIError
+		if errors.As(err, &apiErr) {
+			return nil, &APIError{StatusCode: apiErr.Code, Err: err}
+		}
 		return nil, fmt.Errorf("request gemini: %w", err)
 	}
 	raw := strings.TrimSpace(resp.Text())
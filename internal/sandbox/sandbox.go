@@ -0,0 +1,150 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrOutside = errors.New("path outside the sandbox")
+
+// Sandbox resolves client supplied paths to files under a fixed set of root
+// directories, the first root being the one relative paths are taken from
+type Sandbox struct {
+	roots []string
+}
+
+// New makes the roots if needed and keeps their real location
+func New(roots ...string) (*Sandbox, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("sandbox without roots")
+	}
+	s := &Sandbox{}
+	for _, r := range roots {
+		if err := os.MkdirAll(r, 0o755); err != nil {
+			return nil, err
+		}
+		abs, err := filepath.Abs(r)
+		if err != nil {
+			return nil, err
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return nil, err
+		}
+		s.roots = append(s.roots, real)
+	}
+	return s, nil
+}
+
+// Root is where relative paths resolve to
+func (s *Sandbox) Root() string {
+	return s.roots[0]
+}
+
+// Resolve returns the absolute location of p, a relative p is taken from the
+// first root and an absolute one must already lie under a root; ".." and
+// symlinks that lead out of every root are rejected, the file itself need
+// not exist yet. Hidden names below a root are rejected as well, they hold
+// the server state and uploads in progress
+func (s *Sandbox) Resolve(p string) (string, error) {
+	if strings.TrimSpace(p) == "" {
+		return "", fmt.Errorf("%w: empty path", ErrOutside)
+	}
+	if strings.ContainsRune(p, 0) {
+		return "", fmt.Errorf("%w: %q", ErrOutside, p)
+	}
+
+	var abs string
+	if filepath.IsAbs(p) {
+		abs = filepath.Clean(p)
+	} else {
+		if !filepath.IsLocal(p) {
+			return "", fmt.Errorf("%w: %q", ErrOutside, p)
+		}
+		abs = filepath.Join(s.roots[0], p)
+	}
+
+	real, err := realPath(abs)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range s.roots {
+		if !within(root, real) {
+			continue
+		}
+		if hidden(root, real) {
+			return "", fmt.Errorf("%w: %q is hidden", ErrOutside, p)
+		}
+		return real, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrOutside, p)
+}
+
+// ResolveIn resolves p like Resolve but, when p is relative, from dir, and
+// rejects anything outside dir, it keeps a path such as a project asset
+// inside its project
+func (s *Sandbox) ResolveIn(dir, p string) (string, error) {
+	base, err := s.Resolve(dir)
+	if err != nil {
+		return "", err
+	}
+	target := p
+	if !filepath.IsAbs(p) {
+		if !filepath.IsLocal(p) {
+			return "", fmt.Errorf("%w: %q leaves %s", ErrOutside, p, dir)
+		}
+		target = filepath.Join(base, p)
+	}
+	real, err := s.Resolve(target)
+	if err != nil {
+		return "", err
+	}
+	if !within(base, real) {
+		return "", fmt.Errorf("%w: %q leaves %s", ErrOutside, p, dir)
+	}
+	return real, nil
+}
+
+// realPath resolves the symlinks of the longest existing prefix of abs and
+// appends the part that does not exist yet
+func realPath(abs string) (string, error) {
+	var rest []string
+	dir := abs
+	for {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(dir)}, rest...)
+		dir = parent
+	}
+}
+
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// hidden reports whether an element of p below root starts with a dot
+func hidden(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." {
+		return false
+	}
+	for el := range strings.SplitSeq(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(el, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSandbox_Resolve(t *testing.T) {
+	base := t.TempDir()
+	store := filepath.Join(base, "store")
+	tmp := filepath.Join(base, "tmp")
+	outside := filepath.Join(base, "outside")
+	if err := os.MkdirAll(filepath.Join(store, "moon"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(store, "moon", "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	sb, err := New(store, tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, tmp = sb.roots[0], sb.roots[1]
+
+	for p, want := range map[string]string{
+		"moon/out.mp4":                     filepath.Join(store, "moon", "out.mp4"),
+		"moon/new/dir/out.mp4":             filepath.Join(store, "moon", "new", "dir", "out.mp4"),
+		filepath.Join(store, "moon/a.wav"): filepath.Join(store, "moon", "a.wav"),
+		filepath.Join(tmp, "up/a.wav"):     filepath.Join(tmp, "up", "a.wav"),
+	} {
+		got, err := sb.Resolve(p)
+		if err != nil || got != want {
+			t.Fatalf("resolve %s: got %q %v, want %q", p, got, err, want)
+		}
+	}
+
+	for _, p := range []string{
+		"",
+		"../outside/a",
+		"moon/../../outside/a",
+		"/etc/passwd",
+		filepath.Join(store, "..", "outside", "a"),
+		"moon/escape/a.mp4",
+		".comp0ser/tasks.jsonl",
+		filepath.Join(store, ".comp0ser", "keys.json"),
+		"moon/.uploads/a.mp4",
+	} {
+		if _, err := sb.Resolve(p); !errors.Is(err, ErrOutside) {
+			t.Fatalf("resolve %q: got %v, want ErrOutside", p, err)
+		}
+	}
+}
+
+func TestSandbox_ResolveIn(t *testing.T) {
+	store := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(store, "moon", "asset"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sb, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sb.ResolveIn("moon", "asset/bgm.mp3")
+	if err != nil || got != filepath.Join(sb.Root(), "moon", "asset", "bgm.mp3") {
+		t.Fatalf("got %q %v", got, err)
+	}
+	for _, p := range []string{"../sun/bgm.mp3", filepath.Join(sb.Root(), "sun", "bgm.mp3")} {
+		if _, err := sb.ResolveIn("moon", p); !errors.Is(err, ErrOutside) {
+			t.Fatalf("resolve %q in moon: got %v, want ErrOutside", p, err)
+		}
+	}
+}
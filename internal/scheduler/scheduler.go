@@ -0,0 +1,376 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"comp0ser/internal/worker"
+)
+
+const scheduleFile = "schedules.json"
+
+var (
+	ErrNotFound    = errors.New("schedule not found")
+	ErrBadSchedule = errors.New("bad schedule")
+)
+
+// Submitter is the part of worker.Worker the scheduler enqueues into
+type Submitter interface {
+	Submit(context.Context, worker.TaskType, any, ...worker.SubmitOption) (string, error)
+}
+
+// Schedule submits a task of Type with Payload on every Cron match, or once
+// at RunAt
+type Schedule struct {
+	ID      string          `json:"id"`
+	Name    string          `json:"name,omitempty"`
+	Type    worker.TaskType `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+
+	Cron  string     `json:"cron,omitempty"`
+	RunAt *time.Time `json:"runAt,omitempty"`
+
+	CallbackURL string `json:"callbackUrl,omitempty"`
+
+	Paused bool `json:"paused"`
+
+	// NextRun is nil for a paused schedule and a one-shot that already ran
+	NextRun    *time.Time `json:"nextRun,omitempty"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastTaskID string     `json:"lastTaskId,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	Runs       int        `json:"runs"`
+
+	CreatedAt time.Time `json:"createdAt"`
+
+	cron *Cron
+}
+
+// Scheduler keeps schedules in <dir>/schedules.json and submits them to the
+// worker when they are due
+type Scheduler struct {
+	mu        sync.Mutex
+	path      string
+	schedules map[string]*Schedule
+	order     []string
+
+	wk   Submitter
+	wake chan struct{}
+	done chan struct{}
+}
+
+// New loads the schedules persisted in dir
+func New(dir string, wk Submitter) (*Scheduler, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Scheduler{
+		path:      filepath.Join(dir, scheduleFile),
+		schedules: make(map[string]*Schedule),
+		wk:        wk,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Schedule
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("bad %s: %w", s.path, err)
+	}
+	for _, sc := range list {
+		if sc.Cron != "" {
+			if sc.cron, err = ParseCron(sc.Cron); err != nil {
+				return nil, fmt.Errorf("schedule %s: %w", sc.ID, err)
+			}
+		}
+		s.schedules[sc.ID] = sc
+		s.order = append(s.order, sc.ID)
+	}
+
+	slog.Info("schedules loaded",
+		"path", s.path,
+		"schedules", len(list),
+	)
+	return s, nil
+}
+
+// Run submits due schedules until ctx is done, a schedule missed while the
+// server was down runs once right away
+func (s *Scheduler) Run(ctx context.Context) {
+	defer close(s.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		next := s.fireDue(ctx, time.Now())
+
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = max(time.Until(next), 0)
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// Wait blocks until Run returned
+func (s *Scheduler) Wait() {
+	<-s.done
+}
+
+// fireDue submits every schedule due at now and returns the earliest next run
+func (s *Scheduler) fireDue(ctx context.Context, now time.Time) time.Time {
+	s.mu.Lock()
+	var due []Schedule
+	for _, id := range s.order {
+		sc := s.schedules[id]
+		if sc.NextRun != nil && !sc.NextRun.After(now) {
+			due = append(due, *sc)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sc := range due {
+		s.fire(ctx, sc, now)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, sc := range s.schedules {
+		if sc.NextRun != nil && (next.IsZero() || sc.NextRun.Before(next)) {
+			next = *sc.NextRun
+		}
+	}
+	return next
+}
+
+func (s *Scheduler) fire(ctx context.Context, sc Schedule, now time.Time) {
+	var opts []worker.SubmitOption
+	if sc.CallbackURL != "" {
+		opts = append(opts, worker.WithCallback(sc.CallbackURL))
+	}
+	taskID, err := s.wk.Submit(ctx, sc.Type, sc.Payload, opts...)
+	if errors.Is(err, worker.ErrDuplicate) {
+		// the previous run is still pending
+		err = nil
+	}
+
+	log := slog.With(
+		"schedule_id", sc.ID,
+		"task_type", sc.Type,
+		"task_id", taskID,
+	)
+	if err != nil {
+		log.Error("scheduled submit failed", "err", err)
+	} else {
+		log.Info("scheduled task submitted")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, ok := s.schedules[sc.ID]
+	if !ok {
+		// deleted meanwhile
+		return
+	}
+	cur.LastRun = &now
+	cur.Runs++
+	cur.LastTaskID = taskID
+	cur.LastError = ""
+	if err != nil {
+		cur.LastError = err.Error()
+	}
+	cur.NextRun = cur.next(now)
+	if err := s.saveLocked(); err != nil {
+		log.Error("save schedules failed", "err", err)
+	}
+}
+
+// next returns the run after now, nil for a one-shot or paused schedule
+func (sc *Schedule) next(now time.Time) *time.Time {
+	if sc.Paused || sc.cron == nil {
+		return nil
+	}
+	t := sc.cron.Next(now)
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Add validates sc and stores it, the returned copy carries the ID and the
+// first run
+func (s *Scheduler) Add(sc Schedule) (Schedule, error) {
+	switch {
+	case sc.Type == "":
+		return Schedule{}, fmt.Errorf("%w: empty type", ErrBadSchedule)
+	case (sc.Cron == "") == (sc.RunAt == nil):
+		return Schedule{}, fmt.Errorf("%w: want exactly one of cron and runAt", ErrBadSchedule)
+	}
+	if len(sc.Payload) == 0 {
+		sc.Payload = json.RawMessage("{}")
+	}
+	if !json.Valid(sc.Payload) {
+		return Schedule{}, fmt.Errorf("%w: bad payload", ErrBadSchedule)
+	}
+
+	now := time.Now()
+	if sc.Cron != "" {
+		c, err := ParseCron(sc.Cron)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("%w: %v", ErrBadSchedule, err)
+		}
+		sc.cron = c
+		if sc.NextRun = sc.next(now); sc.NextRun == nil && !sc.Paused {
+			return Schedule{}, fmt.Errorf("%w: cron %q never matches", ErrBadSchedule, sc.Cron)
+		}
+	} else if !sc.Paused {
+		at := *sc.RunAt
+		sc.NextRun = &at
+	}
+
+	sc.ID = fmt.Sprintf("sched_%d", now.UnixNano())
+	sc.CreatedAt = now
+	sc.LastRun, sc.LastTaskID, sc.LastError, sc.Runs = nil, "", "", 0
+
+	s.mu.Lock()
+	s.schedules[sc.ID] = &sc
+	s.order = append(s.order, sc.ID)
+	err := s.saveLocked()
+	out := sc
+	s.mu.Unlock()
+
+	if err != nil {
+		return Schedule{}, err
+	}
+	s.poke()
+
+	slog.Info("schedule added",
+		"schedule_id", sc.ID,
+		"task_type", sc.Type,
+		"cron", sc.Cron,
+		"next_run", sc.NextRun,
+	)
+	return out, nil
+}
+
+func (s *Scheduler) List() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Schedule, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, *s.schedules[id])
+	}
+	return out
+}
+
+func (s *Scheduler) Get(id string) (Schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc, ok := s.schedules[id]
+	if !ok {
+		return Schedule{}, false
+	}
+	return *sc, true
+}
+
+// Pause stops a schedule from running until Resume
+func (s *Scheduler) Pause(id string) (Schedule, error) {
+	return s.update(id, func(sc *Schedule) {
+		sc.Paused = true
+		sc.NextRun = nil
+	})
+}
+
+// Resume re-arms a paused schedule, a one-shot whose time passed runs right
+// away unless it already ran
+func (s *Scheduler) Resume(id string) (Schedule, error) {
+	return s.update(id, func(sc *Schedule) {
+		sc.Paused = false
+		sc.NextRun = sc.next(time.Now())
+		if sc.cron == nil && sc.Runs == 0 {
+			at := *sc.RunAt
+			sc.NextRun = &at
+		}
+	})
+}
+
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.schedules, id)
+	s.order = slices.DeleteFunc(s.order, func(v string) bool { return v == id })
+	return s.saveLocked()
+}
+
+func (s *Scheduler) update(id string, fn func(sc *Schedule)) (Schedule, error) {
+	s.mu.Lock()
+	sc, ok := s.schedules[id]
+	if !ok {
+		s.mu.Unlock()
+		return Schedule{}, ErrNotFound
+	}
+	fn(sc)
+	err := s.saveLocked()
+	out := *sc
+	s.mu.Unlock()
+
+	s.poke()
+	return out, err
+}
+
+// poke makes Run recompute its timer
+func (s *Scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) saveLocked() error {
+	list := make([]*Schedule, 0, len(s.order))
+	for _, id := range s.order {
+		list = append(list, s.schedules[id])
+	}
+
+	raw, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"comp0ser/internal/worker"
+)
+
+func TestCron_Next(t *testing.T) {
+	loc := time.UTC
+	from := time.Date(2026, 3, 14, 10, 7, 30, 0, loc) // a saturday
+
+	for expr, want := range map[string]time.Time{
+		"* * * * *":      time.Date(2026, 3, 14, 10, 8, 0, 0, loc),
+		"*/15 * * * *":   time.Date(2026, 3, 14, 10, 15, 0, 0, loc),
+		"30 2 * * *":     time.Date(2026, 3, 15, 2, 30, 0, 0, loc),
+		"0 9 * * 1-5":    time.Date(2026, 3, 16, 9, 0, 0, 0, loc),
+		"0 0 1 * *":      time.Date(2026, 4, 1, 0, 0, 0, 0, loc),
+		"0 12 29 2 *":    time.Date(2028, 2, 29, 12, 0, 0, 0, loc),
+		"5,10 10 * * 6":  time.Date(2026, 3, 14, 10, 10, 0, 0, loc),
+		"0 0 * * 7":      time.Date(2026, 3, 15, 0, 0, 0, 0, loc),
+		"@hourly":        time.Date(2026, 3, 14, 11, 0, 0, 0, loc),
+		"0 0 13 * 5":     time.Date(2026, 3, 20, 0, 0, 0, 0, loc), // friday or the 13th
+		"0 8-18/4 * * *": time.Date(2026, 3, 14, 12, 0, 0, 0, loc),
+	} {
+		c, err := ParseCron(expr)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		if got := c.Next(from); !got.Equal(want) {
+			t.Errorf("%s: next = %v, want %v", expr, got, want)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+
+	never, _ := ParseCron("0 0 31 2 *")
+	if got := never.Next(from); !got.IsZero() {
+		t.Errorf("impossible cron matched %v", got)
+	}
+}
+
+type fakeSubmitter struct {
+	mu    sync.Mutex
+	calls []worker.TaskType
+	fired chan struct{}
+}
+
+func (f *fakeSubmitter) Submit(ctx context.Context, typ worker.TaskType, payload any, opts ...worker.SubmitOption) (string, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, typ)
+	f.mu.Unlock()
+	f.fired <- struct{}{}
+	return "task_1", nil
+}
+
+func TestScheduler_RunAt(t *testing.T) {
+	dir := t.TempDir()
+	sub := &fakeSubmitter{fired: make(chan struct{}, 1)}
+
+	s, err := New(dir, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Run(ctx)
+
+	at := time.Now().Add(50 * time.Millisecond)
+	sc, err := s.Add(Schedule{Type: worker.Render, RunAt: &at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(Schedule{Type: worker.Render, Cron: "@daily", RunAt: &at}); err == nil {
+		t.Fatal("expected an error for cron together with runAt")
+	}
+	paused, err := s.Add(Schedule{Type: worker.Concat, Cron: "* * * * *", Paused: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pause(paused.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-sub.fired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("schedule did not fire")
+	}
+	cancel()
+	s.Wait()
+
+	got, _ := s.Get(sc.ID)
+	if got.Runs != 1 || got.LastTaskID != "task_1" || got.NextRun != nil {
+		t.Fatalf("unexpected schedule after run: %+v", got)
+	}
+
+	// reloaded from disk
+	s2, err := New(dir, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := s2.List()
+	if len(list) != 2 || list[0].Runs != 1 || !list[1].Paused || list[1].NextRun != nil {
+		t.Fatalf("unexpected reloaded schedules: %+v", list)
+	}
+	if err := s2.Delete(sc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.Delete(sc.ID); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+
+	"comp0ser/internal/filestore"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	UploadAssetsChain = []gin.HandlerFunc{
+		BindForm[UploadAssetsReq](),
+		uploadAssets(),
+	}
+
+	ListAssetsChain = []gin.HandlerFunc{
+		BindQuery[ListAssetsReq](),
+		listAssets(),
+	}
+
+	DeleteAssetChain = []gin.HandlerFunc{
+		deleteAsset(),
+	}
+
+	uploadAssets = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[UploadAssetsReq](c)
+			s := MustScope(c)
+			name := c.Param("name")
+			kind := filestore.AssetKind(req.Kind)
+
+			// all or nothing, the store probes the whole batch before it
+			// replaces any file
+			uploads := make([]filestore.Upload, 0, len(req.Files))
+			for _, fh := range req.Files {
+				f, err := fh.Open()
+				if err != nil {
+					abortAssetError(c, err)
+					return
+				}
+				defer f.Close()
+				uploads = append(uploads, filestore.Upload{Filename: filepath.Base(fh.Filename), Body: f})
+			}
+			saved, err := s.Deps.FS.SaveAssets(name, kind, uploads, s.Deps.Probe)
+			if err != nil {
+				abortAssetError(c, err)
+				return
+			}
+			c.JSON(http.StatusCreated, AssetList{Assets: saved})
+		}
+	}
+
+	listAssets = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[ListAssetsReq](c)
+			s := MustScope(c)
+
+			assets, err := s.Deps.FS.Assets(c.Param("name"), filestore.AssetKind(req.Kind))
+			if err != nil {
+				abortAssetError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, AssetList{Assets: assets})
+		}
+	}
+
+	deleteAsset = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			kind := filestore.AssetKind(c.Param("kind"))
+			if err := s.Deps.FS.DeleteAsset(c.Param("name"), kind, c.Param("filename")); err != nil {
+				abortAssetError(c, err)
+				return
+			}
+			c.Status(http.StatusNoContent)
+		}
+	}
+)
+
+// abortAssetError maps filestore asset errors onto http statuses
+func abortAssetError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, filestore.ErrBadAsset):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad asset", "detail": err.Error()})
+	case errors.Is(err, filestore.ErrAssetNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		abortProjectError(c, err)
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"comp0ser/internal/auth"
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// publicRoutes answer without an api key, by the path routes.go registers
+var publicRoutes = map[string]bool{
+	"/ping":         true,
+	"/openapi.json": true,
+}
+
+// narrationCharsPerSecond is the speaking pace assumed to price the video of
+// a narration that does not exist yet
+const narrationCharsPerSecond = 4
+
+var (
+	UsageChain = []gin.HandlerFunc{
+		getUsage(),
+	}
+
+	getUsage = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+			if s.Deps.Auth == nil || s.Key == nil {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "api keys are not configured"})
+				return
+			}
+			used, reset := s.Deps.Auth.Usage(s.Key)
+			c.JSON(http.StatusOK, UsageResp{
+				Key:   s.Key.Name,
+				Scope: s.Key.Scope,
+				Usage: used,
+				Quota: s.Key.Quota,
+				Reset: reset,
+			})
+		}
+	}
+)
+
+// Authenticate finds the api key of a request, given as a bearer token or in
+// X-API-Key, and checks the scope its method needs: GET and HEAD read,
+// everything else submits; public routes and every request when no keys are
+// configured pass
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s := MustScope(c)
+		if s.Deps.Auth == nil || publicRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		key, ok := s.Deps.Auth.Lookup(apiKey(c))
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="comp0ser"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized", "detail": "missing or unknown api key"})
+			return
+		}
+		s.Key = key
+
+		need := auth.ScopeSubmit
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			need = auth.ScopeRead
+		}
+		if !requireScope(c, need) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// Require guards a route that needs more than its method implies
+func Require(need auth.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireScope(c, need) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func requireScope(c *gin.Context, need auth.Scope) bool {
+	key := MustScope(c).Key
+	if key == nil || key.Scope.Allows(need) {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden", "detail": "key " + key.Name + " has scope " + string(key.Scope) + ", " + string(need) + " is required"})
+	return false
+}
+
+func apiKey(c *gin.Context) string {
+	if v := c.GetHeader(APIKeyHeader); v != "" {
+		return strings.TrimSpace(v)
+	}
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// charge takes u from the quota of the request key, it aborts with 429 when
+// the quota would be overrun
+func charge(c *gin.Context, u auth.Usage) bool {
+	s := MustScope(c)
+	if s.Deps.Auth == nil || s.Key == nil {
+		return true
+	}
+	err := s.Deps.Auth.Charge(s.Key, u)
+	var qe *auth.QuotaError
+	if errors.As(err, &qe) {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(qe.Reset).Seconds())+1))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "quota exceeded", "detail": err.Error()})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error", "detail": err.Error()})
+		return false
+	}
+	s.Charged = s.Charged.Add(u)
+	return true
+}
+
+// refund gives back what the request was charged when it did not go through
+func refund(s *Scope) {
+	if s.Deps.Auth == nil || s.Key == nil {
+		return
+	}
+	s.Deps.Auth.Refund(s.Key, s.Charged)
+	s.Charged = auth.Usage{}
+}
+
+// usageOf estimates what a task will spend: a script is one llm call, tts is
+// priced by the characters without audio, a render by its duration and the
+// other ffmpeg and whisper work by the minutes of media it reads; a payload
+// that does not decode costs nothing as the worker rejects it anyway
+func usageOf(deps Deps, typ worker.TaskType, payload any) auth.Usage {
+	raw, ok := payload.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(payload); err != nil {
+			return auth.Usage{}
+		}
+	}
+
+	switch typ {
+	case worker.GenScript:
+		return auth.Usage{LLMCalls: 1}
+
+	case worker.GenTTSAll:
+		var p worker.GenTTSPayLoad
+		if json.Unmarshal(raw, &p) != nil {
+			return auth.Usage{}
+		}
+		return auth.Usage{TTSChars: pendingChars(deps.FS, p.Folder, "")}
+
+	case worker.GenTTSSingle:
+		var p worker.GenTTSSinglePayLoad
+		if json.Unmarshal(raw, &p) != nil {
+			return auth.Usage{}
+		}
+		return auth.Usage{TTSChars: pendingChars(deps.FS, p.Folder, p.NarID)}
+
+	case worker.Render:
+		var p worker.RenderPayLoad
+		if json.Unmarshal(raw, &p) != nil {
+			return auth.Usage{}
+		}
+		return auth.Usage{RenderMinutes: p.Dur / 60}
+
+	case worker.EpisodeBuild:
+		var p worker.EpisodePayLoad
+		if json.Unmarshal(raw, &p) != nil {
+			return auth.Usage{}
+		}
+		// the script is not written yet, price the longest one it may be,
+		// the request rules make segments and maxChars positive
+		chars := int64(max(p.Segments, 1) * max(p.MaxChars, 1))
+		dur := p.Render.Dur
+		if dur <= 0 {
+			dur = float64(chars) / narrationCharsPerSecond
+		}
+		return auth.Usage{LLMCalls: 1, TTSChars: chars, RenderMinutes: dur / 60}
+
+	case worker.Mixdown:
+		var p worker.MixdownPayLoad
+		if json.Unmarshal(raw, &p) != nil {
+			return auth.Usage{}
+		}
+		return auth.Usage{RenderMinutes: mediaMinutes(deps, p.AudioPath)}
+
+	case worker.Merge:
+		var p worker.MergePayLoad
+		if json.Unmarshal(raw, &p) != nil {
+			return auth.Usage{}
+		}
+		return auth.Usage{RenderMinutes: mediaMinutes(deps, p.VideoPath)}
+
+	case worker.Brun:
+		var p worker.BrunSubtitlePayLoad
+		if json.Unmarshal(raw, &p) != nil {
+			return auth.Usage{}
+		}
+		return auth.Usage{RenderMinutes: mediaMinutes(deps, p.VideoPath)}
+
+	case worker.GenSrt:
+		var p worker.GenSubtitlePayload
+		if json.Unmarshal(raw, &p) != nil {
+			return auth.Usage{}
+		}
+		return auth.Usage{RenderMinutes: mediaMinutes(deps, p.AudioPath)}
+	}
+	return auth.Usage{}
+}
+
+// mediaMinutes is the length of the media at path in minutes. An input that
+// cannot be probed yet, the output of an earlier task of a job, is priced by
+// that task
+func mediaMinutes(deps Deps, path string) float64 {
+	if deps.Probe == nil || path == "" {
+		return 0
+	}
+	if deps.Sandbox != nil {
+		real, err := deps.Sandbox.Resolve(path)
+		if err != nil {
+			return 0
+		}
+		path = real
+	}
+	m, err := deps.Probe(path)
+	if err != nil {
+		return 0
+	}
+	return m.Duration / 60
+}
+
+// pendingChars counts the characters of the narrations of folder that have
+// no synthesized audio, or of the narration id whatever its state
+func pendingChars(fs filestore.FileStore, folder, id string) int64 {
+	if fs == nil {
+		return 0
+	}
+	nars, err := fs.List(folder)
+	if err != nil {
+		return 0
+	}
+	var n int64
+	for _, nar := range nars {
+		if (id == "" && nar.Status != filestore.NarrationSynthesized) || nar.ID == id {
+			n += int64(utf8.RuneCountInString(nar.Text))
+		}
+	}
+	return n
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+
+	"comp0ser/internal/auth"
+	"comp0ser/internal/cmd"
+	"comp0ser/internal/worker"
+)
+
+func TestUsageOf(t *testing.T) {
+	deps := Deps{Probe: func(path string) (*cmd.MediaInfo, error) {
+		return &cmd.MediaInfo{Duration: 90}, nil
+	}}
+
+	tests := []struct {
+		name    string
+		typ     worker.TaskType
+		payload any
+		want    auth.Usage
+	}{
+		{"merge", worker.Merge, worker.MergePayLoad{VideoPath: "/store/ep1/out.mp4"}, auth.Usage{RenderMinutes: 1.5}},
+		{"mix", worker.Mixdown, worker.MixdownPayLoad{AudioPath: "/tmp/a.wav"}, auth.Usage{RenderMinutes: 1.5}},
+		{"subtitle", worker.GenSrt, worker.GenSubtitlePayload{AudioPath: "/tmp/a.wav"}, auth.Usage{RenderMinutes: 1.5}},
+		{"burn", worker.Brun, worker.BrunSubtitlePayLoad{VideoPath: "/store/ep1/final.mp4"}, auth.Usage{RenderMinutes: 1.5}},
+		{"episode", worker.EpisodeBuild, worker.EpisodePayLoad{
+			GenScriptPayLoad: worker.GenScriptPayLoad{Segments: 10, MaxChars: 120},
+		}, auth.Usage{LLMCalls: 1, TTSChars: 1200, RenderMinutes: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usageOf(deps, tt.typ, tt.payload); got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
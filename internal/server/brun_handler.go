@@ -21,6 +21,10 @@ var (
 			req := MustReq[BrunReq](c)
 			s := MustScope(c)
 
+			if !resolvePaths(c, &req.VideoPath, &req.SubtitlePath, &req.OutputPath) {
+				return
+			}
+
 			s.Type = worker.Brun
 			fmt.Println(req)
 			s.Payload = &worker.BrunSubtitlePayLoad{
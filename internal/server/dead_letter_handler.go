@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+
+	"comp0ser/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ListDeadLettersChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		listDeadLetters(),
+	}
+
+	RetryDeadLetterChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		retryDeadLetter(),
+	}
+
+	DiscardDeadLetterChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		discardDeadLetter(),
+	}
+
+	listDeadLetters = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+			c.JSON(http.StatusOK, TaskList{Tasks: s.Deps.Worker.DeadLetters()})
+		}
+	}
+
+	retryDeadLetter = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			taskID, err := s.Deps.Worker.Resubmit(c.Request.Context(), c.Param("id"))
+			if err != nil {
+				abortTaskError(c, err)
+				return
+			}
+			c.JSON(http.StatusAccepted, TaskAccepted{TaskID: taskID})
+		}
+	}
+
+	discardDeadLetter = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			if err := s.Deps.Worker.Discard(c.Param("id")); err != nil {
+				abortTaskError(c, err)
+				return
+			}
+			c.Status(http.StatusNoContent)
+		}
+	}
+)
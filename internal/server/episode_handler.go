@@ -0,0 +1,57 @@
+package server
+
+import (
+	"strings"
+
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	EpisodeChain = []gin.HandlerFunc{
+		BindJSON[EpisodeReq](),
+		preEpisode(),
+		Submit(),
+		Convert(),
+	}
+
+	preEpisode = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[EpisodeReq](c)
+
+			req.RawText = strings.TrimSpace(req.RawText)
+			req.Mix.BGM = strings.TrimSpace(req.Mix.BGM)
+			if !checkProjectPath(c, req.Subject, req.Mix.BGM) {
+				return
+			}
+
+			p := &worker.EpisodePayLoad{
+				GenScriptPayLoad: *genScriptPayload(&req.GenScriptReq),
+				TTS:              req.TTS,
+				Render: worker.EpisodeRender{
+					Dur:     req.Render.Dur,
+					TailCut: req.Render.TailCut,
+					Loop:    req.Render.Loop,
+				},
+				Mix: worker.EpisodeMix{
+					BGM:    req.Mix.BGM,
+					Volume: req.Mix.Volume,
+					Loop:   req.Mix.Loop,
+				},
+			}
+			if req.Subtitle != nil {
+				p.Subtitle = &worker.EpisodeSubtitle{
+					Lang: req.Subtitle.Lang,
+					Burn: req.Subtitle.Burn,
+				}
+			}
+
+			s := MustScope(c)
+			s.Type = worker.EpisodeBuild
+			s.Payload = p
+
+			c.Next()
+		}
+	}
+)
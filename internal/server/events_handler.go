@@ -0,0 +1,98 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keepAlive keeps proxies from closing an idle event stream
+const keepAlive = 15 * time.Second
+
+var (
+	TaskEventsChain = []gin.HandlerFunc{
+		taskEvents(),
+	}
+
+	EventsChain = []gin.HandlerFunc{
+		allEvents(),
+	}
+
+	taskEvents = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+			id := c.Param("id")
+
+			// subscribe before the snapshot so no transition falls in between
+			events, unsubscribe := s.Deps.Worker.Subscribe(id)
+			defer unsubscribe()
+
+			st, ok := s.Deps.Worker.Get(id)
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+				return
+			}
+
+			c.SSEvent(string(worker.EventState), worker.Event{
+				Type:     worker.EventState,
+				TaskID:   st.ID,
+				TaskType: st.Type,
+				State:    st.State,
+				Error:    st.Error,
+				Stage:    st.Stage,
+				Progress: st.Progress,
+				Outputs:  st.Outputs,
+				Time:     time.Now(),
+			})
+			if st.State.Done() {
+				return
+			}
+
+			// the stream ends with the terminal state of the task
+			streamEvents(c, events, func(e worker.Event) bool {
+				return e.Type == worker.EventState && e.State.Done()
+			})
+		}
+	}
+
+	allEvents = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			events, unsubscribe := s.Deps.Worker.Subscribe("")
+			defer unsubscribe()
+
+			streamEvents(c, events, func(worker.Event) bool { return false })
+		}
+	}
+)
+
+// streamEvents writes events as SSE until last reports true, the client goes
+// away or the worker shuts down
+func streamEvents(c *gin.Context, events <-chan worker.Event, last func(worker.Event) bool) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(e.Type), e)
+			return !last(e)
+		case <-ticker.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"comp0ser/internal/filestore"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	GetFileChain = []gin.HandlerFunc{
+		getFile(),
+	}
+
+	// getFile serves a project file, http.ServeContent answers Range,
+	// If-Range, If-None-Match and If-Modified-Since
+	getFile = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			f, err := s.Deps.FS.Open(c.Param("name"), c.Param("path"))
+			if err != nil {
+				abortFileError(c, err)
+				return
+			}
+			defer f.Close()
+
+			info, err := f.Stat()
+			if err != nil {
+				abortFileError(c, err)
+				return
+			}
+
+			h := c.Writer.Header()
+			h.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+			if ct := contentType(info.Name()); ct != "" {
+				h.Set("Content-Type", ct)
+			}
+			http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), f)
+		}
+	}
+)
+
+// mediaTypes covers the outputs the system registry often lacks, other
+// extensions fall back to mime and then to sniffing
+var mediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".wav":  "audio/wav",
+	".mp3":  "audio/mpeg",
+	".srt":  "application/x-subrip",
+	".vtt":  "text/vtt",
+	".txt":  "text/plain; charset=utf-8",
+	".json": "application/json",
+}
+
+func contentType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ct, ok := mediaTypes[ext]; ok {
+		return ct
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// abortFileError maps filestore file errors onto http statuses
+func abortFileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, filestore.ErrBadPath):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad path", "detail": err.Error()})
+	case errors.Is(err, filestore.ErrFileNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		abortProjectError(c, err)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"comp0ser/internal/filestore"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	fs := filestore.NewFileLocalStore(dir)
+	for _, name := range []string{"ep1", "ep2"} {
+		if _, err := fs.CreateProject(filestore.Project{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ep1", "final.mp4"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ep2", "secret.srt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "ep2", "secret.srt"), filepath.Join(dir, "ep1", "link.srt")); err != nil {
+		t.Fatal(err)
+	}
+	mux := Routes(context.Background(), Deps{FS: fs})
+
+	full := serve(mux, http.MethodGet, "/projects/ep1/files/final.mp4", "")
+	etag := full.Header().Get("ETag")
+	if full.Code != http.StatusOK || full.Body.String() != "0123456789" || etag == "" ||
+		full.Header().Get("Content-Type") != "video/mp4" || full.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("full: %d %v %q", full.Code, full.Header(), full.Body)
+	}
+
+	part := serve(mux, http.MethodGet, "/projects/ep1/files/final.mp4", "", "Range", "bytes=2-5")
+	if part.Code != http.StatusPartialContent || part.Body.String() != "2345" ||
+		part.Header().Get("Content-Range") != "bytes 2-5/10" {
+		t.Fatalf("range: %d %v %q", part.Code, part.Header(), part.Body)
+	}
+
+	cached := serve(mux, http.MethodGet, "/projects/ep1/files/final.mp4", "", "If-None-Match", etag)
+	if cached.Code != http.StatusNotModified || cached.Body.Len() != 0 {
+		t.Fatalf("if-none-match: %d %q", cached.Code, cached.Body)
+	}
+
+	tests := []struct {
+		name, target string
+		status       int
+	}{
+		{name: "dot dot", target: "/projects/ep1/files/..%2Fep2%2Fsecret.srt", status: http.StatusBadRequest},
+		{name: "symlink out", target: "/projects/ep1/files/link.srt", status: http.StatusBadRequest},
+		{name: "hidden", target: "/projects/ep1/files/.build.json", status: http.StatusBadRequest},
+		{name: "missing", target: "/projects/ep1/files/nope.mp4", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(mux, http.MethodGet, tt.target, "")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
@@ -17,6 +17,9 @@ var (
 	preGenSubtitle = func() gin.HandlerFunc {
 		return func(c *gin.Context) {
 			req := MustReq[GenSubtitleReq](c)
+			if !resolvePaths(c, &req.AudioPath, &req.OutputPath) {
+				return
+			}
 
 			s := MustScope(c)
 			s.Type = worker.GenSrt
@@ -1,17 +1,40 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"sync"
 
+	"comp0ser/internal/auth"
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/sandbox"
+	"comp0ser/internal/scheduler"
+	"comp0ser/internal/tts"
 	"comp0ser/internal/worker"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Deps struct {
-	Worker  worker.Worker
-	TmpRoot string
+	FS filestore.FileStore
+	// Auth holds the api keys, nil leaves the server open
+	Auth *auth.Keyring
+	// Probe reads the metadata of uploaded assets
+	Probe filestore.ProbeFunc
+	// Sandbox confines every path a request names to the store and TmpRoot
+	Sandbox *sandbox.Sandbox
+	// TTS lists the speech providers a request may name
+	TTS       *tts.Registry
+	Worker    worker.Worker
+	Scheduler *scheduler.Scheduler
+	TmpRoot   string
+	// CallbackHosts allow-lists the hosts of callback urls, empty allows
+	// every host
+	CallbackHosts []string
 }
 
 type Scope struct {
@@ -20,11 +43,22 @@ type Scope struct {
 
 	Type    worker.TaskType
 	Payload any
-	TaskID  any
+	TaskID  string
+	// Duplicate is set when TaskID is an earlier task the submission repeats
+	Duplicate bool
+
+	// Key is the api key of the request, Charged what it took from its quota
+	Key     *auth.Key
+	Charged auth.Usage
 
 	FailCleanup func()
 }
 
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
 const scopeKey = "__server.scope__"
 
 var scopePool = sync.Pool{
@@ -48,7 +82,25 @@ func BindJSON[T any]() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req T
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad json", "detail": err.Error()})
+			abortBind(c, "json", err)
+			return
+		}
+		if !validateReq(c, &req) {
+			return
+		}
+		MustScope(c).Req = &req
+		c.Next()
+	}
+}
+
+func BindQuery[T any]() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req T
+		if err := c.ShouldBindQuery(&req); err != nil {
+			abortBind(c, "query", err)
+			return
+		}
+		if !validateReq(c, &req) {
 			return
 		}
 		MustScope(c).Req = &req
@@ -60,7 +112,11 @@ func BindForm[T any]() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req T
 		if err := c.ShouldBind(&req); err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad form", "detail": err.Error()})
+			abortBind(c, "form", err)
+			return
+		}
+		if !validateReq(c, &req) {
+			return
 		}
 		MustScope(c).Req = &req
 		c.Next()
@@ -70,8 +126,37 @@ func BindForm[T any]() gin.HandlerFunc {
 func Submit() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		s := MustScope(c)
-		taskID, err := s.Deps.Worker.Submit(c.Request.Context(), s.Type, s.Payload)
+
+		var opts []worker.SubmitOption
+		if r, ok := s.Req.(interface{ callbackURL() string }); ok && r.callbackURL() != "" {
+			opts = append(opts, worker.WithCallback(r.callbackURL()))
+		}
+		if key := strings.TrimSpace(c.GetHeader(IdempotencyKeyHeader)); key != "" {
+			opts = append(opts, worker.WithIdempotencyKey(key))
+		}
+		if !charge(c, usageOf(s.Deps, s.Type, s.Payload)) {
+			failCleanup(s)
+			return
+		}
+
+		taskID, err := s.Deps.Worker.Submit(c.Request.Context(), s.Type, s.Payload, opts...)
+		if errors.Is(err, worker.ErrDuplicate) {
+			// the uploads of this request are not used and the earlier
+			// submission already paid
+			failCleanup(s)
+			refund(s)
+			s.Duplicate = true
+			err = nil
+		}
+		if errors.Is(err, worker.ErrWorkerStopped) {
+			failCleanup(s)
+			refund(s)
+			abortStopped(c)
+			return
+		}
 		if err != nil {
+			failCleanup(s)
+			refund(s)
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error", "detail": err.Error()})
 			return
 		}
@@ -81,9 +166,120 @@ func Submit() gin.HandlerFunc {
 	}
 }
 
+// resolvePaths maps the request paths into the sandbox in place, empty ones
+// are left alone; it aborts with 400 on a path that leaves the sandbox
+func resolvePaths(c *gin.Context, paths ...*string) bool {
+	sb := MustScope(c).Deps.Sandbox
+	if sb == nil {
+		return true
+	}
+	for _, p := range paths {
+		if *p == "" {
+			continue
+		}
+		real, err := sb.Resolve(*p)
+		if err != nil {
+			abortBadPath(c, err)
+			return false
+		}
+		*p = real
+	}
+	return true
+}
+
+// checkProjectPath makes sure rel stays inside the folder of project
+func checkProjectPath(c *gin.Context, project, rel string) bool {
+	if !checkProject(c, project) {
+		return false
+	}
+	sb := MustScope(c).Deps.Sandbox
+	if sb == nil {
+		return true
+	}
+	if _, err := sb.ResolveIn(project, rel); err != nil {
+		abortBadPath(c, err)
+		return false
+	}
+	return true
+}
+
+// projectFile returns the location of rel inside the folder of project
+func projectFile(c *gin.Context, project, rel string) (string, bool) {
+	if !checkProject(c, project) {
+		return "", false
+	}
+	s := MustScope(c)
+	if s.Deps.Sandbox == nil {
+		if !filepath.IsLocal(rel) {
+			abortBadPath(c, fmt.Errorf("%q leaves project %s", rel, project))
+			return "", false
+		}
+		return filepath.Join(s.Deps.FS.Dir(), project, rel), true
+	}
+	real, err := s.Deps.Sandbox.ResolveIn(project, rel)
+	if err != nil {
+		abortBadPath(c, err)
+		return "", false
+	}
+	return real, true
+}
+
+func checkProject(c *gin.Context, name string) bool {
+	if err := filestore.CheckProjectName(name); err != nil {
+		abortBadPath(c, err)
+		return false
+	}
+	return true
+}
+
+// CheckProject guards the routes under /projects/:name
+func CheckProject() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkProject(c, c.Param("name")) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func abortBadPath(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad path", "detail": err.Error()})
+}
+
+// abortStopped answers submissions made while the server drains, a client
+// retries against the restarted instance
+func abortStopped(c *gin.Context) {
+	c.Header("Retry-After", "30")
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server is shutting down"})
+}
+
 func Convert() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.JSON(http.StatusAccepted, gin.H{"taskID": MustScope(c).TaskID})
+		s := MustScope(c)
+		if s.Duplicate {
+			c.Header(IdempotentReplayedHeader, "true")
+			c.JSON(http.StatusOK, TaskAccepted{TaskID: s.TaskID, Duplicate: true})
+			return
+		}
+		c.JSON(http.StatusAccepted, TaskAccepted{TaskID: s.TaskID})
+	}
+}
+
+// checkCallbackURL accepts absolute http and https urls only
+func checkCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("want an absolute http(s) url, got %q", raw)
+	}
+	return nil
+}
+
+func failCleanup(s *Scope) {
+	if s.FailCleanup != nil {
+		s.FailCleanup()
 	}
 }
 
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"comp0ser/internal/auth"
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	JobChain = []gin.HandlerFunc{
+		BindJSON[JobReq](),
+		preJob(),
+		submitJob(),
+	}
+
+	// preJob checks the payload of every task against the request of its
+	// type, the errors of all tasks are reported at once
+	preJob = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[JobReq](c)
+
+			var fields []FieldError
+			for i, t := range req.Tasks {
+				prefix := fmt.Sprintf("tasks[%d].payload", i)
+				fields = append(fields, checkPayload(c, prefix, worker.TaskType(t.Type), t.Payload)...)
+			}
+			if len(fields) > 0 {
+				abortInvalid(c, fields)
+				return
+			}
+			c.Next()
+		}
+	}
+
+	submitJob = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[JobReq](c)
+			s := MustScope(c)
+
+			var cost auth.Usage
+			tasks := make([]worker.JobTask, 0, len(req.Tasks))
+			for _, t := range req.Tasks {
+				tasks = append(tasks, worker.JobTask{
+					Name:        t.Name,
+					Type:        worker.TaskType(t.Type),
+					Payload:     t.Payload,
+					DependsOn:   t.DependsOn,
+					CallbackURL: t.CallbackURL,
+				})
+				cost = cost.Add(usageOf(s.Deps, worker.TaskType(t.Type), t.Payload))
+			}
+			if !charge(c, cost) {
+				return
+			}
+
+			ids, err := s.Deps.Worker.SubmitJob(c.Request.Context(), tasks)
+			if err != nil {
+				refund(s)
+				if errors.Is(err, worker.ErrInvalidJob) {
+					c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad job", "detail": err.Error()})
+					return
+				}
+				if errors.Is(err, worker.ErrWorkerStopped) {
+					abortStopped(c)
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error", "detail": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusAccepted, JobAccepted{Tasks: ids})
+		}
+	}
+)
@@ -22,6 +22,10 @@ var (
 			fmt.Println(req)
 			s := MustScope(c)
 
+			if !resolvePaths(c, &req.VideoPath, &req.AudioPath, &req.OutPath) {
+				return
+			}
+
 			s.Type = worker.Merge
 			s.Payload = &worker.MergePayLoad{
 				AudioPath: req.AudioPath,
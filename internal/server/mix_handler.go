@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -32,11 +33,35 @@ var (
 
 			s.FailCleanup = func() { _ = os.RemoveAll(dir) }
 
-			audioName := filepath.Base(req.Audio.Filename)
-			bgmName := filepath.Base(req.BGM.Filename)
+			audioPath, ok := uploadPath(c, dir, req.Audio.Filename)
+			if !ok {
+				s.FailCleanup()
+				return
+			}
+			bgmPath, ok := uploadPath(c, dir, req.BGM.Filename)
+			if !ok {
+				s.FailCleanup()
+				return
+			}
 
-			audioPath := filepath.Join(dir, audioName)
-			bgmPath := filepath.Join(dir, bgmName)
+			// a bare file name lands in the project folder, a path goes
+			// through the sandbox; the upload dir is removed once the task
+			// finished, so nothing is written there
+			filename := strings.TrimSpace(req.Filename)
+			if filepath.Base(filename) == filename {
+				if req.Folder == "" {
+					s.FailCleanup()
+					abortInvalid(c, []FieldError{{Field: "folder", Rule: "required", Message: "is required for a bare filename"}})
+					return
+				}
+				if filename, ok = projectFile(c, req.Folder, filename); !ok {
+					s.FailCleanup()
+					return
+				}
+			} else if !resolvePaths(c, &filename) {
+				s.FailCleanup()
+				return
+			}
 
 			if err := c.SaveUploadedFile(req.Audio, audioPath); err != nil {
 				s.FailCleanup()
@@ -54,7 +79,7 @@ var (
 			s.Payload = &worker.MixdownPayLoad{
 				AudioPath: audioPath,
 				BGMPath:   bgmPath,
-				Filename:  strings.TrimSpace(req.Filename),
+				Filename:  filename,
 				Loop:      req.Loop,
 			}
 
@@ -62,3 +87,13 @@ var (
 		}
 	}
 )
+
+// uploadPath places an uploaded file named by the client in dir
+func uploadPath(c *gin.Context, dir, name string) (string, bool) {
+	name = filepath.Base(name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		abortBadPath(c, fmt.Errorf("bad upload name %q", name))
+		return "", false
+	}
+	return filepath.Join(dir, name), true
+}
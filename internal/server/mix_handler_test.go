@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/sandbox"
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// submitRecorder keeps the payload of the last submission
+type submitRecorder struct {
+	worker.Worker
+	payload any
+}
+
+func (r *submitRecorder) Submit(ctx context.Context, typ worker.TaskType, payload any, opts ...worker.SubmitOption) (string, error) {
+	r.payload = payload
+	return "t1", nil
+}
+
+func mixdownForm(t *testing.T, fields map[string]string) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, field := range []string{"audio", "bgm"} {
+		w, err := mw.CreateFormFile(field, field+".wav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte("RIFF"))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String(), mw.FormDataContentType()
+}
+
+func TestMixdownFilename(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	store, tmp := filepath.Join(dir, "store"), filepath.Join(dir, "tmp")
+	fs := filestore.NewFileLocalStore(store)
+	if _, err := fs.CreateProject(filestore.Project{Name: "ep1"}); err != nil {
+		t.Fatal(err)
+	}
+	sb, err := sandbox.New(store, tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &submitRecorder{}
+	mux := Routes(context.Background(), Deps{FS: fs, Sandbox: sb, Worker: rec, TmpRoot: tmp})
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		status int
+		want   string
+	}{
+		{name: "bare name in the project", fields: map[string]string{"folder": "ep1", "filename": "mix.m4a"}, status: http.StatusAccepted, want: filepath.Join(sb.Root(), "ep1", "mix.m4a")},
+		{name: "store path", fields: map[string]string{"filename": "ep1/out/mix.m4a"}, status: http.StatusAccepted, want: filepath.Join(sb.Root(), "ep1", "out", "mix.m4a")},
+		{name: "bare name without a folder", fields: map[string]string{"filename": "mix.m4a"}, status: http.StatusBadRequest},
+		{name: "unknown folder", fields: map[string]string{"folder": "ep9", "filename": "mix.m4a"}, status: http.StatusBadRequest},
+		{name: "hidden name", fields: map[string]string{"folder": "ep1", "filename": ".mix.m4a"}, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec.payload = nil
+			body, contentType := mixdownForm(t, tt.fields)
+			resp := serve(mux, http.MethodPost, "/mix", body, "Content-Type", contentType)
+			if resp.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", resp.Code, tt.status, resp.Body)
+			}
+			if tt.want == "" {
+				return
+			}
+			p, ok := rec.payload.(*worker.MixdownPayLoad)
+			if !ok || p.Filename != tt.want {
+				t.Fatalf("payload %+v, want filename %s", rec.payload, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"comp0ser/internal/filestore"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ListNarrationsChain = []gin.HandlerFunc{
+		listNarrations(),
+	}
+
+	GetNarrationChain = []gin.HandlerFunc{
+		getNarration(),
+	}
+
+	InsertNarrationChain = []gin.HandlerFunc{
+		BindJSON[InsertNarrationReq](),
+		insertNarration(),
+	}
+
+	EditNarrationChain = []gin.HandlerFunc{
+		BindJSON[EditNarrationReq](),
+		editNarration(),
+	}
+
+	DeleteNarrationChain = []gin.HandlerFunc{
+		deleteNarration(),
+	}
+
+	ReorderNarrationsChain = []gin.HandlerFunc{
+		BindJSON[ReorderNarrationsReq](),
+		reorderNarrations(),
+	}
+
+	SplitNarrationChain = []gin.HandlerFunc{
+		BindJSON[SplitNarrationReq](),
+		splitNarration(),
+	}
+
+	JoinNarrationsChain = []gin.HandlerFunc{
+		BindJSON[JoinNarrationsReq](),
+		joinNarrations(),
+	}
+
+	listNarrations = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			nars, err := s.Deps.FS.List(c.Param("name"))
+			if err != nil {
+				abortNarrationError(c, err)
+				return
+			}
+			if nars == nil {
+				nars = []filestore.Narration{}
+			}
+			c.JSON(http.StatusOK, NarrationList{Narrations: nars})
+		}
+	}
+
+	getNarration = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			nars, err := s.Deps.FS.List(c.Param("name"))
+			if err != nil {
+				abortNarrationError(c, err)
+				return
+			}
+			for _, nar := range nars {
+				if nar.ID == c.Param("id") {
+					c.JSON(http.StatusOK, nar)
+					return
+				}
+			}
+			abortNarrationError(c, filestore.ErrNarrationNotFound)
+		}
+	}
+
+	insertNarration = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[InsertNarrationReq](c)
+			s := MustScope(c)
+
+			at := -1
+			if req.Index != nil {
+				at = *req.Index
+			}
+			nar, err := s.Deps.FS.Insert(c.Param("name"), at, filestore.Narration{
+				Text:  req.Text,
+				Voice: req.Voice,
+				Meta:  req.Meta,
+			})
+			if err != nil {
+				abortNarrationError(c, err)
+				return
+			}
+			c.JSON(http.StatusCreated, nar)
+		}
+	}
+
+	editNarration = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[EditNarrationReq](c)
+			s := MustScope(c)
+
+			nar, err := s.Deps.FS.Update(c.Param("name"), c.Param("id"), func(nar *filestore.Narration) error {
+				stale := false
+				if req.Text != nil && *req.Text != nar.Text {
+					nar.Text = *req.Text
+					stale = true
+				}
+				if req.Voice != nil && *req.Voice != nar.Voice {
+					nar.Voice = *req.Voice
+					stale = true
+				}
+				if req.Meta != nil {
+					nar.Meta = req.Meta
+				}
+				if stale {
+					nar.Invalidate()
+				}
+				return nil
+			})
+			if err != nil {
+				abortNarrationError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, nar)
+		}
+	}
+
+	deleteNarration = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			if err := s.Deps.FS.Delete(c.Param("name"), c.Param("id")); err != nil {
+				abortNarrationError(c, err)
+				return
+			}
+			c.Status(http.StatusNoContent)
+		}
+	}
+
+	reorderNarrations = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[ReorderNarrationsReq](c)
+			s := MustScope(c)
+
+			nars, err := s.Deps.FS.Reorder(c.Param("name"), req.IDs)
+			if err != nil {
+				abortNarrationError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, NarrationList{Narrations: nars})
+		}
+	}
+
+	splitNarration = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[SplitNarrationReq](c)
+			s := MustScope(c)
+
+			nars, err := s.Deps.FS.Split(c.Param("name"), c.Param("id"), req.At)
+			if err != nil {
+				abortNarrationError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, NarrationList{Narrations: nars})
+		}
+	}
+
+	joinNarrations = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[JoinNarrationsReq](c)
+			s := MustScope(c)
+
+			nar, err := s.Deps.FS.Join(c.Param("name"), req.IDs, req.Separator)
+			if err != nil {
+				abortNarrationError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, nar)
+		}
+	}
+)
+
+// abortNarrationError maps filestore narration errors onto http statuses
+func abortNarrationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, filestore.ErrNarrationNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, filestore.ErrBadEdit):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad edit", "detail": err.Error()})
+	default:
+		abortProjectError(c, err)
+	}
+}
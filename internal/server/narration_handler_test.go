@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"comp0ser/internal/filestore"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNarrationRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fs := filestore.NewFileLocalStore(t.TempDir())
+	if _, err := fs.CreateProject(filestore.Project{Name: "ep1"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := fs.Append("ep1", filestore.Narration{Text: "one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Append("ep1", filestore.Narration{Text: "two"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Update("ep1", first.ID, func(nar *filestore.Narration) error {
+		nar.AudioID = "a1"
+		nar.Duration = 1.5
+		nar.Status = filestore.NarrationSynthesized
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mux := Routes(context.Background(), Deps{FS: fs})
+	one := "/projects/ep1/narrations/" + first.ID
+
+	tests := []struct {
+		name, method, target, body string
+		status                     int
+	}{
+		{name: "unknown project", method: http.MethodGet, target: "/projects/ep9/narrations", status: http.StatusNotFound},
+		{name: "unknown narration", method: http.MethodGet, target: "/projects/ep1/narrations/nope", status: http.StatusNotFound},
+		{name: "edit unknown narration", method: http.MethodPatch, target: "/projects/ep1/narrations/nope", body: `{"text":"x"}`, status: http.StatusNotFound},
+		{name: "bad split", method: http.MethodPost, target: one + "/split", body: `{"at":99}`, status: http.StatusBadRequest},
+		{name: "bad join", method: http.MethodPost, target: "/projects/ep1/narrations/join", body: `{"ids":["` + first.ID + `","` + first.ID + `"]}`, status: http.StatusBadRequest},
+		{name: "bad reorder", method: http.MethodPut, target: "/projects/ep1/narrations/order", body: `{"ids":["` + first.ID + `"]}`, status: http.StatusBadRequest},
+		// a v1 line of narration.txt is plain text, the api only takes v2 objects
+		{name: "v1 body", method: http.MethodPost, target: "/projects/ep1/narrations", body: `three`, status: http.StatusBadRequest},
+		{name: "not an object", method: http.MethodPatch, target: one, body: `["three"]`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(mux, tt.method, tt.target, tt.body); rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+
+	edit := func(body string) filestore.Narration {
+		t.Helper()
+		rec := serve(mux, http.MethodPatch, one, body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("edit %s: status %d: %s", body, rec.Code, rec.Body)
+		}
+		var nar filestore.Narration
+		if err := json.Unmarshal(rec.Body.Bytes(), &nar); err != nil {
+			t.Fatal(err)
+		}
+		return nar
+	}
+
+	// meta and an unchanged text keep the audio
+	if nar := edit(`{"text":"one","meta":{"mood":"calm"}}`); nar.AudioID != "a1" || nar.Status != filestore.NarrationSynthesized {
+		t.Fatalf("audio dropped: %+v", nar)
+	}
+	if nar := edit(`{"text":"one, fixed"}`); nar.AudioID != "" || nar.Duration != 0 || nar.Status != filestore.NarrationPending {
+		t.Fatalf("stale audio kept: %+v", nar)
+	}
+	nars, err := fs.List("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nars[0].Text != "one, fixed" || nars[0].AudioID != "" || nars[0].Meta["mood"] != "calm" {
+		t.Fatalf("stored narration %+v", nars[0])
+	}
+}
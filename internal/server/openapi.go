@@ -0,0 +1,492 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"comp0ser/internal/auth"
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/scheduler"
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIVersion is the info.version of the OpenAPI document
+const APIVersion = "1.0.0"
+
+var (
+	OpenAPIChain = []gin.HandlerFunc{
+		getOpenAPI(),
+	}
+
+	getOpenAPI = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Data(http.StatusOK, "application/json", openAPIJSON())
+		}
+	}
+)
+
+// operation documents one route of Routes, the schemas are derived from the
+// request and response types so the document follows their json tags
+type operation struct {
+	method, path string
+	id, tag      string
+	summary      string
+
+	// scope is what the api key needs, "" marks a public route
+	scope auth.Scope
+	// charged routes take from the quota of the key and may answer 429
+	charged bool
+
+	query any
+	body  any
+	// form is a multipart body
+	form any
+
+	status int
+	resp   any
+	// contentType of a response that is not json
+	contentType string
+}
+
+var operations = []operation{
+	{method: "GET", path: "/ping", id: "ping", tag: "meta", summary: "Liveness check", status: 202, resp: Pong{}},
+	{method: "GET", path: "/openapi.json", id: "getOpenAPI", tag: "meta", summary: "This document", status: 200, contentType: "application/json"},
+	{method: "GET", path: "/usage", id: "getUsage", tag: "meta", summary: "Usage and quota of the calling key this month", scope: auth.ScopeRead, status: 200, resp: UsageResp{}},
+
+	{method: "POST", path: "/projects", id: "createProject", tag: "projects", summary: "Create a project", scope: auth.ScopeSubmit, body: ProjectReq{}, status: 201, resp: filestore.Project{}},
+	{method: "GET", path: "/projects", id: "listProjects", tag: "projects", summary: "List projects", scope: auth.ScopeRead, status: 200, resp: ProjectList{}},
+	{method: "GET", path: "/projects/:name", id: "getProject", tag: "projects", summary: "Get the manifest of a project", scope: auth.ScopeRead, status: 200, resp: filestore.Project{}},
+	{method: "DELETE", path: "/projects/:name", id: "deleteProject", tag: "projects", summary: "Delete a project with all its files", scope: auth.ScopeAdmin, status: 204},
+
+	{method: "POST", path: "/projects/:name/assets", id: "uploadAssets", tag: "assets", summary: "Upload assets, all or nothing", scope: auth.ScopeSubmit, form: UploadAssetsReq{}, status: 201, resp: AssetList{}},
+	{method: "GET", path: "/projects/:name/assets", id: "listAssets", tag: "assets", summary: "List the assets of a project", scope: auth.ScopeRead, query: ListAssetsReq{}, status: 200, resp: AssetList{}},
+	{method: "DELETE", path: "/projects/:name/assets/:kind/:filename", id: "deleteAsset", tag: "assets", summary: "Delete an asset", scope: auth.ScopeSubmit, status: 204},
+
+	{method: "GET", path: "/projects/:name/files/*path", id: "getFile", tag: "files", summary: "Download a project file, Range and If-None-Match are honoured", scope: auth.ScopeRead, status: 200, contentType: "application/octet-stream"},
+	{method: "HEAD", path: "/projects/:name/files/*path", id: "headFile", tag: "files", summary: "Size, type and ETag of a project file", scope: auth.ScopeRead, status: 200},
+
+	{method: "POST", path: "/gen", id: "genScript", tag: "narrations", summary: "Generate the narration script of a project", scope: auth.ScopeSubmit, charged: true, body: GenScriptReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "GET", path: "/projects/:name/narrations", id: "listNarrations", tag: "narrations", summary: "List narrations in order", scope: auth.ScopeRead, status: 200, resp: NarrationList{}},
+	{method: "POST", path: "/projects/:name/narrations", id: "insertNarration", tag: "narrations", summary: "Append or insert a narration", scope: auth.ScopeSubmit, body: InsertNarrationReq{}, status: 201, resp: filestore.Narration{}},
+	{method: "PUT", path: "/projects/:name/narrations/order", id: "reorderNarrations", tag: "narrations", summary: "Reorder narrations", scope: auth.ScopeSubmit, body: ReorderNarrationsReq{}, status: 200, resp: NarrationList{}},
+	{method: "POST", path: "/projects/:name/narrations/join", id: "joinNarrations", tag: "narrations", summary: "Join consecutive narrations", scope: auth.ScopeSubmit, body: JoinNarrationsReq{}, status: 200, resp: filestore.Narration{}},
+	{method: "GET", path: "/projects/:name/narrations/:id", id: "getNarration", tag: "narrations", summary: "Get a narration", scope: auth.ScopeRead, status: 200, resp: filestore.Narration{}},
+	{method: "PATCH", path: "/projects/:name/narrations/:id", id: "editNarration", tag: "narrations", summary: "Edit a narration, a new text or voice drops its audio", scope: auth.ScopeSubmit, body: EditNarrationReq{}, status: 200, resp: filestore.Narration{}},
+	{method: "DELETE", path: "/projects/:name/narrations/:id", id: "deleteNarration", tag: "narrations", summary: "Delete a narration and its audio", scope: auth.ScopeSubmit, status: 204},
+	{method: "POST", path: "/projects/:name/narrations/:id/split", id: "splitNarration", tag: "narrations", summary: "Split a narration at a rune offset", scope: auth.ScopeSubmit, body: SplitNarrationReq{}, status: 200, resp: NarrationList{}},
+
+	{method: "POST", path: "/tts/single", id: "ttsSingle", tag: "tasks", summary: "Synthesize one narration", scope: auth.ScopeSubmit, charged: true, body: TTSGenSingleReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "POST", path: "/tts/all", id: "ttsAll", tag: "tasks", summary: "Synthesize every narration without audio", scope: auth.ScopeSubmit, charged: true, body: TTSGenAllReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "POST", path: "/mix", id: "mixdown", tag: "tasks", summary: "Mix an uploaded narration with background music", scope: auth.ScopeSubmit, charged: true, form: MixdownReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "POST", path: "/concat", id: "concat", tag: "tasks", summary: "Concatenate the narration audio of a project", scope: auth.ScopeSubmit, body: ConcatReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "POST", path: "/render", id: "render", tag: "tasks", summary: "Render the video track from the project assets", scope: auth.ScopeSubmit, charged: true, body: RenderReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "POST", path: "/merge", id: "merge", tag: "tasks", summary: "Merge a video with an audio track", scope: auth.ScopeSubmit, charged: true, body: MergeReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "POST", path: "/subtitle", id: "genSubtitle", tag: "tasks", summary: "Transcribe audio into srt subtitles", scope: auth.ScopeSubmit, charged: true, body: GenSubtitleReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "POST", path: "/brun", id: "burnSubtitle", tag: "tasks", summary: "Burn subtitles into a video", scope: auth.ScopeSubmit, charged: true, body: BrunReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "POST", path: "/episodes", id: "buildEpisode", tag: "tasks", summary: "Build a whole episode from raw text, segments and maxChars are required", scope: auth.ScopeSubmit, charged: true, body: EpisodeReq{}, status: 202, resp: TaskAccepted{}},
+	{method: "POST", path: "/jobs", id: "submitJob", tag: "tasks", summary: "Submit a graph of dependent tasks", scope: auth.ScopeSubmit, charged: true, body: JobReq{}, status: 202, resp: JobAccepted{}},
+
+	{method: "GET", path: "/tasks", id: "listTasks", tag: "tasks", summary: "List tasks", scope: auth.ScopeRead, query: ListTasksReq{}, status: 200, resp: TaskList{}},
+	{method: "GET", path: "/tasks/:id", id: "getTask", tag: "tasks", summary: "Get the status of a task", scope: auth.ScopeRead, status: 200, resp: worker.TaskStatus{}},
+	{method: "GET", path: "/tasks/:id/events", id: "taskEvents", tag: "events", summary: "Stream the events of a task until it finishes", scope: auth.ScopeRead, status: 200, resp: worker.Event{}, contentType: "text/event-stream"},
+	{method: "DELETE", path: "/tasks/:id", id: "cancelTask", tag: "tasks", summary: "Cancel a queued or running task", scope: auth.ScopeSubmit, status: 200, resp: worker.TaskStatus{}},
+	{method: "POST", path: "/tasks/:id/resume", id: "resumeTask", tag: "tasks", summary: "Resume an interrupted task", scope: auth.ScopeSubmit, status: 202, resp: TaskAccepted{}},
+
+	{method: "GET", path: "/events", id: "events", tag: "events", summary: "Stream the events of every task", scope: auth.ScopeRead, status: 200, resp: worker.Event{}, contentType: "text/event-stream"},
+
+	{method: "POST", path: "/schedules", id: "addSchedule", tag: "schedules", summary: "Add a cron or one-shot schedule", scope: auth.ScopeAdmin, body: ScheduleReq{}, status: 201, resp: scheduler.Schedule{}},
+	{method: "GET", path: "/schedules", id: "listSchedules", tag: "schedules", summary: "List schedules", scope: auth.ScopeAdmin, status: 200, resp: ScheduleList{}},
+	{method: "GET", path: "/schedules/:id", id: "getSchedule", tag: "schedules", summary: "Get a schedule", scope: auth.ScopeAdmin, status: 200, resp: scheduler.Schedule{}},
+	{method: "POST", path: "/schedules/:id/pause", id: "pauseSchedule", tag: "schedules", summary: "Pause a schedule", scope: auth.ScopeAdmin, status: 200, resp: scheduler.Schedule{}},
+	{method: "POST", path: "/schedules/:id/resume", id: "resumeSchedule", tag: "schedules", summary: "Resume a schedule", scope: auth.ScopeAdmin, status: 200, resp: scheduler.Schedule{}},
+	{method: "DELETE", path: "/schedules/:id", id: "deleteSchedule", tag: "schedules", summary: "Delete a schedule", scope: auth.ScopeAdmin, status: 204},
+
+	{method: "GET", path: "/dead-letters", id: "listDeadLetters", tag: "dead letters", summary: "List the tasks that failed for good", scope: auth.ScopeAdmin, status: 200, resp: TaskList{}},
+	{method: "POST", path: "/dead-letters/:id/retry", id: "retryDeadLetter", tag: "dead letters", summary: "Resubmit a dead letter as a new task", scope: auth.ScopeAdmin, status: 202, resp: TaskAccepted{}},
+	{method: "DELETE", path: "/dead-letters/:id", id: "discardDeadLetter", tag: "dead letters", summary: "Drop a dead letter", scope: auth.ScopeAdmin, status: 204},
+}
+
+// taskPayloads are the payloads a job or schedule carries per task type
+var taskPayloads = map[worker.TaskType]any{
+	worker.GenScript:    worker.GenScriptPayLoad{},
+	worker.GenTTSAll:    worker.GenTTSPayLoad{},
+	worker.GenTTSSingle: worker.GenTTSSinglePayLoad{},
+	worker.Mixdown:      worker.MixdownPayLoad{},
+	worker.Concat:       worker.ConcatPayLoad{},
+	worker.Render:       worker.RenderPayLoad{},
+	worker.Merge:        worker.MergePayLoad{},
+	worker.GenSrt:       worker.GenSubtitlePayload{},
+	worker.Brun:         worker.BrunSubtitlePayLoad{},
+	worker.EpisodeBuild: worker.EpisodePayLoad{},
+}
+
+// enums lists the values of the string types clients switch on
+var enums = map[reflect.Type][]string{
+	reflect.TypeFor[worker.TaskState](): {
+		string(worker.TaskQueued), string(worker.TaskWaiting), string(worker.TaskRunning),
+		string(worker.TaskSucceeded), string(worker.TaskFailed), string(worker.TaskCancelled),
+		string(worker.TaskInterrupted),
+	},
+	reflect.TypeFor[worker.EventType](): {
+		string(worker.EventState), string(worker.EventStage), string(worker.EventProgress),
+		string(worker.EventSegment), string(worker.EventOutput),
+	},
+	reflect.TypeFor[filestore.NarrationStatus](): {
+		string(filestore.NarrationPending), string(filestore.NarrationSynthesized), string(filestore.NarrationFailed),
+	},
+	reflect.TypeFor[filestore.AssetKind](): {
+		string(filestore.AssetVideo), string(filestore.AssetStill), string(filestore.AssetBGM),
+	},
+	reflect.TypeFor[auth.Scope](): {
+		string(auth.ScopeRead), string(auth.ScopeSubmit), string(auth.ScopeAdmin),
+	},
+}
+
+var openAPIJSON = sync.OnceValue(func() []byte {
+	raw, err := json.Marshal(OpenAPI())
+	if err != nil {
+		panic(fmt.Sprintf("marshal openapi document: %v", err))
+	}
+	return raw
+})
+
+// OpenAPI builds the OpenAPI 3 document of the routes
+func OpenAPI() map[string]any {
+	b := &schemaBuilder{
+		defs:  make(map[string]any),
+		names: make(map[string]reflect.Type),
+	}
+	b.schema(reflect.TypeFor[ErrorResp](), false)
+
+	paths := make(map[string]map[string]any)
+	for _, op := range operations {
+		path, params := openAPIPath(op.path)
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+		paths[path][strings.ToLower(op.method)] = b.operation(op, params)
+	}
+
+	payloads := make(map[string]any, len(taskPayloads))
+	for typ, p := range taskPayloads {
+		payloads[string(typ)] = b.schema(reflect.TypeOf(p), false)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "comp0ser",
+			"version":     APIVersion,
+			"description": "Narrated video production: scripts, tts, ffmpeg rendering and subtitles run as background tasks.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.defs,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": APIKeyHeader},
+			},
+		},
+		// the payload of a job task or schedule by task type
+		"x-task-payloads": payloads,
+	}
+}
+
+func (b *schemaBuilder) operation(op operation, params []any) map[string]any {
+	out := map[string]any{
+		"operationId": op.id,
+		"summary":     op.summary,
+		"tags":        []string{op.tag},
+	}
+
+	if op.query != nil {
+		t := reflect.TypeOf(op.query)
+		props, required := b.properties(t, true)
+		for _, f := range sortedKeys(props) {
+			params = append(params, map[string]any{
+				"name":     f,
+				"in":       "query",
+				"required": slices.Contains(required, f),
+				"schema":   props[f],
+			})
+		}
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	switch {
+	case op.body != nil:
+		out["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(op.body), false)},
+			},
+		}
+	case op.form != nil:
+		out["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"multipart/form-data": map[string]any{"schema": b.inline(reflect.TypeOf(op.form), true)},
+			},
+		}
+	}
+
+	ok := map[string]any{"description": http.StatusText(op.status)}
+	switch {
+	case op.contentType == "text/event-stream":
+		ok["content"] = map[string]any{op.contentType: map[string]any{
+			"schema": map[string]any{"type": "string", "description": "server-sent events, the data of every event is a " + reflect.TypeOf(op.resp).Name()},
+		}}
+		b.schema(reflect.TypeOf(op.resp), false)
+	case op.contentType != "":
+		ok["content"] = map[string]any{op.contentType: map[string]any{
+			"schema": map[string]any{"type": "string", "format": "binary"},
+		}}
+	case op.resp != nil:
+		ok["content"] = map[string]any{"application/json": map[string]any{
+			"schema": b.schema(reflect.TypeOf(op.resp), false),
+		}}
+	}
+	responses := map[string]any{
+		strconv.Itoa(op.status): ok,
+		"default":               errorResponse("error"),
+	}
+	if op.scope != "" {
+		responses["401"] = errorResponse("missing or unknown api key")
+		responses["403"] = errorResponse("the key lacks the " + string(op.scope) + " scope")
+		out["security"] = []any{
+			map[string]any{"bearer": []string{}},
+			map[string]any{"apiKey": []string{}},
+		}
+		out["x-scope"] = op.scope
+	} else {
+		out["security"] = []any{}
+	}
+	if op.charged {
+		responses["429"] = errorResponse("quota of the key exhausted, see Retry-After")
+	}
+	out["responses"] = responses
+	return out
+}
+
+func errorResponse(desc string) map[string]any {
+	return map[string]any{
+		"description": desc,
+		"content": map[string]any{"application/json": map[string]any{
+			"schema": map[string]any{"$ref": "#/components/schemas/ErrorResp"},
+		}},
+	}
+}
+
+// openAPIPath turns the gin parameters of path into OpenAPI ones
+func openAPIPath(path string) (string, []any) {
+	var params []any
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if p == "" || (p[0] != ':' && p[0] != '*') {
+			continue
+		}
+		name := p[1:]
+		parts[i] = "{" + name + "}"
+		params = append(params, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	return strings.Join(parts, "/"), params
+}
+
+// schemaBuilder collects the named types it meets as components
+type schemaBuilder struct {
+	defs  map[string]any
+	names map[string]reflect.Type
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+	fileHeaderType = reflect.TypeFor[multipart.FileHeader]()
+)
+
+// schema returns the schema of t, a $ref for named structs; form reads the
+// form tags instead of the json ones
+func (b *schemaBuilder) schema(t reflect.Type, form bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{}
+	case fileHeaderType:
+		return map[string]any{"type": "string", "format": "binary"}
+	}
+	if values, ok := enums[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem(), form)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem(), form)}
+	case reflect.Struct:
+		if t.Name() == "" || form {
+			return b.inline(t, form)
+		}
+		name := t.Name()
+		if prev, ok := b.names[name]; ok {
+			if prev != t {
+				panic(fmt.Sprintf("openapi: schema %s names both %v and %v", name, prev, t))
+			}
+		} else {
+			b.names[name] = t
+			b.defs[name] = nil // placeholder against recursion
+			b.defs[name] = b.inline(t, form)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func (b *schemaBuilder) inline(t reflect.Type, form bool) map[string]any {
+	props, required := b.properties(t, form)
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// properties lists the fields of struct t the way encoding/json, or gin form
+// binding, sees them, embedded structs included
+func (b *schemaBuilder) properties(t reflect.Type, form bool) (map[string]any, []string) {
+	props := make(map[string]any)
+	var required []string
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tagKey := "json"
+		if form {
+			tagKey = "form"
+		}
+		tag := f.Tag.Get(tagKey)
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			p, r := b.properties(f.Type, form)
+			for k, v := range p {
+				props[k] = v
+			}
+			required = append(required, r...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = b.constrain(b.schema(f.Type, form), f, &required, name)
+	}
+	return props, required
+}
+
+// constrain adds the binding rules of field f to its schema s, the rules
+// after dive apply to the items and are left out
+func (b *schemaBuilder) constrain(s map[string]any, f reflect.StructField, required *[]string, name string) map[string]any {
+	t := f.Type
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	items := t.Kind() == reflect.Slice || t.Kind() == reflect.Map
+	str := t.Kind() == reflect.String
+
+	for rule := range strings.SplitSeq(f.Tag.Get("binding"), ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		n, _ := strconv.ParseFloat(value, 64)
+		switch key {
+		case "dive":
+			return s
+		case "required":
+			*required = append(*required, name)
+		case "notblank":
+			s["minLength"] = 1
+		case "oneof":
+			s = map[string]any{"type": "string", "enum": strings.Fields(value)}
+		case "lang":
+			s["enum"] = slices.Sorted(maps.Keys(languages))
+		case "tasktype":
+			types := make([]string, 0, len(taskPayloads))
+			for typ := range taskPayloads {
+				types = append(types, string(typ))
+			}
+			slices.Sort(types)
+			s["enum"] = types
+		case "project":
+			s["pattern"] = `^[^./\\][^/\\]*$`
+		case "callback":
+			s["format"] = "uri"
+		case "gt":
+			s["minimum"] = n
+			s["exclusiveMinimum"] = true
+		case "gte", "min":
+			switch {
+			case items:
+				s["minItems"] = n
+			case str:
+				s["minLength"] = n
+			default:
+				s["minimum"] = n
+			}
+		case "lte", "max":
+			switch {
+			case items:
+				s["maxItems"] = n
+			case str:
+				s["maxLength"] = n
+			default:
+				s["maximum"] = n
+			}
+		}
+	}
+	return s
+}
+
+// public reports whether the route path is served without an api key
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOpenAPI_CoversRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mux := Routes(context.Background(), Deps{}).(*gin.Engine)
+
+	documented := make(map[string]bool, len(operations))
+	for _, op := range operations {
+		key := op.method + " " + op.path
+		if documented[key] {
+			t.Errorf("%s documented twice", key)
+		}
+		documented[key] = true
+		if (op.scope == "") != publicRoutes[op.path] {
+			t.Errorf("%s: documented scope %q disagrees with publicRoutes", key, op.scope)
+		}
+	}
+	for _, r := range mux.Routes() {
+		key := r.Method + " " + r.Path
+		if !documented[key] {
+			t.Errorf("route %s is missing from the openapi document", key)
+		}
+		delete(documented, key)
+	}
+	for key := range documented {
+		t.Errorf("%s is documented but not routed", key)
+	}
+}
+
+func TestOpenAPI_Document(t *testing.T) {
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage
+				Required   []string
+			}
+		}
+	}
+	if err := json.Unmarshal(openAPIJSON(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := doc.Paths["/projects/{name}/files/{path}"]["get"]; !ok {
+		t.Fatal("gin parameters are not converted")
+	}
+	sub := doc.Components.Schemas["GenSubtitleReq"]
+	for _, name := range []string{"audioPath", "outputPath", "lang", "callbackUrl"} {
+		if _, ok := sub.Properties[name]; !ok {
+			t.Errorf("GenSubtitleReq lacks %s: %v", name, sub.Properties)
+		}
+	}
+	if p := doc.Components.Schemas["ProjectReq"]; len(p.Required) != 1 || p.Required[0] != "name" {
+		t.Errorf("ProjectReq required: %v", p.Required)
+	}
+
+	// every property name is lower camel case
+	for name, s := range doc.Components.Schemas {
+		for prop := range s.Properties {
+			if prop[:1] != strings.ToLower(prop[:1]) {
+				t.Errorf("%s.%s is not camel case", name, prop)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"comp0ser/internal/auth"
+	"comp0ser/internal/filestore"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	CreateProjectChain = []gin.HandlerFunc{
+		BindJSON[ProjectReq](),
+		createProject(),
+	}
+
+	ListProjectsChain = []gin.HandlerFunc{
+		listProjects(),
+	}
+
+	GetProjectChain = []gin.HandlerFunc{
+		getProject(),
+	}
+
+	DeleteProjectChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		deleteProject(),
+	}
+
+	createProject = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[ProjectReq](c)
+			s := MustScope(c)
+
+			p, err := s.Deps.FS.CreateProject(filestore.Project{
+				Name:     req.Name,
+				Subject:  req.Subject,
+				Language: req.Language,
+				Voice:    req.Voice,
+				TTS:      req.TTS,
+				Prompt:   req.Prompt,
+			})
+			if err != nil {
+				abortProjectError(c, err)
+				return
+			}
+			c.JSON(http.StatusCreated, p)
+		}
+	}
+
+	listProjects = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			projects, err := s.Deps.FS.Projects()
+			if err != nil {
+				abortProjectError(c, err)
+				return
+			}
+			if projects == nil {
+				projects = []filestore.Project{}
+			}
+			c.JSON(http.StatusOK, ProjectList{Projects: projects})
+		}
+	}
+
+	getProject = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			p, err := s.Deps.FS.Project(c.Param("name"))
+			if err != nil {
+				abortProjectError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, p)
+		}
+	}
+
+	deleteProject = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			if err := s.Deps.FS.DeleteProject(c.Param("name")); err != nil {
+				abortProjectError(c, err)
+				return
+			}
+			c.Status(http.StatusNoContent)
+		}
+	}
+)
+
+// abortProjectError maps filestore project errors onto http statuses
+func abortProjectError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, filestore.ErrProjectNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, filestore.ErrProjectExists):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, filestore.ErrBadProjectName):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad project name", "detail": err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error", "detail": err.Error()})
+	}
+}
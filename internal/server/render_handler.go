@@ -19,6 +19,14 @@ var (
 			req := MustReq[RenderReq](c)
 			s := MustScope(c)
 
+			out := req.Out
+			if out == "" {
+				out = "out.mp4"
+			}
+			if !checkProjectPath(c, req.Folder, out) {
+				return
+			}
+
 			s.Type = worker.Render
 			s.Payload = &worker.RenderPayLoad{
 				Folder:  req.Folder,
@@ -4,22 +4,49 @@ import (
 	"context"
 	"net/http"
 
-	"comp0ser/internal/worker"
-
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
-func Routes(ctx context.Context, wk worker.Worker, tmpRoot string) http.Handler {
+func Routes(ctx context.Context, deps Deps) http.Handler {
+	// the Bind helpers run the binding rules with validate, gin's own
+	// validator knows none of the custom rules such as project_exists
+	binding.Validator = nil
+
 	mux := gin.Default()
 
-	mux.Use(PrepareScope(Deps{Worker: wk, TmpRoot: tmpRoot}))
+	mux.Use(PrepareScope(deps), Authenticate())
 
 	mux.GET("/ping", func(ctx *gin.Context) {
-		ctx.JSON(http.StatusAccepted, gin.H{"msg": "pong"})
+		ctx.JSON(http.StatusAccepted, Pong{Msg: "pong"})
 	})
+	mux.GET("/openapi.json", OpenAPIChain...)
+
+	// projects
+	mux.POST("/projects", CreateProjectChain...)
+	mux.GET("/projects", ListProjectsChain...)
+
+	project := mux.Group("/projects/:name", CheckProject())
+	project.GET("", GetProjectChain...)
+	project.DELETE("", DeleteProjectChain...)
+
+	project.POST("/assets", UploadAssetsChain...)
+	project.GET("/assets", ListAssetsChain...)
+	project.DELETE("/assets/:kind/:filename", DeleteAssetChain...)
+
+	project.GET("/files/*path", GetFileChain...)
+	project.HEAD("/files/*path", GetFileChain...)
 
 	// narration
 	mux.POST("/gen", GenScriptChain...)
+	project.GET("/narrations", ListNarrationsChain...)
+	project.POST("/narrations", InsertNarrationChain...)
+	project.PUT("/narrations/order", ReorderNarrationsChain...)
+	project.POST("/narrations/join", JoinNarrationsChain...)
+	project.GET("/narrations/:id", GetNarrationChain...)
+	project.PATCH("/narrations/:id", EditNarrationChain...)
+	project.DELETE("/narrations/:id", DeleteNarrationChain...)
+	project.POST("/narrations/:id/split", SplitNarrationChain...)
 
 	// tts
 	mux.POST("/tts/single", TTSSingleChain...)
@@ -36,5 +63,34 @@ func Routes(ctx context.Context, wk worker.Worker, tmpRoot string) http.Handler
 	mux.POST("/subtitle", GenSubtitleChain...)
 	mux.POST("/brun", BrunChain...)
 
+	// pipeline
+	mux.POST("/episodes", EpisodeChain...)
+	mux.POST("/jobs", JobChain...)
+
+	// tasks
+	mux.GET("/tasks", ListTasksChain...)
+	mux.GET("/tasks/:id", GetTaskChain...)
+	mux.GET("/tasks/:id/events", TaskEventsChain...)
+	mux.DELETE("/tasks/:id", CancelTaskChain...)
+	mux.POST("/tasks/:id/resume", ResumeTaskChain...)
+
+	// events
+	mux.GET("/events", EventsChain...)
+
+	// schedules
+	mux.POST("/schedules", AddScheduleChain...)
+	mux.GET("/schedules", ListSchedulesChain...)
+	mux.GET("/schedules/:id", GetScheduleChain...)
+	mux.POST("/schedules/:id/pause", PauseScheduleChain...)
+	mux.POST("/schedules/:id/resume", ResumeScheduleChain...)
+	mux.DELETE("/schedules/:id", DeleteScheduleChain...)
+
+	mux.GET("/usage", UsageChain...)
+
+	// dead letters
+	mux.GET("/dead-letters", ListDeadLettersChain...)
+	mux.POST("/dead-letters/:id/retry", RetryDeadLetterChain...)
+	mux.DELETE("/dead-letters/:id", DiscardDeadLetterChain...)
+
 	return mux
 }
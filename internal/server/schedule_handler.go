@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"comp0ser/internal/auth"
+	"comp0ser/internal/scheduler"
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	AddScheduleChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		BindJSON[ScheduleReq](),
+		preSchedule(),
+		addSchedule(),
+	}
+
+	ListSchedulesChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		listSchedules(),
+	}
+
+	GetScheduleChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		getSchedule(),
+	}
+
+	PauseScheduleChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		pauseSchedule(),
+	}
+
+	ResumeScheduleChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		resumeSchedule(),
+	}
+
+	DeleteScheduleChain = []gin.HandlerFunc{
+		Require(auth.ScopeAdmin),
+		deleteSchedule(),
+	}
+
+	preSchedule = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[ScheduleReq](c)
+			if fields := checkPayload(c, "payload", worker.TaskType(req.Type), req.Payload); len(fields) > 0 {
+				abortInvalid(c, fields)
+				return
+			}
+			c.Next()
+		}
+	}
+
+	addSchedule = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[ScheduleReq](c)
+			s := MustScope(c)
+
+			sc, err := s.Deps.Scheduler.Add(scheduler.Schedule{
+				Name:        req.Name,
+				Type:        worker.TaskType(req.Type),
+				Payload:     req.Payload,
+				Cron:        req.Cron,
+				RunAt:       req.RunAt,
+				CallbackURL: req.CallbackURL,
+				Paused:      req.Paused,
+			})
+			if err != nil {
+				abortScheduleError(c, err)
+				return
+			}
+			c.JSON(http.StatusCreated, sc)
+		}
+	}
+
+	listSchedules = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+			c.JSON(http.StatusOK, ScheduleList{Schedules: s.Deps.Scheduler.List()})
+		}
+	}
+
+	getSchedule = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			sc, ok := s.Deps.Scheduler.Get(c.Param("id"))
+			if !ok {
+				abortScheduleError(c, scheduler.ErrNotFound)
+				return
+			}
+			c.JSON(http.StatusOK, sc)
+		}
+	}
+
+	pauseSchedule = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			sc, err := s.Deps.Scheduler.Pause(c.Param("id"))
+			if err != nil {
+				abortScheduleError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, sc)
+		}
+	}
+
+	resumeSchedule = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			sc, err := s.Deps.Scheduler.Resume(c.Param("id"))
+			if err != nil {
+				abortScheduleError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, sc)
+		}
+	}
+
+	deleteSchedule = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			if err := s.Deps.Scheduler.Delete(c.Param("id")); err != nil {
+				abortScheduleError(c, err)
+				return
+			}
+			c.Status(http.StatusNoContent)
+		}
+	}
+)
+
+func abortScheduleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, scheduler.ErrBadSchedule):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad schedule", "detail": err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error", "detail": err.Error()})
+	}
+}
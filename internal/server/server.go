@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -43,7 +44,7 @@ func (s *Server) ServeHTTP(ctx context.Context, srv *http.Server) error {
 		errCh <- srv.Shutdown(shuwdownCtx)
 	}()
 
-	if err := srv.Serve(s.listener); err != nil {
+	if err := srv.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("failed to serve: %w", err)
 	}
 
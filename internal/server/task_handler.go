@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	GetTaskChain = []gin.HandlerFunc{
+		getTask(),
+	}
+
+	ListTasksChain = []gin.HandlerFunc{
+		BindQuery[ListTasksReq](),
+		listTasks(),
+	}
+
+	CancelTaskChain = []gin.HandlerFunc{
+		cancelTask(),
+	}
+
+	ResumeTaskChain = []gin.HandlerFunc{
+		resumeTask(),
+	}
+
+	getTask = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+
+			st, ok := s.Deps.Worker.Get(c.Param("id"))
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+				return
+			}
+			c.JSON(http.StatusOK, st)
+		}
+	}
+
+	listTasks = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := MustReq[ListTasksReq](c)
+			s := MustScope(c)
+
+			tasks := s.Deps.Worker.List(worker.TaskFilter{
+				Type:  worker.TaskType(req.Type),
+				State: worker.TaskState(req.State),
+			})
+			c.JSON(http.StatusOK, TaskList{Tasks: tasks})
+		}
+	}
+
+	cancelTask = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+			id := c.Param("id")
+
+			if err := s.Deps.Worker.Cancel(id); err != nil {
+				abortTaskError(c, err)
+				return
+			}
+
+			st, _ := s.Deps.Worker.Get(id)
+			c.JSON(http.StatusOK, st)
+		}
+	}
+
+	resumeTask = func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			s := MustScope(c)
+			id := c.Param("id")
+
+			if err := s.Deps.Worker.Resume(id); err != nil {
+				abortTaskError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusAccepted, TaskAccepted{TaskID: id})
+		}
+	}
+)
+
+// abortTaskError maps worker task errors onto http statuses
+func abortTaskError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, worker.ErrTaskNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, worker.ErrTaskFinished),
+		errors.Is(err, worker.ErrTaskNotResumable),
+		errors.Is(err, worker.ErrNotDeadLetter):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, worker.ErrWorkerStopped):
+		abortStopped(c)
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error", "detail": err.Error()})
+	}
+}
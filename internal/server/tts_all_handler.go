@@ -22,6 +22,7 @@ var (
 			s.Type = worker.GenTTSAll
 			s.Payload = &worker.GenTTSPayLoad{
 				Folder: req.Folder,
+				TTS:    req.TTS,
 			}
 			c.Next()
 		}
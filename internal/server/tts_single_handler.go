@@ -24,6 +24,7 @@ var (
 			s.Payload = &worker.GenTTSSinglePayLoad{
 				Folder: req.Folder,
 				NarID:  req.NarID,
+				TTS:    req.TTS,
 			}
 			c.Next()
 		}
@@ -1,13 +1,34 @@
 package server
 
-import "mime/multipart"
+import (
+	"encoding/json"
+	"mime/multipart"
+	"time"
+
+	"comp0ser/internal/auth"
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/scheduler"
+	"comp0ser/internal/worker"
+)
+
+// Callback is accepted by every submission, the worker posts a signed
+// summary to the url once the task finished
+type Callback struct {
+	CallbackURL string `json:"callbackUrl" form:"callbackUrl" binding:"omitempty,callback"`
+}
+
+func (c Callback) callbackURL() string {
+	return c.CallbackURL
+}
 
 type GenScriptReq struct {
-	RawText  string `json:"rawText"`
-	Subject  string `json:"subject"`
-	Segments int    `json:"segments"`
-	MinChars int    `json:"minChars"`
-	MaxChars int    `json:"maxChars"`
+	Callback
+
+	RawText  string `json:"rawText" binding:"required,notblank"`
+	Subject  string `json:"subject" binding:"required,project"`
+	Segments int    `json:"segments" binding:"gte=0"`
+	MinChars int    `json:"minChars" binding:"gte=0"`
+	MaxChars int    `json:"maxChars" binding:"gte=0"`
 
 	// focus for generated narrations
 	Focus string `json:"focus"`
@@ -19,51 +40,249 @@ type GenScriptReq struct {
 }
 
 type GenSubtitleReq struct {
-	AudioPath  string `json:"audioPath"`
-	OutputPath string `json:"OutputPath"`
-	Lang       string `json:"lang"`
+	Callback
+
+	AudioPath  string `json:"audioPath" binding:"required"`
+	OutputPath string `json:"outputPath" binding:"required"`
+	Lang       string `json:"lang" binding:"omitempty,lang"`
 }
 
 type BrunReq struct {
-	VideoPath    string `json:"videoPath"`
-	SubtitlePath string `json:"subtitlePath"`
-	OutputPath   string `json:"outputPath"`
+	Callback
+
+	VideoPath    string `json:"videoPath" binding:"required"`
+	SubtitlePath string `json:"subtitlePath" binding:"required"`
+	OutputPath   string `json:"outputPath" binding:"required"`
 }
 
 type MixdownReq struct {
+	Callback
+
+	// Folder is the project a bare Filename is written to
+	Folder   string                `form:"folder" binding:"omitempty,project,project_exists"`
 	Filename string                `form:"filename" binding:"required"`
 	Audio    *multipart.FileHeader `form:"audio" binding:"required"`
 	BGM      *multipart.FileHeader `form:"bgm" binding:"required"`
 	Loop     bool                  `form:"loop"`
 }
 
+// MixdownPayloadReq is the payload of a mixdown task in a job or schedule,
+// which points at files already in the store instead of uploading them
+type MixdownPayloadReq struct {
+	AudioPath string  `json:"audioPath" binding:"required"`
+	BGMPath   string  `json:"bgmPath" binding:"required"`
+	Filename  string  `json:"filename" binding:"required"`
+	Volume    float64 `json:"volume" binding:"gte=0,lte=4"`
+	Loop      bool    `json:"loop"`
+}
+
 type MergeReq struct {
-	VideoPath string `json:"videoPath"`
-	AudioPath string `json:"audioPath"`
-	OutPath   string `json:"outPath"`
+	Callback
+
+	VideoPath string `json:"videoPath" binding:"required"`
+	AudioPath string `json:"audioPath" binding:"required"`
+	OutPath   string `json:"outPath" binding:"required"`
 }
 
 type RenderReq struct {
-	Folder  string  `json:"folder"`
-	Dur     float64 `json:"dur"`     // 目标总时长（秒）
-	TailCut float64 `json:"tailCut"` // 每段末尾剪掉秒数（比如 10）
-	Loop    bool    `json:"loop"`    // 不够 dur 是否循环补足
-	Out     string  `json:"out"`     // 可选：输出文件名
+	Callback
+
+	Folder  string  `json:"folder" binding:"required,project,project_exists"`
+	Dur     float64 `json:"dur" binding:"gt=0"`      // 目标总时长（秒）
+	TailCut float64 `json:"tailCut" binding:"gte=0"` // 每段末尾剪掉秒数（比如 10）
+	Loop    bool    `json:"loop"`                    // 不够 dur 是否循环补足
+	Out     string  `json:"out"`                     // 可选：输出文件名
 }
 
 type ConcatReq struct {
-	Folder string `json:"folder"`
+	Callback
+
+	Folder string `json:"folder" binding:"required,project,project_exists"`
 }
 
 type TTSGenAllReq struct {
-	Folder string `json:"folder"`
+	Callback
+
+	Folder string `json:"folder" binding:"required,project,project_exists"`
+	// TTS overrides the speech provider of the project
+	TTS string `json:"tts" binding:"omitempty,ttsprovider"`
 }
 
 type TTSGenSingleReq struct {
-	Folder string `json:"folder"`
-	NarID  string `json:"narId"`
+	Callback
+
+	Folder string `json:"folder" binding:"required,project,project_exists"`
+	NarID  string `json:"narId" binding:"required"`
+	TTS    string `json:"tts" binding:"omitempty,ttsprovider"`
+}
+
+type EpisodeReq struct {
+	GenScriptReq
+
+	// TTS overrides the speech provider of the project
+	TTS string `json:"tts" binding:"omitempty,ttsprovider"`
+
+	Render   EpisodeRenderReq    `json:"render"`
+	Mix      EpisodeMixReq       `json:"mix"`
+	Subtitle *EpisodeSubtitleReq `json:"subtitle"` // 为空时不生成字幕
+}
+
+type EpisodeRenderReq struct {
+	Dur     float64 `json:"dur" binding:"gte=0"` // 目标总时长（秒），为 0 时取旁白时长
+	TailCut float64 `json:"tailCut" binding:"gte=0"`
+	Loop    bool    `json:"loop"`
+}
+
+type EpisodeMixReq struct {
+	BGM    string  `json:"bgm" binding:"required,notblank"` // 相对项目目录的 BGM 路径
+	Volume float64 `json:"volume" binding:"gte=0,lte=4"`    // BGM 音量倍数，为 0 时取 0.18
+	Loop   bool    `json:"loop"`
+}
+
+type EpisodeSubtitleReq struct {
+	Lang string `json:"lang" binding:"omitempty,lang"`
+	Burn bool   `json:"burn"` // 是否烧录进视频
+}
+
+type ListTasksReq struct {
+	Type  string `form:"type" binding:"omitempty,tasktype"`
+	State string `form:"state" binding:"omitempty,oneof=queued waiting running succeeded failed cancelled interrupted"`
 }
 
 type Narration struct {
 	Text string `json:"text"`
 }
+
+type ProjectReq struct {
+	Name     string           `json:"name" binding:"required,project"`
+	Subject  string           `json:"subject"`
+	Language string           `json:"language" binding:"omitempty,lang"`
+	Voice    string           `json:"voice"`
+	TTS      string           `json:"tts" binding:"omitempty,ttsprovider"` // empty uses the server default
+	Prompt   filestore.Prompt `json:"prompt"`
+}
+
+// UploadAssetsReq carries one or more files under "file"
+type UploadAssetsReq struct {
+	Kind  string                  `form:"kind" binding:"required,oneof=video still bgm"`
+	Files []*multipart.FileHeader `form:"file" binding:"required,min=1"`
+}
+
+type ListAssetsReq struct {
+	Kind string `form:"kind" binding:"omitempty,oneof=video still bgm"`
+}
+
+// InsertNarrationReq appends a narration, or puts it at Index
+type InsertNarrationReq struct {
+	Text  string         `json:"text" binding:"required,notblank"`
+	Index *int           `json:"index" binding:"omitnil,gte=0"`
+	Voice string         `json:"voice"`
+	Meta  map[string]any `json:"meta"`
+}
+
+// EditNarrationReq changes the given fields only, a new text or voice drops
+// the synthesized audio
+type EditNarrationReq struct {
+	Text  *string        `json:"text" binding:"omitnil,notblank"`
+	Voice *string        `json:"voice"`
+	Meta  map[string]any `json:"meta"`
+}
+
+type ReorderNarrationsReq struct {
+	IDs []string `json:"ids" binding:"required,min=1,dive,required"`
+}
+
+// SplitNarrationReq cuts the text at rune offset At
+type SplitNarrationReq struct {
+	At int `json:"at" binding:"required,min=1"`
+}
+
+type JoinNarrationsReq struct {
+	IDs       []string `json:"ids" binding:"required,min=2,dive,required"`
+	Separator string   `json:"separator"`
+}
+
+type JobReq struct {
+	Tasks []JobTaskReq `json:"tasks" binding:"required,min=1,dive"`
+}
+
+type JobTaskReq struct {
+	Callback
+
+	Name string `json:"name" binding:"required"`
+	Type string `json:"type" binding:"required,tasktype"`
+
+	// names of other tasks in the job or ids of earlier tasks
+	DependsOn []string `json:"dependsOn"`
+
+	// payload of the task type, strings may use ${name.outputs[N]}
+	Payload json.RawMessage `json:"payload"`
+}
+
+type ScheduleReq struct {
+	Callback
+
+	Name    string          `json:"name"`
+	Type    string          `json:"type" binding:"required,tasktype"`
+	Payload json.RawMessage `json:"payload"`
+
+	// exactly one of cron and runAt, cron is evaluated in server local time
+	Cron  string     `json:"cron"`
+	RunAt *time.Time `json:"runAt"`
+
+	Paused bool `json:"paused"`
+}
+
+// ErrorResp is the body of every error response
+type ErrorResp struct {
+	Error  string `json:"error"`
+	Detail string `json:"detail,omitempty"`
+	// Fields lists every field of an invalid request
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// TaskAccepted answers a submission, Duplicate is set when the
+// Idempotency-Key matched an earlier task
+type TaskAccepted struct {
+	TaskID    string `json:"taskID"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+// JobAccepted maps every task name of a job to its task ID
+type JobAccepted struct {
+	Tasks map[string]string `json:"tasks"`
+}
+
+type TaskList struct {
+	Tasks []worker.TaskStatus `json:"tasks"`
+}
+
+type ProjectList struct {
+	Projects []filestore.Project `json:"projects"`
+}
+
+type AssetList struct {
+	Assets []filestore.Asset `json:"assets"`
+}
+
+type NarrationList struct {
+	Narrations []filestore.Narration `json:"narrations"`
+}
+
+type ScheduleList struct {
+	Schedules []scheduler.Schedule `json:"schedules"`
+}
+
+// UsageResp is what the calling key used this month, Reset is when the
+// month ends
+type UsageResp struct {
+	Key   string     `json:"key"`
+	Scope auth.Scope `json:"scope"`
+	Usage auth.Usage `json:"usage"`
+	Quota auth.Quota `json:"quota"`
+	Reset time.Time  `json:"reset"`
+}
+
+type Pong struct {
+	Msg string `json:"msg"`
+}
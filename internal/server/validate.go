@@ -0,0 +1,335 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/worker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError names one invalid field of a request, Field is the json or
+// form name with the path of nested objects, e.g. "render.dur"
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// languages are the codes whisper transcribes, "auto" detects the language
+var languages = map[string]bool{
+	"auto": true,
+	"af":   true, "am": true, "ar": true, "as": true, "az": true, "ba": true, "be": true, "bg": true,
+	"bn": true, "bo": true, "br": true, "bs": true, "ca": true, "cs": true, "cy": true, "da": true,
+	"de": true, "el": true, "en": true, "es": true, "et": true, "eu": true, "fa": true, "fi": true,
+	"fo": true, "fr": true, "gl": true, "gu": true, "ha": true, "haw": true, "he": true, "hi": true,
+	"hr": true, "ht": true, "hu": true, "hy": true, "id": true, "is": true, "it": true, "ja": true,
+	"jw": true, "ka": true, "kk": true, "km": true, "kn": true, "ko": true, "la": true, "lb": true,
+	"ln": true, "lo": true, "lt": true, "lv": true, "mg": true, "mi": true, "mk": true, "ml": true,
+	"mn": true, "mr": true, "ms": true, "mt": true, "my": true, "ne": true, "nl": true, "nn": true,
+	"no": true, "oc": true, "pa": true, "pl": true, "ps": true, "pt": true, "ro": true, "ru": true,
+	"sa": true, "sd": true, "si": true, "sk": true, "sl": true, "sn": true, "so": true, "sq": true,
+	"sr": true, "su": true, "sv": true, "sw": true, "ta": true, "te": true, "tg": true, "th": true,
+	"tk": true, "tl": true, "tr": true, "tt": true, "uk": true, "ur": true, "uz": true, "vi": true,
+	"yi": true, "yo": true, "yue": true, "zh": true,
+}
+
+// validate checks the binding tags of every request, the Bind helpers pass
+// the gin context down so rules such as project_exists look at the store of
+// the request. Routes switches gin's own validator off
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.SetTagName("binding")
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		for _, key := range []string{"json", "form"} {
+			name, _, _ := strings.Cut(f.Tag.Get(key), ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return ""
+	})
+
+	must := func(err error) {
+		if err != nil {
+			panic(err)
+		}
+	}
+	must(v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	}))
+	must(v.RegisterValidation("project", func(fl validator.FieldLevel) bool {
+		return filestore.CheckProjectName(fl.Field().String()) == nil
+	}))
+	must(v.RegisterValidationCtx("project_exists", func(ctx context.Context, fl validator.FieldLevel) bool {
+		c, ok := ctx.(*gin.Context)
+		if !ok {
+			return true
+		}
+		_, err := MustScope(c).Deps.FS.Project(fl.Field().String())
+		return !errors.Is(err, filestore.ErrProjectNotFound)
+	}))
+	must(v.RegisterValidation("lang", func(fl validator.FieldLevel) bool {
+		return languages[strings.ToLower(fl.Field().String())]
+	}))
+	must(v.RegisterValidationCtx("ttsprovider", func(ctx context.Context, fl validator.FieldLevel) bool {
+		var c *gin.Context
+		switch ctx := ctx.(type) {
+		case *gin.Context:
+			c = ctx
+		case payloadCtx:
+			c = ctx.Context
+		default:
+			return true
+		}
+		reg := MustScope(c).Deps.TTS
+		if reg == nil {
+			return true
+		}
+		_, err := reg.Client(fl.Field().String())
+		return err == nil
+	}))
+	must(v.RegisterValidationCtx("callback", func(ctx context.Context, fl validator.FieldLevel) bool {
+		if checkCallbackURL(fl.Field().String()) != nil {
+			return false
+		}
+		var c *gin.Context
+		switch ctx := ctx.(type) {
+		case *gin.Context:
+			c = ctx
+		case payloadCtx:
+			c = ctx.Context
+		default:
+			return true
+		}
+		return worker.CheckCallbackHost(MustScope(c).Deps.CallbackHosts, fl.Field().String()) == nil
+	}))
+	must(v.RegisterValidation("tasktype", func(fl validator.FieldLevel) bool {
+		return worker.TaskType(fl.Field().String()).Valid()
+	}))
+
+	// an episode is charged before its script exists, from the longest one
+	// segments and maxChars allow
+	v.RegisterStructValidation(func(sl validator.StructLevel) {
+		req := sl.Current().Interface().(EpisodeReq)
+		if req.Segments <= 0 {
+			sl.ReportError(req.Segments, "segments", "Segments", "gt", "0")
+		}
+		if req.MaxChars <= 0 {
+			sl.ReportError(req.MaxChars, "maxChars", "MaxChars", "gt", "0")
+		}
+	}, EpisodeReq{})
+	return v
+}
+
+// validateReq runs the rules of req and aborts with every field that broke
+// one, it reports whether the chain can go on
+func validateReq(c *gin.Context, req any) bool {
+	fields, err := checkReq(c, req)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResp{Error: "invalid request", Detail: err.Error()})
+		return false
+	}
+	if len(fields) > 0 {
+		abortInvalid(c, fields)
+		return false
+	}
+	return true
+}
+
+func checkReq(ctx context.Context, req any) ([]FieldError, error) {
+	err := validate.StructCtx(ctx, req)
+	if err == nil {
+		return nil, nil
+	}
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil, err
+	}
+
+	fields := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, FieldError{
+			Field:   fieldPath(fe.Namespace()),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldMessage(fe),
+		})
+	}
+	return fields, nil
+}
+
+// payloadCtx validates the payload of a job task or schedule, it runs after
+// the submission so project_exists is not checked, an earlier task may create
+// the project
+type payloadCtx struct {
+	*gin.Context
+}
+
+// payloadReqs are the requests whose rules a payload of the task type follows
+var payloadReqs = map[worker.TaskType]func() any{
+	worker.GenScript:    func() any { return new(GenScriptReq) },
+	worker.GenTTSAll:    func() any { return new(TTSGenAllReq) },
+	worker.GenTTSSingle: func() any { return new(TTSGenSingleReq) },
+	worker.Mixdown:      func() any { return new(MixdownPayloadReq) },
+	worker.Concat:       func() any { return new(ConcatReq) },
+	worker.Render:       func() any { return new(RenderReq) },
+	worker.Merge:        func() any { return new(MergeReq) },
+	worker.GenSrt:       func() any { return new(GenSubtitleReq) },
+	worker.Brun:         func() any { return new(BrunReq) },
+	worker.EpisodeBuild: func() any { return new(EpisodeReq) },
+}
+
+// checkPayload decodes the payload of a task of type typ into its request
+// and runs the rules, the fields it returns are named under prefix
+func checkPayload(c *gin.Context, prefix string, typ worker.TaskType, payload json.RawMessage) []FieldError {
+	newReq, ok := payloadReqs[typ]
+	if !ok {
+		// the tasktype rule reports it
+		return nil
+	}
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+
+	req := newReq()
+	var fields []FieldError
+	if err := json.Unmarshal(payload, req); err != nil {
+		fe, ok := typeError(err)
+		if !ok {
+			fe = FieldError{Rule: "type", Param: "object", Message: "must be a json object"}
+		}
+		fields = []FieldError{fe}
+	} else if fields, err = checkReq(payloadCtx{c}, req); err != nil {
+		fields = []FieldError{{Rule: "invalid", Message: err.Error()}}
+	}
+
+	for i := range fields {
+		fields[i].Field = strings.TrimSuffix(prefix+"."+fields[i].Field, ".")
+	}
+	return fields
+}
+
+// abortBind answers a body or query that did not decode, a value of the
+// wrong type is reported like a broken rule
+func abortBind(c *gin.Context, what string, err error) {
+	if fe, ok := typeError(err); ok {
+		abortInvalid(c, []FieldError{fe})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResp{Error: "bad " + what, Detail: err.Error()})
+}
+
+func typeError(err error) (FieldError, bool) {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field == "" {
+		return FieldError{}, false
+	}
+	return FieldError{
+		Field:   typeErr.Field,
+		Rule:    "type",
+		Param:   typeErr.Type.String(),
+		Message: fmt.Sprintf("must be a %s, got a %s", jsonType(typeErr.Type), typeErr.Value),
+	}, true
+}
+
+func abortInvalid(c *gin.Context, fields []FieldError) {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Field
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResp{
+		Error:  "invalid request",
+		Detail: "invalid " + strings.Join(names, ", "),
+		Fields: fields,
+	})
+}
+
+// fieldPath drops the request type and the Go names of embedded structs
+// from ns, json flattens those
+func fieldPath(ns string) string {
+	parts := strings.Split(ns, ".")[1:]
+	out := parts[:0]
+	for _, p := range parts {
+		if r := []rune(p); len(r) > 0 && unicode.IsUpper(r[0]) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return strings.Join(out, ".")
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	p := fe.Param()
+	items := fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "notblank":
+		return "must not be blank"
+	case "gt":
+		return "must be greater than " + p
+	case "gte", "min":
+		if items {
+			return fmt.Sprintf("must have at least %s items", p)
+		}
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters long", p)
+		}
+		return "must be at least " + p
+	case "lte", "max":
+		if items {
+			return fmt.Sprintf("must have at most %s items", p)
+		}
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters long", p)
+		}
+		return "must be at most " + p
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(p), ", ")
+	case "project":
+		return fmt.Sprintf("%q is not a valid project name", fe.Value())
+	case "project_exists":
+		return fmt.Sprintf("project %q does not exist", fe.Value())
+	case "lang":
+		return fmt.Sprintf("%q is not a known language code", fe.Value())
+	case "ttsprovider":
+		return fmt.Sprintf("%q is not a configured tts provider", fe.Value())
+	case "callback":
+		return "must be an absolute http(s) url of an allowed host"
+	case "tasktype":
+		return fmt.Sprintf("%q is not a task type", fe.Value())
+	}
+	return "breaks the " + fe.Tag() + " rule"
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/tts"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBind_Validation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fs := filestore.NewFileLocalStore(t.TempDir())
+	if _, err := fs.CreateProject(filestore.Project{Name: "ep1"}); err != nil {
+		t.Fatal(err)
+	}
+	providers := tts.NewRegistry(tts.ProviderVolc)
+	volc, _ := tts.NewClient()
+	providers.Register(tts.ProviderVolc, volc)
+	mux := Routes(context.Background(), Deps{FS: fs, TTS: providers, CallbackHosts: []string{"hooks.example.com"}})
+
+	tests := []struct {
+		name, method, target, body string
+		want                       map[string]string // field -> rule
+	}{
+		{
+			name:   "render",
+			method: http.MethodPost, target: "/render",
+			body: `{"folder":"missing","dur":0,"tailCut":-1}`,
+			want: map[string]string{"folder": "project_exists", "dur": "gt", "tailCut": "gte"},
+		},
+		{
+			name:   "merge",
+			method: http.MethodPost, target: "/merge",
+			body: `{"callbackUrl":"ftp://hook"}`,
+			want: map[string]string{"videoPath": "required", "audioPath": "required", "outPath": "required", "callbackUrl": "callback"},
+		},
+		{
+			name:   "episode",
+			method: http.MethodPost, target: "/episodes",
+			body: `{"rawText":"  ","subject":"../x","mix":{"bgm":"a.mp3","volume":9},"subtitle":{"lang":"xx"}}`,
+			want: map[string]string{
+				"rawText": "notblank", "subject": "project", "segments": "gt", "maxChars": "gt",
+				"mix.volume": "lte", "subtitle.lang": "lang",
+			},
+		},
+		{
+			name:   "project",
+			method: http.MethodPost, target: "/projects",
+			body: `{"name":"ep2","language":"klingon","tts":"local"}`,
+			want: map[string]string{"language": "lang", "tts": "ttsprovider"},
+		},
+		{
+			name:   "wrong type",
+			method: http.MethodPost, target: "/render",
+			body: `{"folder":"ep1","dur":"60"}`,
+			want: map[string]string{"dur": "type"},
+		},
+		{
+			// a later task may work on the project an earlier one creates
+			name:   "job",
+			method: http.MethodPost, target: "/jobs",
+			body: `{"tasks":[
+				{"name":"gen","type":"script.gen","payload":{"subject":"ep2","rawText":" "}},
+				{"name":"tts","type":"tts.all.gen","dependsOn":["gen"],"payload":{"folder":"ep2","tts":"nope"}},
+				{"name":"render","type":"render.mp4","payload":{"folder":"ep2","dur":"60"}},
+				{"name":"mix","type":"mix.audio.bgm","payload":["a.wav"]}]}`,
+			want: map[string]string{
+				"tasks[0].payload.rawText": "notblank",
+				"tasks[1].payload.tts":     "ttsprovider",
+				"tasks[2].payload.dur":     "type",
+				"tasks[3].payload":         "type",
+			},
+		},
+		{
+			name:   "callback host",
+			method: http.MethodPost, target: "/concat",
+			body: `{"folder":"ep1","callbackUrl":"http://169.254.169.254/latest"}`,
+			want: map[string]string{"callbackUrl": "callback"},
+		},
+		{
+			name:   "schedule",
+			method: http.MethodPost, target: "/schedules",
+			body: `{"type":"m4a.merge.mp4","cron":"0 3 * * *","payload":{"videoPath":"ep1/out.mp4"}}`,
+			want: map[string]string{"payload.audioPath": "required", "payload.outPath": "required"},
+		},
+		{
+			name:   "query",
+			method: http.MethodGet, target: "/tasks?state=done&type=nope",
+			want: map[string]string{"state": "oneof", "type": "tasktype"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			var resp ErrorResp
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string, len(resp.Fields))
+			for _, f := range resp.Fields {
+				if f.Message == "" {
+					t.Errorf("%s has no message", f.Field)
+				}
+				got[f.Field] = f.Rule
+			}
+			if resp.Error != "invalid request" || len(got) != len(tt.want) {
+				t.Fatalf("fields %v, want %v", resp.Fields, tt.want)
+			}
+			for field, rule := range tt.want {
+				if got[field] != rule {
+					t.Errorf("%s broke %q, want %q", field, got[field], rule)
+				}
+			}
+		})
+	}
+}
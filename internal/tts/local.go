@@ -0,0 +1,39 @@
+package tts
+
+import (
+	"context"
+	"os"
+
+	"comp0ser/internal/cmd"
+)
+
+type localClient struct {
+	speech *cmd.Speech
+	runner *cmd.Runner
+	tmpDir string
+}
+
+// NewLocalClient synthesizes offline with piper or espeak-ng, the wav is
+// written under tmpDir and read back
+func NewLocalClient(speech *cmd.Speech, runner *cmd.Runner, tmpDir string) Client {
+	return &localClient{speech: speech, runner: runner, tmpDir: tmpDir}
+}
+
+func (c *localClient) Synthesize(ctx context.Context, content, voice string) ([]byte, error) {
+	f, err := os.CreateTemp(c.tmpDir, "tts-*.wav")
+	if err != nil {
+		return nil, err
+	}
+	out := f.Name()
+	_ = f.Close()
+	defer os.Remove(out)
+
+	command, err := c.speech.Synthesize(content, voice, out)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.runner.Run(ctx, command); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
@@ -0,0 +1,92 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	_defaultOpenAIEndpoint = "https://api.openai.com/v1/audio/speech"
+	_defaultOpenAIModel    = "tts-1"
+	_defaultOpenAIVoice    = "alloy"
+)
+
+type openAIClient struct {
+	opts options
+
+	cli *http.Client
+}
+
+type speechReq struct {
+	Model          string `json:"model"`
+	Input          string `json:"input"`
+	Voice          string `json:"voice"`
+	ResponseFormat string `json:"response_format"`
+}
+
+// NewOpenAIClient synthesizes with an openai compatible /v1/audio/speech
+// endpoint, WithEndpoint points it at a self hosted server
+func NewOpenAIClient(opts ...Option) (Client, error) {
+	o := defaultOpts()
+	o.Endpoint = _defaultOpenAIEndpoint
+	o.Model = _defaultOpenAIModel
+	o.VoiceType = _defaultOpenAIVoice
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := &http.Client{
+		Timeout: 5 * time.Minute,
+	}
+	return &openAIClient{opts: o, cli: c}, nil
+}
+
+func (c *openAIClient) Synthesize(ctx context.Context, content, voice string) ([]byte, error) {
+	body, err := json.Marshal(&speechReq{
+		Model:          c.opts.Model,
+		Input:          content,
+		Voice:          c.opts.voice(voice),
+		ResponseFormat: c.opts.Format,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.opts.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.APIKey)
+	}
+
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		msg := strings.TrimSpace(string(raw))
+		if json.Unmarshal(raw, &e) == nil && e.Error.Message != "" {
+			msg = e.Error.Message
+		}
+		return nil, &StatusError{StatusCode: resp.StatusCode, Message: msg}
+	}
+	return raw, nil
+}
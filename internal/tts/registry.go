@@ -0,0 +1,79 @@
+package tts
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// names of the providers the server knows how to configure
+const (
+	ProviderVolc   = "volc"
+	ProviderOpenAI = "openai"
+	ProviderLocal  = "local"
+)
+
+var ErrUnknownProvider = errors.New("unknown tts provider")
+
+// Registry holds the configured providers by name, a project picks one and
+// falls back to the default
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]Client
+	def       string
+}
+
+// NewRegistry makes a registry whose default is def, which must be
+// registered before the first lookup
+func NewRegistry(def string) *Registry {
+	return &Registry{
+		providers: make(map[string]Client),
+		def:       def,
+	}
+}
+
+// Register adds or replaces the provider name
+func (r *Registry) Register(name string, c Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.providers[name] = c
+}
+
+// Client returns the provider name, an empty name is the default
+func (r *Registry) Client(name string) (Client, error) {
+	if name == "" {
+		name = r.def
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+	return c, nil
+}
+
+// Resolve names the provider an empty name stands for
+func (r *Registry) Resolve(name string) string {
+	if name == "" {
+		return r.def
+	}
+	return name
+}
+
+// Names lists the registered providers in order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,95 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"comp0ser/internal/cmd"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(ProviderVolc)
+	volc, _ := NewClient()
+	r.Register(ProviderVolc, volc)
+
+	if c, err := r.Client(""); err != nil || c != volc {
+		t.Fatalf("default: %v %v", c, err)
+	}
+	if _, err := r.Client(ProviderLocal); !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("want ErrUnknownProvider, got %v", err)
+	}
+	r.Register(ProviderLocal, NewLocalClient(cmd.NewSpeech(cmd.EngineEspeak, "", ""), &cmd.Runner{}, ""))
+	if names := r.Names(); len(names) != 2 || names[0] != ProviderLocal || r.Resolve("") != ProviderVolc {
+		t.Fatalf("names %v", names)
+	}
+}
+
+func TestOpenAIClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req speechReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"message":"bad key"}}`))
+			return
+		}
+		if req.Model != "tts-1" || req.ResponseFormat != FormatWAV {
+			t.Errorf("request %+v", req)
+		}
+		_, _ = w.Write([]byte("RIFF" + req.Voice + ":" + req.Input))
+	}))
+	defer srv.Close()
+
+	c, _ := NewOpenAIClient(WithEndpoint(srv.URL), WithAPIKey("key"), WithVoiceType("nova"))
+	b, err := c.Synthesize(context.Background(), "hello", "")
+	if err != nil || string(b) != "RIFFnova:hello" {
+		t.Fatalf("synthesize: %q %v", b, err)
+	}
+	// the voice of a narration wins over the configured one
+	b, err = c.Synthesize(context.Background(), "hello", "shimmer")
+	if err != nil || string(b) != "RIFFshimmer:hello" {
+		t.Fatalf("synthesize with a voice: %q %v", b, err)
+	}
+
+	c, _ = NewOpenAIClient(WithEndpoint(srv.URL))
+	var se *StatusError
+	if _, err := c.Synthesize(context.Background(), "hello", ""); !errors.As(err, &se) || se.Message != "bad key" {
+		t.Fatalf("want the error message, got %v", err)
+	}
+}
+
+func TestLocalClient(t *testing.T) {
+	dir := t.TempDir()
+	// a piper that writes its stdin as the wav
+	bin := filepath.Join(dir, "piper")
+	script := "#!/bin/sh\n" +
+		"[ \"$1\" = --model ] && [ \"$3\" = --output_file ] || exit 1\n" +
+		"{ basename \"$2\"; cat; } > \"$4\"\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewLocalClient(cmd.NewSpeech(cmd.EnginePiper, bin, "voice.onnx"), &cmd.Runner{Timeout: time.Minute}, dir)
+	b, err := c.Synthesize(context.Background(), "offline draft", "")
+	if err != nil || string(b) != "voice.onnx\noffline draft" {
+		t.Fatalf("synthesize: %q %v", b, err)
+	}
+	// another voice is a model next to the configured one
+	b, err = c.Synthesize(context.Background(), "offline draft", "en_US-amy")
+	if err != nil || string(b) != "en_US-amy.onnx\noffline draft" {
+		t.Fatalf("synthesize with a voice: %q %v", b, err)
+	}
+	if _, err := c.Synthesize(context.Background(), "offline draft", "../../etc/x"); err == nil {
+		t.Fatal("a voice with a path was accepted")
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "tts-*")); len(left) != 0 {
+		t.Fatalf("temp files left: %v", left)
+	}
+}
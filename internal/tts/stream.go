@@ -0,0 +1,315 @@
+package tts
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	_defaultStreamEndpoint = "wss://openspeech.bytedance.com/api/v1/tts/ws_binary"
+	// the server pauses between the chunks of a long text, but not for long
+	_defaultChunkTimeout = 30 * time.Second
+)
+
+// message types of the binary protocol, the high nibble of header byte 1
+const (
+	msgFullClientRequest = 0x1
+	msgAudioOnlyResponse = 0xb
+	msgFrontendResponse  = 0xc
+	msgError             = 0xf
+)
+
+const (
+	serialJSON   = 0x1
+	compressGzip = 0x1
+)
+
+// Progress counts the audio a stream delivered so far
+type Progress struct {
+	Chunks int
+	Bytes  int64
+	// Seconds is the length of the audio, 0 for compressed encodings
+	Seconds float64
+}
+
+// Streamer is a Client that hands the audio over while it is synthesized
+type Streamer interface {
+	Client
+	// Stream writes the audio of content to w chunk by chunk and calls
+	// progress after each of them. The header of a wav stream is written
+	// with the final sizes only when w is an io.WriteSeeker
+	Stream(ctx context.Context, content, voice string, w io.Writer, progress func(Progress)) error
+}
+
+type streamClient struct {
+	opts   options
+	dialer *websocket.Dialer
+}
+
+// NewStreamClient synthesizes over volc's binary websocket protocol, which
+// has no bound on the length of a text
+func NewStreamClient(opts ...Option) (Streamer, error) {
+	o := defaultOpts()
+	o.Endpoint = _defaultStreamEndpoint
+	o.ChunkTimeout = _defaultChunkTimeout
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+	d := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	return &streamClient{opts: o, dialer: d}, nil
+}
+
+func (c *streamClient) Synthesize(ctx context.Context, content, voice string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.Stream(ctx, content, voice, &buf, nil); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	if c.opts.Format == FormatWAV {
+		copy(b, wavHeader(int64(len(b)-wavHeaderSize)))
+	}
+	return b, nil
+}
+
+func (c *streamClient) Stream(ctx context.Context, content, voice string, w io.Writer, progress func(Progress)) error {
+	// volc streams wav as pcm, the header is ours
+	encoding := c.opts.Format
+	if encoding == FormatWAV {
+		encoding = FormatPCM
+	}
+
+	var rb SynthesizeReq
+	rb.User.UID = c.opts.UID
+	rb.App.Cluster = c.opts.Cluster
+	{
+		rb.Audio.VoiceType = c.opts.voice(voice)
+		rb.Audio.Encoding = encoding
+		rb.Audio.SpeedRatio = 1.0
+		rb.Audio.Rate = SampleRate24K
+	}
+	{
+		rb.Request.ReqID = uuid.NewString()
+		rb.Request.Text = content
+		rb.Request.Operation = "submit"
+	}
+	frame, err := encodeRequest(&rb)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("x-api-key", c.opts.APIKey)
+	conn, resp, err := c.dialer.DialContext(ctx, c.opts.Endpoint, header)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return &StatusError{StatusCode: resp.StatusCode}
+		}
+		return err
+	}
+	defer conn.Close()
+	// unblocks the read below when the task is cancelled
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		return err
+	}
+
+	if c.opts.Format == FormatWAV {
+		if _, err := w.Write(wavHeader(-1)); err != nil {
+			return err
+		}
+	}
+
+	var p Progress
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(c.opts.ChunkTimeout))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("read tts stream after %d chunks: %w", p.Chunks, err)
+		}
+
+		r, err := decodeResponse(msg)
+		if err != nil {
+			return err
+		}
+		switch r.typ {
+		case msgError:
+			return &StatusError{StatusCode: resp.StatusCode, Code: int(r.code), Message: string(r.payload)}
+		case msgAudioOnlyResponse:
+			if len(r.payload) > 0 {
+				if _, err := w.Write(r.payload); err != nil {
+					return err
+				}
+				p.Chunks++
+				p.Bytes += int64(len(r.payload))
+				if encoding == FormatPCM {
+					p.Seconds = float64(p.Bytes) / (SampleRate24K * 2)
+				}
+				if progress != nil {
+					progress(p)
+				}
+			}
+			if r.last {
+				return finishWAV(w, c.opts.Format, p.Bytes)
+			}
+		}
+	}
+}
+
+// finishWAV writes the final sizes into the header of a seekable wav
+func finishWAV(w io.Writer, format string, n int64) error {
+	ws, ok := w.(io.WriteSeeker)
+	if format != FormatWAV || !ok {
+		return nil
+	}
+	if _, err := ws.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(wavHeader(n)); err != nil {
+		return err
+	}
+	_, err := ws.Seek(0, io.SeekEnd)
+	return err
+}
+
+const wavHeaderSize = 44
+
+// wavHeader describes n bytes of 16 bit mono pcm at 24kHz, a negative n
+// leaves the sizes at their maximum like an open ended stream
+func wavHeader(n int64) []byte {
+	riff, data := uint32(0xffffffff), uint32(0xffffffff)
+	if n >= 0 {
+		riff, data = uint32(n+wavHeaderSize-8), uint32(n)
+	}
+
+	h := make([]byte, 0, wavHeaderSize)
+	h = append(h, "RIFF"...)
+	h = binary.LittleEndian.AppendUint32(h, riff)
+	h = append(h, "WAVEfmt "...)
+	h = binary.LittleEndian.AppendUint32(h, 16) // fmt chunk size
+	h = binary.LittleEndian.AppendUint16(h, 1)  // pcm
+	h = binary.LittleEndian.AppendUint16(h, 1)  // mono
+	h = binary.LittleEndian.AppendUint32(h, SampleRate24K)
+	h = binary.LittleEndian.AppendUint32(h, SampleRate24K*2) // byte rate
+	h = binary.LittleEndian.AppendUint16(h, 2)               // block align
+	h = binary.LittleEndian.AppendUint16(h, 16)              // bits per sample
+	h = append(h, "data"...)
+	h = binary.LittleEndian.AppendUint32(h, data)
+	return h
+}
+
+// encodeRequest frames rb as a full client request, gzipped json behind the
+// 4 byte header and the payload size
+func encodeRequest(rb *SynthesizeReq) ([]byte, error) {
+	raw, err := json.Marshal(rb)
+	if err != nil {
+		return nil, err
+	}
+	var z bytes.Buffer
+	zw := gzip.NewWriter(&z)
+	if _, err := zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	frame := []byte{
+		0x11, // version 1, header of 1 x 4 bytes
+		msgFullClientRequest << 4,
+		serialJSON<<4 | compressGzip,
+		0x00,
+	}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(z.Len()))
+	return append(frame, z.Bytes()...), nil
+}
+
+type response struct {
+	typ     byte
+	last    bool
+	code    uint32
+	payload []byte
+}
+
+func decodeResponse(msg []byte) (response, error) {
+	if len(msg) < 4 {
+		return response{}, fmt.Errorf("tts frame of %d bytes", len(msg))
+	}
+	headerSize := int(msg[0]&0x0f) * 4
+	if headerSize < 4 || len(msg) < headerSize {
+		return response{}, fmt.Errorf("bad tts frame header %x", msg[:4])
+	}
+	r := response{typ: msg[1] >> 4}
+	flags := msg[1] & 0x0f
+	gzipped := msg[2]&0x0f == compressGzip
+	body := msg[headerSize:]
+
+	var err error
+	switch r.typ {
+	case msgAudioOnlyResponse:
+		// flags 0 acknowledges the request, the others carry a sequence
+		// number which is negative on the last chunk
+		if flags == 0 {
+			return r, nil
+		}
+		if len(body) < 8 {
+			return response{}, fmt.Errorf("short tts audio frame")
+		}
+		seq := int32(binary.BigEndian.Uint32(body))
+		r.last = seq < 0 || flags&0x2 != 0
+		r.payload, err = sized(body[4:])
+	case msgError:
+		if len(body) < 4 {
+			return response{}, fmt.Errorf("short tts error frame")
+		}
+		r.code = binary.BigEndian.Uint32(body)
+		if r.payload, err = sized(body[4:]); err == nil && gzipped {
+			r.payload, err = gunzip(r.payload)
+		}
+	case msgFrontendResponse:
+		// timestamps of the synthesized text, not used
+	default:
+		return response{}, fmt.Errorf("unknown tts message type %#x", r.typ)
+	}
+	return r, err
+}
+
+// sized reads a payload behind its big endian uint32 size
+func sized(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("short tts payload")
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return nil, fmt.Errorf("tts payload of %d bytes in a frame of %d", n, len(b)-4)
+	}
+	return b[4 : 4+n], nil
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,146 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeVolc answers one request with the frames send builds from it
+func fakeVolc(t *testing.T, send func(req SynthesizeReq) [][]byte) string {
+	t.Helper()
+	up := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg[1]>>4 != msgFullClientRequest {
+			t.Errorf("message type %#x", msg[1]>>4)
+		}
+		raw, err := gunzip(msg[8:])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var req SynthesizeReq
+		if err := json.Unmarshal(raw, &req); err != nil {
+			t.Error(err)
+			return
+		}
+		for _, f := range send(req) {
+			if err := conn.WriteMessage(websocket.BinaryMessage, f); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func audioFrame(seq int32, pcm []byte) []byte {
+	flags := byte(0x1)
+	if seq < 0 {
+		flags = 0x3
+	}
+	f := []byte{0x11, msgAudioOnlyResponse<<4 | flags, 0x00, 0x00}
+	f = binary.BigEndian.AppendUint32(f, uint32(seq))
+	f = binary.BigEndian.AppendUint32(f, uint32(len(pcm)))
+	return append(f, pcm...)
+}
+
+func TestStream(t *testing.T) {
+	url := fakeVolc(t, func(req SynthesizeReq) [][]byte {
+		if req.Request.Operation != "submit" || req.Request.Text != "hello" || req.Audio.Encoding != FormatPCM {
+			t.Errorf("request %+v", req)
+		}
+		return [][]byte{
+			{0x11, msgAudioOnlyResponse << 4, 0x00, 0x00}, // ack
+			audioFrame(1, bytes.Repeat([]byte{1}, 24000)),
+			audioFrame(2, bytes.Repeat([]byte{2}, 24000)),
+			audioFrame(-3, bytes.Repeat([]byte{3}, 48000)),
+		}
+	})
+	c, err := NewStreamClient(WithEndpoint(url), WithAPIKey("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var seen []Progress
+	if err := c.Stream(context.Background(), "hello", "", f, func(p Progress) { seen = append(seen, p) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 3 || seen[2].Bytes != 96000 || seen[2].Seconds != 2 || seen[0].Seconds != 0.5 {
+		t.Fatalf("progress %+v", seen)
+	}
+
+	wav, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wav) != wavHeaderSize+96000 || string(wav[:4]) != "RIFF" {
+		t.Fatalf("wav of %d bytes", len(wav))
+	}
+	if n := binary.LittleEndian.Uint32(wav[40:]); n != 96000 {
+		t.Fatalf("data size %d", n)
+	}
+	if wav[wavHeaderSize] != 1 || wav[len(wav)-1] != 3 {
+		t.Fatal("chunks out of order")
+	}
+
+	// Synthesize buffers the same stream
+	b, err := c.Synthesize(context.Background(), "hello", "")
+	if err != nil || !bytes.Equal(b, wav) {
+		t.Fatalf("synthesize: %d bytes, %v", len(b), err)
+	}
+}
+
+func TestStream_Errors(t *testing.T) {
+	url := fakeVolc(t, func(SynthesizeReq) [][]byte {
+		f := []byte{0x11, msgError << 4, 0x10, 0x00}
+		f = binary.BigEndian.AppendUint32(f, 3005)
+		f = binary.BigEndian.AppendUint32(f, 4)
+		return [][]byte{audioFrame(1, []byte{1, 2}), append(f, "busy"...)}
+	})
+
+	c, _ := NewStreamClient(WithEndpoint(url), WithAPIKey("key"))
+	var se *StatusError
+	err := c.Stream(context.Background(), "hello", "", &bytes.Buffer{}, nil)
+	if !errors.As(err, &se) || se.Code != 3005 || se.Message != "busy" || !se.Temporary() {
+		t.Fatalf("want a temporary status error, got %v", err)
+	}
+
+	c, _ = NewStreamClient(WithEndpoint(url), WithAPIKey("wrong"))
+	err = c.Stream(context.Background(), "hello", "", &bytes.Buffer{}, nil)
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("want a 401, got %v", err)
+	}
+}
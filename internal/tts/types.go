@@ -1,5 +1,46 @@
 package tts
 
+import (
+	"fmt"
+	"net/http"
+)
+
+// StatusError is returned when a provider rejects a synthesize request,
+// either by http status or by the code volc puts in the response body
+type StatusError struct {
+	StatusCode int
+	Code       int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Code == 0 {
+		if e.Message != "" {
+			return fmt.Sprintf("http %d: %s", e.StatusCode, e.Message)
+		}
+		return fmt.Sprintf("http %d", e.StatusCode)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("resp code=%d msg=%s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("resp code=%d", e.Code)
+}
+
+// Temporary reports whether the request may succeed when retried
+func (e *StatusError) Temporary() bool {
+	if e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 {
+		return true
+	}
+	switch e.Code {
+	case 3003, // concurrency limit exceeded
+		3005, // backend busy
+		3030, // processing timeout
+		3032: // waiting timeout
+		return true
+	}
+	return false
+}
+
 type SynthesizeResp struct {
 	ReqID     string `json:"reqid"`
 	Code      int    `json:"code"`
@@ -28,6 +69,7 @@ type audio struct {
 	VoiceType  string  `json:"voice_type"`
 	Encoding   string  `json:"encoding"`
 	SpeedRatio float64 `json:"speed_ratio"`
+	Rate       int     `json:"rate,omitempty"`
 }
 
 type request struct {
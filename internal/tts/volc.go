@@ -2,6 +2,7 @@ package tts
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -36,12 +37,19 @@ type options struct {
 	UID       string
 	VoiceType string
 	Format    string
+	// Model is the speech model of openai compatible providers
+	Model string
+
+	// ChunkTimeout bounds the wait for the next chunk of a stream
+	ChunkTimeout time.Duration
 }
 
 type Option func(opts *options)
 
 type Client interface {
-	Synthesize(content string) ([]byte, error)
+	// Synthesize returns the audio of content spoken by voice, an empty
+	// voice is the one the client was made with
+	Synthesize(ctx context.Context, content, voice string) ([]byte, error)
 }
 
 type client struct {
@@ -60,6 +68,14 @@ func defaultOpts() options {
 	}
 }
 
+// voice picks the voice of a call over the configured one
+func (o options) voice(v string) string {
+	if v != "" {
+		return v
+	}
+	return o.VoiceType
+}
+
 func NewClient(opts ...Option) (Client, error) {
 	o := defaultOpts()
 
@@ -72,14 +88,14 @@ func NewClient(opts ...Option) (Client, error) {
 	return &client{opts: o, cli: c}, nil
 }
 
-func (c *client) Synthesize(content string) ([]byte, error) {
+func (c *client) Synthesize(ctx context.Context, content, voice string) ([]byte, error) {
 	reqID := uuid.NewString()
 	var rb SynthesizeReq
 
 	rb.User.UID = c.opts.UID
 	rb.App.Cluster = c.opts.Cluster
 	{
-		rb.Audio.VoiceType = c.opts.VoiceType
+		rb.Audio.VoiceType = c.opts.voice(voice)
 		rb.Audio.Encoding = c.opts.Format
 		rb.Audio.SpeedRatio = 1.0
 	}
@@ -97,7 +113,7 @@ func (c *client) Synthesize(content string) ([]byte, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.opts.Endpoint, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.Endpoint, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -116,7 +132,7 @@ func (c *client) Synthesize(content string) ([]byte, error) {
 	}
 
 	if resp.StatusCode/100 != 2 {
-		return nil, fmt.Errorf("http %d", resp.StatusCode)
+		return nil, &StatusError{StatusCode: resp.StatusCode}
 	}
 
 	var r SynthesizeResp
@@ -125,10 +141,7 @@ func (c *client) Synthesize(content string) ([]byte, error) {
 	}
 
 	if r.Code != 3000 {
-		if r.Message != "" {
-			return nil, fmt.Errorf("resp code=%d msg=%s", r.Code, r.Message)
-		}
-		return nil, fmt.Errorf("resp code=%d", r.Code)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Code: r.Code, Message: r.Message}
 	}
 
 	audio, err := base64.StdEncoding.DecodeString(r.Data)
@@ -138,6 +151,10 @@ func (c *client) Synthesize(content string) ([]byte, error) {
 	return audio, nil
 }
 
+func WithEndpoint(v string) Option {
+	return func(opts *options) { opts.Endpoint = v }
+}
+
 func WithAPIKey(v string) Option {
 	return func(opts *options) { opts.APIKey = v }
 }
@@ -154,6 +171,14 @@ func WithUID(v string) Option {
 	return func(opts *options) { opts.UID = v }
 }
 
+func WithModel(v string) Option {
+	return func(opts *options) { opts.Model = v }
+}
+
 func WithVoiceType(v string) Option {
 	return func(opts *options) { opts.VoiceType = v }
 }
+
+func WithChunkTimeout(v time.Duration) Option {
+	return func(opts *options) { opts.ChunkTimeout = v }
+}
@@ -1,6 +1,7 @@
 package tts
 
 import (
+	"context"
 	"os"
 	"testing"
 )
@@ -10,7 +11,7 @@ func TestVolcSythesize(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	data, err := client.Synthesize("2007年，邓肯·洛里默等人在澳大利亚帕克斯电波天文台2001年的档案资料里发现了洛里默爆发")
+	data, err := client.Synthesize(context.Background(), "2007年，邓肯·洛里默等人在澳大利亚帕克斯电波天文台2001年的档案资料里发现了洛里默爆发", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const buildFile = "build.json"
+
+// fingerprint hashes the inputs and parameters of a build step, files are
+// keyed by path, size and mtime like make does rather than by content
+type fingerprint struct {
+	h hash.Hash
+}
+
+func newFingerprint(step string) *fingerprint {
+	f := &fingerprint{h: sha256.New()}
+	_, _ = io.WriteString(f.h, step+"\n")
+	return f
+}
+
+func (f *fingerprint) param(name string, v any) *fingerprint {
+	b, _ := json.Marshal(v)
+	fmt.Fprintf(f.h, "param %s=%s\n", name, b)
+	return f
+}
+
+func (f *fingerprint) file(paths ...string) *fingerprint {
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(f.h, "file %s missing\n", p)
+			continue
+		}
+		fmt.Fprintf(f.h, "file %s %d %d\n", p, st.Size(), st.ModTime().UnixNano())
+	}
+	return f
+}
+
+func (f *fingerprint) sum() string {
+	return hex.EncodeToString(f.h.Sum(nil))
+}
+
+// buildState is the build.json file of a project folder, mapping a step key
+// such as "render" or "tts/0003" to what it was last built from
+type buildState struct {
+	Steps map[string]buildStep `json:"steps"`
+}
+
+type buildStep struct {
+	Fingerprint string    `json:"fingerprint"`
+	Outputs     []string  `json:"outputs,omitempty"`
+	BuiltAt     time.Time `json:"builtAt"`
+}
+
+// builds reads and writes build.json files, every access re-reads the file so
+// concurrent tasks on the same folder do not drop each other's steps
+type builds struct {
+	mu sync.Mutex
+}
+
+func (b *builds) load(dir string) (*buildState, error) {
+	st := &buildState{Steps: make(map[string]buildStep)}
+
+	raw, err := os.ReadFile(filepath.Join(dir, buildFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, st); err != nil {
+		return nil, fmt.Errorf("bad %s: %w", buildFile, err)
+	}
+	if st.Steps == nil {
+		st.Steps = make(map[string]buildStep)
+	}
+	return st, nil
+}
+
+// upToDate reports whether step was last built from fp and its outputs still
+// exist, returning the recorded outputs
+func (b *builds) upToDate(dir, step, fp string) ([]string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, err := b.load(dir)
+	if err != nil {
+		return nil, false
+	}
+	s, ok := st.Steps[step]
+	if !ok || s.Fingerprint != fp {
+		return nil, false
+	}
+	for _, out := range s.Outputs {
+		if _, err := os.Stat(out); err != nil {
+			return nil, false
+		}
+	}
+	return s.Outputs, true
+}
+
+func (b *builds) record(dir, step, fp string, outputs []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, err := b.load(dir)
+	if err != nil {
+		return err
+	}
+	st.Steps[step] = buildStep{
+		Fingerprint: fp,
+		Outputs:     outputs,
+		BuiltAt:     time.Now(),
+	}
+
+	raw, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+buildFile+".tmp")
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, buildFile))
+}
@@ -0,0 +1,253 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body
+	// keyed with Config.CallbackSecret, it is left out without a secret
+	SignatureHeader = "X-Comp0ser-Signature"
+	DeliveryHeader  = "X-Comp0ser-Delivery"
+	EventHeader     = "X-Comp0ser-Event"
+
+	callbackEvent = "task.finished"
+)
+
+// ErrCallbackHost rejects a callback url whose host is not allow-listed
+var ErrCallbackHost = errors.New("callback host not allowed")
+
+// callbackPolicy paces deliveries of one callback, a receiver that is down
+// for a few minutes still gets it
+var callbackPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	BaseDelay:   5 * time.Second,
+	MaxDelay:    5 * time.Minute,
+}
+
+// CallbackStatus tracks the completion webhook of a task
+type CallbackStatus struct {
+	URL       string            `json:"url"`
+	Delivered bool              `json:"delivered,omitempty"`
+	GaveUp    bool              `json:"gaveUp,omitempty"`
+	Attempts  []CallbackAttempt `json:"attempts,omitempty"`
+}
+
+type CallbackAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// CallbackBody is the JSON posted to the callback URL once a task finished
+type CallbackBody struct {
+	TaskID   string    `json:"taskId"`
+	Type     TaskType  `json:"type"`
+	State    TaskState `json:"state"`
+	Outputs  []string  `json:"outputs,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Attempts int       `json:"attempts"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Durations  Durations  `json:"durations"`
+}
+
+// Durations are in seconds
+type Durations struct {
+	Queued float64 `json:"queued"`
+	Run    float64 `json:"run"`
+	Total  float64 `json:"total"`
+}
+
+// WithCallback posts a signed summary to url once the task finished
+func WithCallback(url string) SubmitOption {
+	return func(o *submitOptions) {
+		o.callback = url
+	}
+}
+
+// CheckCallbackHost rejects rawURL unless its host is in hosts, where
+// ".example.com" also matches every subdomain of example.com; an empty list
+// allows every host
+func CheckCallbackHost(hosts []string, rawURL string) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if host == strings.TrimPrefix(h, ".") || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrCallbackHost, u.Hostname())
+}
+
+// ParseCallbackHosts splits a list written as "hooks.example.com,.example.org"
+func ParseCallbackHosts(s string) []string {
+	var hosts []string
+	for h := range strings.SplitSeq(s, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// Sign returns the signature header value of body
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newCallbackBody(st TaskStatus) CallbackBody {
+	b := CallbackBody{
+		TaskID:     st.ID,
+		Type:       st.Type,
+		State:      st.State,
+		Outputs:    st.Outputs,
+		Error:      st.Error,
+		Attempts:   st.Attempts,
+		CreatedAt:  st.CreatedAt,
+		StartedAt:  st.StartedAt,
+		FinishedAt: st.FinishedAt,
+	}
+	if st.FinishedAt != nil {
+		b.Durations.Total = st.FinishedAt.Sub(st.CreatedAt).Seconds()
+		b.Durations.Queued = b.Durations.Total
+		if st.StartedAt != nil {
+			b.Durations.Queued = st.StartedAt.Sub(st.CreatedAt).Seconds()
+			b.Durations.Run = st.FinishedAt.Sub(*st.StartedAt).Seconds()
+		}
+	}
+	return b
+}
+
+// notify starts delivering the callback of a finished task, a no-op for
+// tasks without a callback or whose delivery already started
+func (w *worker) notify(id string) {
+	st, ok := w.reg.claimCallback(id)
+	if !ok {
+		return
+	}
+
+	w.bg.Go(func() {
+		w.deliver(st)
+	})
+}
+
+func (w *worker) deliver(st TaskStatus) {
+	body, err := json.Marshal(newCallbackBody(st))
+	if err != nil {
+		slog.Error("marshal callback body failed", "task_id", st.ID, "err", err)
+		return
+	}
+	deliveryID := uuid.NewString()
+	log := slog.With(
+		"task_id", st.ID,
+		"callback", st.Callback.URL,
+		"delivery_id", deliveryID,
+	)
+
+	for attempt := len(st.Callback.Attempts) + 1; ; attempt++ {
+		code, err := w.post(st.Callback.URL, deliveryID, body)
+		if w.ctx.Err() != nil {
+			// shutting down, picked up again from the journal on the next start
+			return
+		}
+
+		a := CallbackAttempt{At: time.Now(), StatusCode: code}
+		if err != nil {
+			a.Error = err.Error()
+		}
+		delivered := err == nil
+		gaveUp := !delivered && (attempt >= callbackPolicy.MaxAttempts || !IsRetryable(err))
+		w.reg.recordCallback(st.ID, a, delivered, gaveUp)
+
+		if delivered {
+			log.Info("callback delivered", "attempt", attempt)
+			return
+		}
+		if gaveUp {
+			log.Error("callback delivery gave up", "attempt", attempt, "err", err)
+			return
+		}
+
+		delay := callbackPolicy.backoff(attempt)
+		log.Warn("callback delivery failed, retrying",
+			"attempt", attempt,
+			"delay", delay,
+			"err", err,
+		)
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-w.ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+func (w *worker) post(callbackURL, deliveryID string, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(w.ctx, 30*time.Second)
+	defer cancel()
+
+	// the url was checked on submission, but the allow-list may have
+	// changed since a journal entry was written
+	if err := CheckCallbackHost(w.callbackHosts, callbackURL); err != nil {
+		return 0, Permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, callbackEvent)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	if len(w.callbackSecret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(w.callbackSecret, body))
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if errors.Is(err, ErrCallbackHost) {
+		// redirected off the allow-list
+		return 0, Permanent(err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode/100 == 2 {
+		return resp.StatusCode, nil
+	}
+	err = fmt.Errorf("callback returned %s", resp.Status)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, Permanent(err)
+}
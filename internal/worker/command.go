@@ -10,69 +10,92 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"comp0ser/internal/cmd"
 )
 
-func (w *worker) handleMerge(task *Task) error {
+func (w *worker) handleMerge(ctx context.Context, task *Task) error {
 	var p MergePayLoad
-
 	if err := json.Unmarshal(task.Payload, &p); err != nil {
 		return err
 	}
+	return w.merge(ctx, task, p)
+}
 
+func (w *worker) merge(ctx context.Context, task *Task, p MergePayLoad) error {
 	slog.Info("merge task start")
 
+	if err := w.resolvePaths(&p.VideoPath, &p.AudioPath, &p.OutPath); err != nil {
+		return err
+	}
 	cmd, err := w.ff.Merge(p.VideoPath, p.AudioPath, p.OutPath)
 	if err != nil {
 		return err
 	}
+	cmd.Duration = probeDuration(p.VideoPath)
 
-	if err := w.runner.Run(context.Background(), cmd); err != nil {
+	if err := w.runFFmpeg(ctx, task, cmd); err != nil {
 		return err
 	}
+	w.reg.addOutputs(task.ID, cmd.Outputs...)
 
 	slog.Info("merge task finish")
 	return nil
 }
 
-func (w *worker) handleMixdown(task *Task) error {
-	log := slog.With(
-		"worker_handler", "mixdown",
-		"taskID", task.ID,
-	)
+func (w *worker) handleMixdown(ctx context.Context, task *Task) error {
 	var p MixdownPayLoad
 	if err := json.Unmarshal(task.Payload, &p); err != nil {
 		return err
 	}
+	return w.mixdown(ctx, task, p)
+}
+
+func (w *worker) mixdown(ctx context.Context, task *Task, p MixdownPayLoad) error {
+	log := slog.With(
+		"worker_handler", "mixdown",
+		"taskID", task.ID,
+	)
 
 	log.Info("mixdown task start")
 
+	if err := w.resolvePaths(&p.AudioPath, &p.BGMPath, &p.Filename); err != nil {
+		return err
+	}
 	cmd := w.ff.BlendM4A(p.AudioPath, p.BGMPath, p.Filename, p.Volume, p.Loop)
+	cmd.Duration = probeDuration(p.AudioPath)
 	fmt.Println(cmd)
 
-	ctx, cannel := context.WithTimeout(context.Background(), 10*time.Minute)
+	ctx, cannel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cannel()
 
-	if err := w.runner.Run(ctx, cmd); err != nil {
+	if err := w.runFFmpeg(ctx, task, cmd); err != nil {
 		log.Error("runner execution failed",
 			"err", err,
 			"cmd", cmd,
 		)
 		return err
 	}
+	w.reg.addOutputs(task.ID, cmd.Outputs...)
 
 	slog.Info("blend M4A, finished")
 	return nil
 }
 
-func (w *worker) handleConcat(task *Task) error {
+func (w *worker) handleConcat(ctx context.Context, task *Task) error {
 	var p ConcatPayLoad
-
 	if err := json.Unmarshal(task.Payload, &p); err != nil {
 		return err
 	}
+	return w.concat(ctx, task, p)
+}
 
+func (w *worker) concat(ctx context.Context, task *Task, p ConcatPayLoad) error {
 	slog.Info("concat task start")
 	fmt.Println(p.Folder)
+	if err := checkProject(p.Folder); err != nil {
+		return err
+	}
 
 	nars, err := w.fs.List(p.Folder)
 	if err != nil {
@@ -80,7 +103,10 @@ func (w *worker) handleConcat(task *Task) error {
 	}
 	wavs := make([]string, len(nars))
 	for i, nar := range nars {
-		wavs[i] = filepath.Join(w.fs.Dir(), p.Folder, "audio", nar["audio_id"].(string)+".wav")
+		if nar.AudioID == "" {
+			return Permanent(fmt.Errorf("narration %s has no audio", nar.ID))
+		}
+		wavs[i] = filepath.Join(w.fs.Dir(), p.Folder, "audio", nar.AudioID+".wav")
 	}
 
 	cmd, err := w.ff.ConcatWav(wavs, filepath.Join(w.fs.Dir(), p.Folder), p.Folder+".wav")
@@ -88,9 +114,10 @@ func (w *worker) handleConcat(task *Task) error {
 		return err
 	}
 
-	if err := w.runner.Run(context.Background(), cmd); err != nil {
+	if err := w.runFFmpeg(ctx, task, cmd); err != nil {
 		return err
 	}
+	w.reg.addOutputs(task.ID, cmd.Outputs...)
 
 	slog.Info("concat wav finish",
 		"len", len(wavs),
@@ -98,16 +125,21 @@ func (w *worker) handleConcat(task *Task) error {
 	return nil
 }
 
-func (w *worker) handleRender(task *Task) error {
+func (w *worker) handleRender(ctx context.Context, task *Task) error {
 	var p RenderPayLoad
-
 	if err := json.Unmarshal(task.Payload, &p); err != nil {
 		return err
 	}
+	return w.render(ctx, task, p)
+}
 
+func (w *worker) render(ctx context.Context, task *Task, p RenderPayLoad) error {
 	fmt.Printf("%+v\n", p)
 
 	slog.Info("render task start", "folder", p.Folder)
+	if err := checkProject(p.Folder); err != nil {
+		return err
+	}
 
 	assetDir := filepath.Join(w.fs.Dir(), p.Folder, "asset")
 	videos, err := listMP4Files(assetDir)
@@ -123,7 +155,10 @@ func (w *worker) handleRender(task *Task) error {
 	if out == "" {
 		out = "out.mp4"
 	}
-	outPath := filepath.Join(w.fs.Dir(), p.Folder, out)
+	outPath, err := w.projectPath(p.Folder, out)
+	if err != nil {
+		return err
+	}
 
 	tailCut := p.TailCut
 	if tailCut <= 0 {
@@ -137,14 +172,35 @@ func (w *worker) handleRender(task *Task) error {
 		return err
 	}
 
-	if err := w.runner.Run(context.Background(), cmd); err != nil {
+	if err := w.runFFmpeg(ctx, task, cmd); err != nil {
 		return err
 	}
+	w.reg.addOutputs(task.ID, outPath)
 
 	slog.Info("render task finish")
 	return nil
 }
 
+// runFFmpeg runs an ffmpeg command and publishes its progress on the task
+// status
+func (w *worker) runFFmpeg(ctx context.Context, task *Task, c *cmd.Cmd) error {
+	c.OnProgress = func(p cmd.Progress) {
+		w.reg.setProgress(task.ID, &p)
+	}
+	return w.runner.Run(ctx, c)
+}
+
+// probeDuration returns the length of path in seconds, 0 if ffprobe fails so
+// progress is reported without a percentage
+func probeDuration(path string) float64 {
+	d, err := cmd.ProbeDuration(path)
+	if err != nil {
+		slog.Warn("probe duration failed", "path", path, "err", err)
+		return 0
+	}
+	return d
+}
+
 func listMP4Files(dir string) ([]string, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
@@ -157,6 +213,10 @@ func listMP4Files(dir string) ([]string, error) {
 			continue
 		}
 		name := e.Name()
+		// hidden files are uploads in progress or editor leftovers
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
 		if strings.EqualFold(filepath.Ext(name), ".mp4") {
 			files = append(files, filepath.Join(dir, name))
 		}
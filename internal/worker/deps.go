@@ -0,0 +1,279 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+)
+
+var ErrInvalidJob = errors.New("invalid job")
+
+// outputRef matches ${name.outputs[N]} inside payload strings, name is a job
+// task name or a task ID
+var outputRef = regexp.MustCompile(`\$\{([\w.-]+?)\.outputs\[(\d+)\]\}`)
+
+// SubmitOption configures a single submission
+type SubmitOption func(*submitOptions)
+
+type submitOptions struct {
+	dependsOn []string
+	refs      map[string]string
+	priority  *Priority
+	callback  string
+
+	idempotencyKey string
+}
+
+// DependsOn holds the task until every task in ids succeeded, it is cancelled
+// as soon as one of them fails or is cancelled
+func DependsOn(ids ...string) SubmitOption {
+	return func(o *submitOptions) {
+		o.dependsOn = append(o.dependsOn, ids...)
+	}
+}
+
+// WithPriority overrides the queue priority of the task type
+func WithPriority(p Priority) SubmitOption {
+	return func(o *submitOptions) {
+		o.priority = &p
+	}
+}
+
+// SubmitJob validates the graph, then submits every task in dependency order
+// and returns the task ID of each name
+func (w *worker) SubmitJob(ctx context.Context, tasks []JobTask) (map[string]string, error) {
+	order, err := w.sortJob(tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Start()
+
+	ids := make(map[string]string, len(tasks))
+	for _, t := range tasks {
+		ids[t.Name] = newTaskID()
+	}
+
+	for _, t := range order {
+		deps := make([]string, 0, len(t.DependsOn))
+		for _, d := range t.DependsOn {
+			if id, ok := ids[d]; ok {
+				d = id
+			}
+			deps = append(deps, d)
+		}
+
+		payload := t.Payload
+		if len(payload) == 0 {
+			payload = json.RawMessage("{}")
+		}
+		task := w.newTask(ids[t.Name], t.Type, payload)
+		task.callback = t.CallbackURL
+		err := w.submitWith(ctx, task, submitOptions{dependsOn: deps, refs: ids})
+		if err != nil {
+			// don't leave half a job behind
+			for _, id := range ids {
+				_ = w.Cancel(id)
+			}
+			return nil, fmt.Errorf("submit job task %s: %w", t.Name, err)
+		}
+	}
+
+	slog.Info("job submitted", "tasks", ids)
+	return ids, nil
+}
+
+// sortJob checks names, types and dependencies of a job and returns its tasks
+// in topological order
+func (w *worker) sortJob(tasks []JobTask) ([]JobTask, error) {
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("%w: no tasks", ErrInvalidJob)
+	}
+
+	byName := make(map[string]JobTask, len(tasks))
+	for _, t := range tasks {
+		if t.Name == "" {
+			return nil, fmt.Errorf("%w: task without name", ErrInvalidJob)
+		}
+		if _, ok := byName[t.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate task name %q", ErrInvalidJob, t.Name)
+		}
+		if !w.knownType(t.Type) {
+			return nil, fmt.Errorf("%w: task %s has unknown type %q", ErrInvalidJob, t.Name, t.Type)
+		}
+		if len(t.Payload) > 0 && !json.Valid(t.Payload) {
+			return nil, fmt.Errorf("%w: task %s has a bad payload", ErrInvalidJob, t.Name)
+		}
+		byName[t.Name] = t
+	}
+
+	indegree := make(map[string]int, len(tasks))
+	children := make(map[string][]string, len(tasks))
+	for _, t := range tasks {
+		for _, d := range t.DependsOn {
+			if _, ok := byName[d]; ok {
+				indegree[t.Name]++
+				children[d] = append(children[d], t.Name)
+				continue
+			}
+			if _, ok := w.reg.get(d); !ok {
+				return nil, fmt.Errorf("%w: task %s depends on unknown %q", ErrInvalidJob, t.Name, d)
+			}
+		}
+	}
+
+	order := make([]JobTask, 0, len(tasks))
+	var ready []string
+	for _, t := range tasks {
+		if indegree[t.Name] == 0 {
+			ready = append(ready, t.Name)
+		}
+	}
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, byName[name])
+		for _, c := range children[name] {
+			if indegree[c]--; indegree[c] == 0 {
+				ready = append(ready, c)
+			}
+		}
+	}
+	if len(order) != len(tasks) {
+		return nil, fmt.Errorf("%w: dependency cycle", ErrInvalidJob)
+	}
+	return order, nil
+}
+
+// release queues a waiting task once all of its dependencies succeeded and
+// gives up on it as soon as one of them failed or was cancelled
+func (w *worker) release(id string) {
+	st, ok := w.reg.get(id)
+	if !ok || st.State != TaskWaiting {
+		return
+	}
+
+	outputs := make(map[string][]string, len(st.DependsOn))
+	for _, dep := range st.DependsOn {
+		ps, ok := w.reg.get(dep)
+		switch {
+		case !ok:
+			w.giveUp(id, TaskCancelled, fmt.Errorf("dependency %s: %w", dep, ErrTaskNotFound))
+			return
+		case ps.State == TaskSucceeded:
+			outputs[dep] = ps.Outputs
+		case ps.State.Done():
+			w.giveUp(id, TaskCancelled, fmt.Errorf("dependency %s %s", dep, ps.State))
+			return
+		default:
+			// still pending
+			return
+		}
+	}
+
+	task, ok := w.reg.live(id)
+	if !ok {
+		return
+	}
+	payload, err := expandRefs(task.Payload, st.Refs, outputs)
+	if err != nil {
+		w.giveUp(id, TaskFailed, err)
+		return
+	}
+	if w.reg.release(id, payload) {
+		slog.Info("dependencies met, task queued",
+			"task_id", id,
+			"task_type", st.Type,
+		)
+		go w.enqueue(task)
+	}
+}
+
+func (w *worker) giveUp(id string, state TaskState, err error) {
+	if !w.reg.abandon(id, state, err) {
+		return
+	}
+	slog.Warn("waiting task abandoned",
+		"task_id", id,
+		"state", state,
+		"err", err,
+	)
+	w.finished(id)
+}
+
+// finished runs what follows the end of a task
+func (w *worker) finished(id string) {
+	w.releaseDependents(id)
+	w.notify(id)
+}
+
+// releaseDependents re-checks the tasks waiting on id after it finished
+func (w *worker) releaseDependents(id string) {
+	for _, child := range w.reg.dependents(id) {
+		w.release(child)
+	}
+}
+
+// expandRefs replaces output references in the string values of payload with
+// the outputs of the dependencies
+func expandRefs(payload json.RawMessage, refs map[string]string, outputs map[string][]string) (json.RawMessage, error) {
+	if !outputRef.Match(payload) {
+		return payload, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var expandErr error
+	v = walkStrings(v, func(s string) string {
+		return outputRef.ReplaceAllStringFunc(s, func(m string) string {
+			sub := outputRef.FindStringSubmatch(m)
+			name, idx := sub[1], sub[2]
+
+			id := name
+			if v, ok := refs[name]; ok {
+				id = v
+			}
+			outs, ok := outputs[id]
+			if !ok {
+				expandErr = fmt.Errorf("output reference %s: %s is not a dependency", m, name)
+				return m
+			}
+			i, _ := strconv.Atoi(idx)
+			if i >= len(outs) {
+				expandErr = fmt.Errorf("output reference %s: %s has %d outputs", m, name, len(outs))
+				return m
+			}
+			return outs[i]
+		})
+	})
+	if expandErr != nil {
+		return nil, Permanent(expandErr)
+	}
+	return json.Marshal(v)
+}
+
+func walkStrings(v any, fn func(string) string) any {
+	switch x := v.(type) {
+	case string:
+		return fn(x)
+	case []any:
+		for i := range x {
+			x[i] = walkStrings(x[i], fn)
+		}
+	case map[string]any:
+		for k := range x {
+			x[k] = walkStrings(x[k], fn)
+		}
+	}
+	return v
+}
@@ -0,0 +1,345 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"comp0ser/internal/cmd"
+)
+
+const (
+	StageScript   = "script"
+	StageTTS      = "tts"
+	StageConcat   = "concat"
+	StageRender   = "render"
+	StageMix      = "mix"
+	StageMerge    = "merge"
+	StageSubtitle = "subtitle"
+	StageBurn     = "burn"
+)
+
+// episodeManifest is the episode.json file kept in the project folder
+type episodeManifest struct {
+	TaskID    string         `json:"taskId"`
+	Folder    string         `json:"folder"`
+	Stages    []episodeStage `json:"stages"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+type episodeStage struct {
+	Name       string     `json:"name"`
+	State      TaskState  `json:"state"`
+	Skipped    bool       `json:"skipped,omitempty"` // inputs unchanged since the last build
+	Outputs    []string   `json:"outputs,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+type stage struct {
+	name string
+	// inputs fingerprints what the stage is built from, nil always runs it
+	inputs func() (*fingerprint, error)
+	run    func(ctx context.Context) error
+}
+
+func (w *worker) handleEpisode(ctx context.Context, task *Task) error {
+	var p EpisodePayLoad
+	if err := json.Unmarshal(task.Payload, &p); err != nil {
+		return err
+	}
+	return w.episode(ctx, task, p)
+}
+
+// episode runs the whole chain from raw text to the final video, every stage
+// consumes the outputs of the previous ones inside the project folder and is
+// skipped when its inputs did not change since the last build
+func (w *worker) episode(ctx context.Context, task *Task, p EpisodePayLoad) error {
+	if p.Subject == "" {
+		return Permanent(fmt.Errorf("empty subject"))
+	}
+	if p.Mix.BGM == "" {
+		return Permanent(fmt.Errorf("empty bgm"))
+	}
+	if err := checkProject(p.Subject); err != nil {
+		return err
+	}
+
+	folder := p.Subject
+	dir, err := w.fs.New(folder)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wav    = filepath.Join(dir, folder+".wav")
+		video  = filepath.Join(dir, "out.mp4")
+		mixed  = filepath.Join(dir, "mix.m4a")
+		final  = filepath.Join(dir, "final.mp4")
+		srt    = filepath.Join(dir, "subtitle.srt")
+		burned = filepath.Join(dir, "final_sub.mp4")
+	)
+
+	bgm, err := w.projectPath(folder, p.Mix.BGM)
+	if err != nil {
+		return err
+	}
+	dur := p.Render.Dur
+
+	stages := []stage{
+		{
+			name: StageScript,
+			inputs: func() (*fingerprint, error) {
+				return newFingerprint(StageScript).param("script", p.GenScriptPayLoad), nil
+			},
+			run: func(ctx context.Context) error {
+				// the raw text or prompt changed, genScript replaces the narration
+				return w.genScript(ctx, task, p.GenScriptPayLoad)
+			},
+		},
+		{
+			name: StageTTS,
+			run: func(ctx context.Context) error {
+				return w.ttsAll(ctx, task, GenTTSPayLoad{Folder: folder, TTS: p.TTS})
+			},
+		},
+		{
+			name: StageConcat,
+			inputs: func() (*fingerprint, error) {
+				wavs, err := w.segmentWavs(folder)
+				if err != nil {
+					return nil, err
+				}
+				return newFingerprint(StageConcat).file(wavs...), nil
+			},
+			run: func(ctx context.Context) error {
+				return w.concat(ctx, task, ConcatPayLoad{Folder: folder})
+			},
+		},
+		{
+			name: StageRender,
+			inputs: func() (*fingerprint, error) {
+				if dur <= 0 {
+					d, err := cmd.ProbeDuration(wav)
+					if err != nil {
+						return nil, err
+					}
+					dur = d
+				}
+				videos, err := listMP4Files(filepath.Join(dir, "asset"))
+				if err != nil {
+					return nil, err
+				}
+				return newFingerprint(StageRender).
+					file(videos...).
+					param("dur", dur).
+					param("tailCut", p.Render.TailCut).
+					param("loop", p.Render.Loop), nil
+			},
+			run: func(ctx context.Context) error {
+				return w.render(ctx, task, RenderPayLoad{
+					Folder:  folder,
+					Dur:     dur,
+					TailCut: p.Render.TailCut,
+					Loop:    p.Render.Loop,
+					Out:     filepath.Base(video),
+				})
+			},
+		},
+		{
+			name: StageMix,
+			inputs: func() (*fingerprint, error) {
+				return newFingerprint(StageMix).
+					file(wav, bgm).
+					param("volume", p.Mix.Volume).
+					param("loop", p.Mix.Loop), nil
+			},
+			run: func(ctx context.Context) error {
+				return w.mixdown(ctx, task, MixdownPayLoad{
+					AudioPath: wav,
+					BGMPath:   bgm,
+					Filename:  mixed,
+					Volume:    p.Mix.Volume,
+					Loop:      p.Mix.Loop,
+				})
+			},
+		},
+		{
+			name: StageMerge,
+			inputs: func() (*fingerprint, error) {
+				return newFingerprint(StageMerge).file(video, mixed), nil
+			},
+			run: func(ctx context.Context) error {
+				return w.merge(ctx, task, MergePayLoad{
+					VideoPath: video,
+					AudioPath: mixed,
+					OutPath:   final,
+				})
+			},
+		},
+	}
+
+	if sub := p.Subtitle; sub != nil {
+		stages = append(stages, stage{
+			name: StageSubtitle,
+			inputs: func() (*fingerprint, error) {
+				return newFingerprint(StageSubtitle).
+					file(wav).
+					param("lang", w.subtitleLang(folder, sub.Lang)).
+					param("model", w.whisper.Model), nil
+			},
+			run: func(ctx context.Context) error {
+				return w.genSubtitle(ctx, task, GenSubtitlePayload{
+					AudioPath:  wav,
+					OutputPath: srt,
+					Lang:       w.subtitleLang(folder, sub.Lang),
+				})
+			},
+		})
+		if sub.Burn {
+			stages = append(stages, stage{
+				name: StageBurn,
+				inputs: func() (*fingerprint, error) {
+					return newFingerprint(StageBurn).file(final, srt), nil
+				},
+				run: func(ctx context.Context) error {
+					return w.burnSubtitle(ctx, task, BrunSubtitlePayLoad{
+						VideoPath:    final,
+						SubtitlePath: srt,
+						OutputPath:   burned,
+					})
+				},
+			})
+		}
+	}
+
+	m := &episodeManifest{
+		TaskID: task.ID,
+		Folder: folder,
+	}
+	for _, st := range stages {
+		m.Stages = append(m.Stages, episodeStage{Name: st.name, State: TaskQueued})
+	}
+	manifest := filepath.Join(dir, "episode.json")
+
+	for i, st := range stages {
+		log := slog.With(
+			"task_id", task.ID,
+			"folder", folder,
+			"stage", st.name,
+		)
+
+		now := time.Now()
+		m.Stages[i].State = TaskRunning
+		m.Stages[i].StartedAt = &now
+		w.reg.setStage(task.ID, st.name)
+		writeEpisodeManifest(manifest, m)
+
+		var fp string
+		if st.inputs != nil {
+			f, err := st.inputs()
+			if err != nil {
+				m.Stages[i].State = TaskFailed
+				m.Stages[i].Error = err.Error()
+				writeEpisodeManifest(manifest, m)
+				return fmt.Errorf("episode stage %s: %w", st.name, err)
+			}
+			fp = f.sum()
+
+			if outs, ok := w.builds.upToDate(dir, st.name, fp); ok {
+				finished := time.Now()
+				m.Stages[i].State = TaskSucceeded
+				m.Stages[i].Skipped = true
+				m.Stages[i].Outputs = outs
+				m.Stages[i].FinishedAt = &finished
+				w.reg.addOutputs(task.ID, outs...)
+				writeEpisodeManifest(manifest, m)
+				log.Info("episode stage up to date, skipped")
+				continue
+			}
+		}
+
+		status, _ := w.reg.get(task.ID)
+		before := len(status.Outputs)
+
+		log.Info("episode stage start")
+		err := st.run(ctx)
+
+		status, _ = w.reg.get(task.ID)
+		finished := time.Now()
+		m.Stages[i].FinishedAt = &finished
+		m.Stages[i].Outputs = status.Outputs[before:]
+
+		if err != nil {
+			m.Stages[i].State = TaskFailed
+			m.Stages[i].Error = err.Error()
+			writeEpisodeManifest(manifest, m)
+			return fmt.Errorf("episode stage %s: %w", st.name, err)
+		}
+
+		if fp != "" {
+			if err := w.builds.record(dir, st.name, fp, m.Stages[i].Outputs); err != nil {
+				return fmt.Errorf("record build step %s: %w", st.name, err)
+			}
+		}
+
+		m.Stages[i].State = TaskSucceeded
+		writeEpisodeManifest(manifest, m)
+		log.Info("episode stage finish", "outputs", m.Stages[i].Outputs)
+	}
+
+	w.reg.setStage(task.ID, "")
+	return nil
+}
+
+func writeEpisodeManifest(path string, m *episodeManifest) {
+	m.UpdatedAt = time.Now()
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err == nil {
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, b, 0o644); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		slog.Warn("write episode manifest failed",
+			"path", path,
+			"err", err,
+		)
+	}
+}
+
+// segmentWavs returns the synthesized wav of every narration in folder
+func (w *worker) segmentWavs(folder string) ([]string, error) {
+	nars, err := w.fs.List(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	wavs := make([]string, 0, len(nars))
+	for _, nar := range nars {
+		if nar.AudioID == "" {
+			return nil, fmt.Errorf("narration %s has no audio", nar.ID)
+		}
+		wavs = append(wavs, filepath.Join(w.fs.Dir(), folder, "audio", nar.AudioID+".wav"))
+	}
+	return wavs, nil
+}
+
+// subtitleLang is lang, else the language of the project
+func (w *worker) subtitleLang(folder, lang string) string {
+	if lang != "" {
+		return lang
+	}
+	p, err := w.fs.Project(folder)
+	if err != nil {
+		return ""
+	}
+	return p.Language
+}
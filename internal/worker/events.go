@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"comp0ser/internal/cmd"
+)
+
+type EventType string
+
+const (
+	EventState    EventType = "state"
+	EventStage    EventType = "stage"
+	EventProgress EventType = "progress"
+	// EventSegment reports a synthesized or reused TTS segment
+	EventSegment EventType = "segment"
+	EventOutput  EventType = "output"
+)
+
+// Event is one change in the lifecycle of a task
+type Event struct {
+	Type     EventType `json:"type"`
+	TaskID   string    `json:"taskId"`
+	TaskType TaskType  `json:"taskType"`
+
+	State    TaskState     `json:"state,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Stage    string        `json:"stage,omitempty"`
+	Progress *cmd.Progress `json:"progress,omitempty"`
+	Segment  string        `json:"segment,omitempty"`
+	Outputs  []string      `json:"outputs,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// subscriberBuffer events are kept for a slow subscriber, later ones are
+// dropped until it catches up
+const subscriberBuffer = 256
+
+// hub fans task events out to subscribers
+type hub struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+type subscriber struct {
+	taskID string // empty receives every task
+	ch     chan Event
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[*subscriber]struct{})}
+}
+
+func (h *hub) subscribe(taskID string) (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := &subscriber{taskID: taskID, ch: make(chan Event, subscriberBuffer)}
+	if h.closed {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	h.subs[s] = struct{}{}
+
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if _, ok := h.subs[s]; ok {
+				delete(h.subs, s)
+				close(s.ch)
+			}
+		})
+	}
+}
+
+func (h *hub) publish(e Event) {
+	e.Time = time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		if s.taskID != "" && s.taskID != e.TaskID {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			slog.Warn("event subscriber is lagging, event dropped",
+				"task_id", e.TaskID,
+				"event", e.Type,
+			)
+		}
+	}
+}
+
+// close ends every subscription
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
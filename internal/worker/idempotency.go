@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrDuplicate is returned by Submit together with the ID of the task the
+// submission duplicates
+var ErrDuplicate = errors.New("duplicate submission")
+
+// idempotencyWindow is how long a succeeded task answers for its
+// idempotency key
+const idempotencyWindow = 24 * time.Hour
+
+type duplicateError struct {
+	id string
+}
+
+func (e *duplicateError) Error() string        { return "duplicate of task " + e.id }
+func (e *duplicateError) Is(target error) bool { return target == ErrDuplicate }
+
+// WithIdempotencyKey makes a submission with the same type and key return
+// the task of the first one while it is pending or succeeded within a day,
+// without a key a submission is only matched by its payload against pending
+// tasks
+func WithIdempotencyKey(key string) SubmitOption {
+	return func(o *submitOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+func payloadHash(typ TaskType, payload []byte) string {
+	h := sha256.New()
+	h.Write([]byte(typ))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// findDuplicate returns the task that a submission of task duplicates, the
+// caller holds the lock
+func (r *registry) findDuplicate(task *Task) string {
+	if task.idempotencyKey == "" && task.payloadHash == "" {
+		return ""
+	}
+	if task.idempotencyKey != "" {
+		cutoff := time.Now().Add(-idempotencyWindow)
+		ids := r.byKey[dupKey(task.Type, task.idempotencyKey)]
+		for i := len(ids) - 1; i >= 0; i-- {
+			s := &r.entries[ids[i]].status
+			if !s.State.Done() || s.State == TaskSucceeded && s.FinishedAt != nil && s.FinishedAt.After(cutoff) {
+				return s.ID
+			}
+		}
+		return ""
+	}
+
+	ids := r.byHash[task.payloadHash]
+	for i := len(ids) - 1; i >= 0; i-- {
+		// the hash covers the task type
+		if s := &r.entries[ids[i]].status; !s.State.Done() {
+			return s.ID
+		}
+	}
+	return ""
+}
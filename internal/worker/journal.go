@@ -0,0 +1,214 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	journalFile = "tasks.jsonl"
+
+	// finished tasks older than this are dropped when the journal is compacted
+	journalRetention = 7 * 24 * time.Hour
+	// compactInterval is how often the running worker prunes the registry
+	// and compacts the journal
+	compactInterval = time.Hour
+	// compactLines is how many lines the journal may hold beyond one per
+	// task before it is compacted without anything pruned
+	compactLines = 1000
+)
+
+// record is one line of the journal, the last record of a task wins on replay
+type record struct {
+	Status  TaskStatus      `json:"status"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// Removed drops the task on replay, used when a submission is aborted
+	Removed bool `json:"removed,omitempty"`
+}
+
+// journal is an append-only log of task state changes kept under the store
+// dir, so queued and running tasks survive a restart of the server
+type journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	// lines counts the records in the file
+	lines int
+}
+
+// openJournal replays the journal in dir and compacts it down to one record
+// per retained task
+func openJournal(dir string) (*journal, []record, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	path := filepath.Join(dir, journalFile)
+
+	recs, err := replay(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := compact(path, recs); err != nil {
+		return nil, nil, fmt.Errorf("compact journal: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &journal{path: path, f: f, lines: len(recs)}, recs, nil
+}
+
+func replay(path string) ([]record, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		order []string
+		last  = make(map[string]*record)
+	)
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		var rec record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			// a torn write at the tail is expected after a crash
+			slog.Warn("skip bad journal line", "path", path, "err", err)
+			continue
+		}
+
+		prev, ok := last[rec.Status.ID]
+		if rec.Removed {
+			delete(last, rec.Status.ID)
+			continue
+		}
+		if !ok {
+			order = append(order, rec.Status.ID)
+			last[rec.Status.ID] = &rec
+			continue
+		}
+		if len(rec.Payload) == 0 {
+			rec.Payload = prev.Payload
+		}
+		*prev = rec
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-journalRetention)
+	recs := make([]record, 0, len(order))
+	for _, id := range order {
+		rec, ok := last[id]
+		if !ok {
+			continue
+		}
+		if rec.Status.State.Done() && rec.Status.FinishedAt != nil && rec.Status.FinishedAt.Before(cutoff) {
+			continue
+		}
+		recs = append(recs, *rec)
+	}
+	return recs, nil
+}
+
+func compact(path string, recs []record) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tasks.tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+	}()
+
+	w := bufio.NewWriter(tmp)
+	for _, rec := range recs {
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+func (j *journal) append(rec record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	j.lines++
+	return nil
+}
+
+// overgrown reports whether the journal holds compactLines more lines than
+// the live tasks need
+func (j *journal) overgrown(live int) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.lines-live >= compactLines
+}
+
+// rewrite replaces the journal with recs, the caller keeps appends out until
+// it returns
+func (j *journal) rewrite(recs []record) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := compact(j.path, recs); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_ = j.f.Close()
+	j.f = f
+	j.lines = len(recs)
+	return nil
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.f.Close()
+}
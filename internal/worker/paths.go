@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"comp0ser/internal/filestore"
+)
+
+// resolvePaths maps the client supplied paths of a payload into the sandbox
+// in place, a path outside of it fails the task for good; without a sandbox
+// the paths are used as given
+func (w *worker) resolvePaths(paths ...*string) error {
+	if w.sandbox == nil {
+		return nil
+	}
+	for _, p := range paths {
+		if *p == "" {
+			continue
+		}
+		real, err := w.sandbox.Resolve(*p)
+		if err != nil {
+			return Permanent(err)
+		}
+		*p = real
+	}
+	return nil
+}
+
+// projectPath returns rel inside the folder of project, rel may not leave it
+func (w *worker) projectPath(project, rel string) (string, error) {
+	if err := checkProject(project); err != nil {
+		return "", err
+	}
+	if w.sandbox != nil {
+		real, err := w.sandbox.ResolveIn(project, rel)
+		if err != nil {
+			return "", Permanent(err)
+		}
+		return real, nil
+	}
+	if !filepath.IsLocal(rel) {
+		return "", Permanent(fmt.Errorf("%q leaves project %s", rel, project))
+	}
+	return filepath.Join(w.fs.Dir(), project, rel), nil
+}
+
+// checkProject rejects folder names that are not a single directory of the
+// store
+func checkProject(name string) error {
+	if err := filestore.CheckProjectName(name); err != nil {
+		return Permanent(err)
+	}
+	return nil
+}
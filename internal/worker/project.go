@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"comp0ser/internal/filestore"
+)
+
+// recordArtifacts lists the outputs of a succeeded task that landed in a
+// project folder on that project's manifest
+func (w *worker) recordArtifacts(id string) {
+	if w.fs == nil {
+		return
+	}
+	st, ok := w.reg.get(id)
+	if !ok || len(st.Outputs) == 0 {
+		return
+	}
+	root, err := filepath.Abs(w.fs.Dir())
+	if err != nil {
+		return
+	}
+
+	arts := make(map[string][]filestore.Artifact)
+	for _, out := range st.Outputs {
+		abs, err := filepath.Abs(out)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+		name, path, ok := strings.Cut(filepath.ToSlash(rel), "/")
+		if !ok || filestore.CheckProjectName(name) != nil {
+			continue
+		}
+		arts[name] = append(arts[name], filestore.Artifact{
+			Path:   path,
+			Kind:   string(st.Type),
+			TaskID: id,
+		})
+	}
+
+	for name, list := range arts {
+		if _, err := w.fs.AddArtifacts(name, list...); err != nil {
+			slog.Warn("record project artifacts failed",
+				"task_id", id,
+				"project", name,
+				"err", err,
+			)
+		}
+	}
+}
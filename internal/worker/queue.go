@@ -0,0 +1,192 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Priority orders queued tasks, higher lanes are always served first
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+const numLanes = 3
+
+var defaultPriorities = map[TaskType]Priority{
+	// interactive fixes of a single segment jump ahead of batch work
+	GenTTSSingle: PriorityHigh,
+
+	Render:       PriorityLow,
+	EpisodeBuild: PriorityLow,
+}
+
+// defaultConcurrency caps the types that run ffmpeg encodes, an uncapped type
+// may take every worker
+var defaultConcurrency = map[TaskType]int{
+	Render:       1,
+	Brun:         1,
+	EpisodeBuild: 1,
+}
+
+func (p Priority) lane() int {
+	return min(max(int(p-PriorityLow), 0), numLanes-1)
+}
+
+// queue hands tasks to the worker loops by priority, FIFO within a lane,
+// skipping tasks whose type already runs at its concurrency cap
+type queue struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	lanes [numLanes][]*Task
+
+	limits  map[TaskType]int
+	running map[TaskType]int
+	closed  bool
+
+	// slots bounds the number of queued tasks, a push blocks while it is full
+	slots chan struct{}
+}
+
+func newQueue(capacity int, limits map[TaskType]int) *queue {
+	q := &queue{
+		limits:  limits,
+		running: make(map[TaskType]int),
+		slots:   make(chan struct{}, capacity),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push blocks until the queue has room or ctx is done, it reports false once
+// the queue is closed
+func (q *queue) push(ctx context.Context, task *Task) (bool, error) {
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		<-q.slots
+		return false, nil
+	}
+	l := task.Priority.lane()
+	q.lanes[l] = append(q.lanes[l], task)
+	q.cond.Broadcast()
+	return true, nil
+}
+
+// pop returns the next runnable task and counts it as running until done is
+// called, it returns false once the queue is closed
+func (q *queue) pop() (*Task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.closed {
+			return nil, false
+		}
+		if task := q.next(); task != nil {
+			q.running[task.Type]++
+			<-q.slots
+			return task, true
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *queue) next() *Task {
+	for l := numLanes - 1; l >= 0; l-- {
+		for i, task := range q.lanes[l] {
+			if limit, ok := q.limits[task.Type]; ok && limit > 0 && q.running[task.Type] >= limit {
+				continue
+			}
+			q.lanes[l] = append(q.lanes[l][:i], q.lanes[l][i+1:]...)
+			return task
+		}
+	}
+	return nil
+}
+
+// done frees the concurrency slot taken by pop
+func (q *queue) done(task *Task) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running[task.Type]--
+	q.cond.Broadcast()
+}
+
+// close stops accepting and handing out tasks, the queued ones stay queued in
+// the journal for the next start
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (q *queue) stopped() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// ParseConcurrency parses caps written as "render.mp4=1,tts.all.gen=8"
+func ParseConcurrency(s string) (map[TaskType]int, error) {
+	limits := make(map[TaskType]int)
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		typ, n, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad concurrency %q, want type=n", part)
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("bad concurrency %q, want type=n", part)
+		}
+		limits[TaskType(strings.TrimSpace(typ))] = v
+	}
+	return limits, nil
+}
+
+var priorityNames = map[string]Priority{
+	"low":    PriorityLow,
+	"normal": PriorityNormal,
+	"high":   PriorityHigh,
+}
+
+// ParsePriorities parses priorities written as "render.mp4=low,concat.wav=high"
+func ParsePriorities(s string) (map[TaskType]Priority, error) {
+	prios := make(map[TaskType]Priority)
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		typ, name, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad priority %q, want type=low|normal|high", part)
+		}
+		p, ok := priorityNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("bad priority %q, want type=low|normal|high", part)
+		}
+		prios[TaskType(strings.TrimSpace(typ))] = p
+	}
+	return prios, nil
+}
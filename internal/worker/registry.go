@@ -0,0 +1,555 @@
+package worker
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"comp0ser/internal/cmd"
+)
+
+// registry keeps the status of every task submitted to the worker
+type registry struct {
+	mu      sync.RWMutex
+	entries map[string]*entry
+	order   []string // submission order
+
+	// byKey and byHash list the tasks of every idempotency key and payload
+	// hash in submission order, so findDuplicate only looks at candidates
+	byKey  map[string][]string
+	byHash map[string][]string
+
+	// journal persists every change, nil keeps the registry in memory only
+	journal *journal
+	// events is fed with every change
+	events *hub
+}
+
+type entry struct {
+	status  TaskStatus
+	payload json.RawMessage
+
+	// task is the live handle, nil once the task reached a terminal state
+	task      *Task
+	cancelled bool
+	// delivering is set once the callback delivery started in this process
+	delivering bool
+}
+
+func newRegistry(j *journal) *registry {
+	return &registry{
+		entries: make(map[string]*entry),
+		byKey:   make(map[string][]string),
+		byHash:  make(map[string][]string),
+		journal: j,
+		events:  newHub(),
+	}
+}
+
+// add registers a queued task, unless it duplicates another one whose ID is
+// returned instead
+func (r *registry) add(task *Task) string {
+	return r.addWith(task, TaskQueued, nil, nil)
+}
+
+// addWaiting registers a task held until every task in deps succeeded
+func (r *registry) addWaiting(task *Task, deps []string, refs map[string]string) string {
+	return r.addWith(task, TaskWaiting, deps, refs)
+}
+
+func (r *registry) addWith(task *Task, state TaskState, deps []string, refs map[string]string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if dup := r.findDuplicate(task); dup != "" {
+		return dup
+	}
+
+	e := &entry{
+		status: TaskStatus{
+			ID:             task.ID,
+			Type:           task.Type,
+			State:          state,
+			Priority:       task.Priority,
+			DependsOn:      deps,
+			Refs:           refs,
+			IdempotencyKey: task.idempotencyKey,
+			PayloadHash:    task.payloadHash,
+			CreatedAt:      time.Now(),
+		},
+		payload: task.Payload,
+		task:    task,
+	}
+	if task.callback != "" {
+		e.status.Callback = &CallbackStatus{URL: task.callback}
+	}
+	r.entries[task.ID] = e
+	r.order = append(r.order, task.ID)
+	r.index(&e.status)
+	r.persist(record{Status: e.status, Payload: e.payload})
+	r.events.publish(stateEvent(&e.status))
+	return ""
+}
+
+// restore registers a task replayed from the journal
+func (r *registry) restore(rec record, task *Task) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := &entry{
+		status:  rec.Status,
+		payload: rec.Payload,
+		task:    task,
+	}
+	r.entries[rec.Status.ID] = e
+	r.order = append(r.order, rec.Status.ID)
+	r.index(&e.status)
+}
+
+func (r *registry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.entries[id]; ok {
+		r.unindex(&e.status)
+	}
+	delete(r.entries, id)
+	r.order = slices.DeleteFunc(r.order, func(v string) bool { return v == id })
+	r.persist(record{Status: TaskStatus{ID: id}, Removed: true})
+}
+
+// prune drops the tasks that finished before cutoff and compacts the journal
+// down to the tasks left when it dropped any or the journal is overgrown, it
+// returns how many were dropped
+func (r *registry) prune(cutoff time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var dropped int
+	kept := r.order[:0]
+	for _, id := range r.order {
+		e := r.entries[id]
+		s := &e.status
+		if e.task == nil && s.State.Done() && s.FinishedAt != nil && s.FinishedAt.Before(cutoff) {
+			r.unindex(s)
+			delete(r.entries, id)
+			dropped++
+			continue
+		}
+		kept = append(kept, id)
+	}
+	clear(r.order[len(kept):])
+	r.order = kept
+
+	if r.journal == nil || (dropped == 0 && !r.journal.overgrown(len(r.order))) {
+		return dropped
+	}
+	recs := make([]record, 0, len(r.order))
+	for _, id := range r.order {
+		e := r.entries[id]
+		recs = append(recs, record{Status: e.status, Payload: e.payload})
+	}
+	if err := r.journal.rewrite(recs); err != nil {
+		slog.Error("compact task journal failed", "err", err)
+	}
+	return dropped
+}
+
+func dupKey(typ TaskType, key string) string {
+	return string(typ) + "\x00" + key
+}
+
+// index adds s to the duplicate lookups, the caller holds the lock
+func (r *registry) index(s *TaskStatus) {
+	if s.IdempotencyKey != "" {
+		k := dupKey(s.Type, s.IdempotencyKey)
+		r.byKey[k] = append(r.byKey[k], s.ID)
+	}
+	if s.PayloadHash != "" {
+		r.byHash[s.PayloadHash] = append(r.byHash[s.PayloadHash], s.ID)
+	}
+}
+
+func (r *registry) unindex(s *TaskStatus) {
+	drop := func(m map[string][]string, k string) {
+		ids := slices.DeleteFunc(m[k], func(v string) bool { return v == s.ID })
+		if len(ids) == 0 {
+			delete(m, k)
+			return
+		}
+		m[k] = ids
+	}
+	if s.IdempotencyKey != "" {
+		drop(r.byKey, dupKey(s.Type, s.IdempotencyKey))
+	}
+	if s.PayloadHash != "" {
+		drop(r.byHash, s.PayloadHash)
+	}
+}
+
+func (r *registry) update(id string, fn func(e *entry)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[id]
+	if !ok {
+		return
+	}
+	prev := e.status.State
+	fn(e)
+	r.persist(record{Status: e.status})
+	if e.status.State != prev {
+		r.events.publish(stateEvent(&e.status))
+	}
+}
+
+func stateEvent(s *TaskStatus) Event {
+	e := Event{
+		Type:     EventState,
+		TaskID:   s.ID,
+		TaskType: s.Type,
+		State:    s.State,
+		Error:    s.Error,
+	}
+	if s.State == TaskSucceeded {
+		e.Outputs = slices.Clone(s.Outputs)
+	}
+	return e
+}
+
+func (r *registry) persist(rec record) {
+	if r.journal == nil {
+		return
+	}
+	if err := r.journal.append(rec); err != nil {
+		slog.Error("append task journal failed",
+			"task_id", rec.Status.ID,
+			"err", err,
+		)
+	}
+}
+
+func (r *registry) markRunning(id string) {
+	r.update(id, func(e *entry) {
+		now := time.Now()
+		e.status.State = TaskRunning
+		e.status.StartedAt = &now
+	})
+}
+
+// markAttempt counts a run, outputs are reset while the error of the previous
+// attempt is kept until the task finishes
+func (r *registry) markAttempt(id string, prev error) {
+	r.update(id, func(e *entry) {
+		e.status.Attempts++
+		e.status.Outputs = nil
+		e.status.Progress = nil
+		if prev != nil {
+			e.status.Error = prev.Error()
+		}
+	})
+}
+
+func (r *registry) markDeadLetter(id string) {
+	r.update(id, func(e *entry) {
+		e.status.DeadLetter = true
+	})
+}
+
+// takeDeadLetter clears the dead letter flag and returns the task payload
+func (r *registry) takeDeadLetter(id string, resubmittedAs string) (TaskStatus, json.RawMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[id]
+	if !ok {
+		return TaskStatus{}, nil, ErrTaskNotFound
+	}
+	if !e.status.DeadLetter {
+		return TaskStatus{}, nil, ErrNotDeadLetter
+	}
+	e.status.DeadLetter = false
+	e.status.ResubmittedAs = resubmittedAs
+	r.persist(record{Status: e.status})
+	return e.status.clone(), e.payload, nil
+}
+
+// markQueued puts an interrupted task back in the queue
+func (r *registry) markQueued(id string) {
+	r.update(id, func(e *entry) {
+		e.status.State = TaskQueued
+		e.status.StartedAt = nil
+	})
+}
+
+// requeue puts a task stopped by a drain back in the queued state so the
+// next start runs it again, a task the user cancelled is left to markDone
+func (r *registry) requeue(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[id]
+	if !ok || e.cancelled {
+		return false
+	}
+	e.status.State = TaskQueued
+	e.status.StartedAt = nil
+	e.status.Stage = ""
+	e.status.Progress = nil
+	r.persist(record{Status: e.status})
+	r.events.publish(stateEvent(&e.status))
+	return true
+}
+
+func (r *registry) markInterrupted(id string) {
+	r.update(id, func(e *entry) {
+		e.status.State = TaskInterrupted
+	})
+}
+
+// release queues a waiting task with its output references expanded, it
+// reports false if the task is no longer waiting
+func (r *registry) release(id string, payload json.RawMessage) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[id]
+	if !ok || e.task == nil || e.status.State != TaskWaiting {
+		return false
+	}
+	e.status.State = TaskQueued
+	e.payload = payload
+	e.task.Payload = payload
+	r.persist(record{Status: e.status, Payload: e.payload})
+	r.events.publish(stateEvent(&e.status))
+	return true
+}
+
+// abandon finishes a waiting task that can never run, it reports false if the
+// task is no longer waiting
+func (r *registry) abandon(id string, state TaskState, err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[id]
+	if !ok || e.status.State != TaskWaiting {
+		return false
+	}
+	now := time.Now()
+	e.status.State = state
+	e.status.Error = err.Error()
+	e.status.FinishedAt = &now
+	e.status.DeadLetter = state == TaskFailed
+	if e.task != nil {
+		e.task.cancel()
+		e.task = nil
+	}
+	r.persist(record{Status: e.status})
+	r.events.publish(stateEvent(&e.status))
+	return true
+}
+
+// claimCallback returns the status of a finished task whose callback is still
+// to be delivered, at most once per process
+func (r *registry) claimCallback(id string) (TaskStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[id]
+	if !ok || e.delivering || !e.status.State.Done() {
+		return TaskStatus{}, false
+	}
+	cb := e.status.Callback
+	if cb == nil || cb.Delivered || cb.GaveUp {
+		return TaskStatus{}, false
+	}
+	e.delivering = true
+	return e.status.clone(), true
+}
+
+func (r *registry) recordCallback(id string, a CallbackAttempt, delivered, gaveUp bool) {
+	r.update(id, func(e *entry) {
+		cb := e.status.Callback
+		if cb == nil {
+			return
+		}
+		cb.Attempts = append(cb.Attempts, a)
+		cb.Delivered = delivered
+		cb.GaveUp = gaveUp
+	})
+}
+
+// dependents returns the waiting tasks that depend on id
+func (r *registry) dependents(id string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var out []string
+	for _, cid := range r.order {
+		s := &r.entries[cid].status
+		if s.State == TaskWaiting && slices.Contains(s.DependsOn, id) {
+			out = append(out, cid)
+		}
+	}
+	return out
+}
+
+// markCancelled flags the task as cancelled, a task waiting for a worker or a
+// dependency is finished right away while a running one keeps its state until
+// the handler returns
+func (r *registry) markCancelled(id string) {
+	r.update(id, func(e *entry) {
+		e.cancelled = true
+		switch e.status.State {
+		case TaskQueued, TaskInterrupted, TaskWaiting:
+			now := time.Now()
+			e.status.State = TaskCancelled
+			e.status.FinishedAt = &now
+			e.task = nil
+		}
+	})
+}
+
+// markDone finishes the task, failed tasks go to the dead letter list
+func (r *registry) markDone(id string, err error) {
+	r.update(id, func(e *entry) {
+		now := time.Now()
+		e.status.FinishedAt = &now
+		e.task = nil
+
+		switch {
+		case e.cancelled:
+			e.status.State = TaskCancelled
+		case err != nil:
+			e.status.State = TaskFailed
+			e.status.Error = err.Error()
+			e.status.DeadLetter = true
+		default:
+			e.status.State = TaskSucceeded
+			e.status.Error = ""
+		}
+	})
+}
+
+func (r *registry) setStage(id, stage string) {
+	r.update(id, func(e *entry) {
+		e.status.Stage = stage
+		e.status.Progress = nil
+		r.events.publish(Event{
+			Type:     EventStage,
+			TaskID:   id,
+			TaskType: e.status.Type,
+			Stage:    stage,
+		})
+	})
+}
+
+// setProgress is called several times a second while ffmpeg runs, so unlike
+// the other changes it is not written to the journal
+func (r *registry) setProgress(id string, p *cmd.Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.entries[id]; ok {
+		e.status.Progress = p
+		r.events.publish(Event{
+			Type:     EventProgress,
+			TaskID:   id,
+			TaskType: e.status.Type,
+			Stage:    e.status.Stage,
+			Progress: p,
+		})
+	}
+}
+
+func (r *registry) addOutputs(id string, paths ...string) {
+	r.update(id, func(e *entry) {
+		e.status.Outputs = append(e.status.Outputs, paths...)
+		r.events.publish(Event{
+			Type:     EventOutput,
+			TaskID:   id,
+			TaskType: e.status.Type,
+			Stage:    e.status.Stage,
+			Outputs:  slices.Clone(paths),
+		})
+	})
+}
+
+// addSegment records the wav of one narration segment
+func (r *registry) addSegment(id, segment, path string) {
+	r.update(id, func(e *entry) {
+		e.status.Outputs = append(e.status.Outputs, path)
+		r.events.publish(Event{
+			Type:     EventSegment,
+			TaskID:   id,
+			TaskType: e.status.Type,
+			Stage:    e.status.Stage,
+			Segment:  segment,
+			Outputs:  []string{path},
+		})
+	})
+}
+
+// live returns the handle of a task that has not finished yet
+func (r *registry) live(id string) (*Task, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.entries[id]
+	if !ok || e.task == nil {
+		return nil, false
+	}
+	return e.task, true
+}
+
+func (r *registry) get(id string) (TaskStatus, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.entries[id]
+	if !ok {
+		return TaskStatus{}, false
+	}
+	return e.status.clone(), true
+}
+
+func (r *registry) list(f TaskFilter) []TaskStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]TaskStatus, 0, len(r.order))
+	for _, id := range r.order {
+		s := &r.entries[id].status
+		if f.Type != "" && s.Type != f.Type {
+			continue
+		}
+		if f.State != "" && s.State != f.State {
+			continue
+		}
+		if f.DeadLetter && !s.DeadLetter {
+			continue
+		}
+		out = append(out, s.clone())
+	}
+	return out
+}
+
+func (s *TaskStatus) clone() TaskStatus {
+	c := *s
+	c.Outputs = slices.Clone(s.Outputs)
+	c.DependsOn = slices.Clone(s.DependsOn)
+	c.Refs = maps.Clone(s.Refs)
+	if s.Progress != nil {
+		p := *s.Progress
+		c.Progress = &p
+	}
+	if s.Callback != nil {
+		cb := *s.Callback
+		cb.Attempts = slices.Clone(s.Callback.Attempts)
+		c.Callback = &cb
+	}
+	return c
+}
@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy controls how a failed task of one type is retried
+type RetryPolicy struct {
+	// MaxAttempts counts the first run, values below 2 disable retries
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	// Retryable reports whether err is transient, nil falls back to IsRetryable
+	Retryable func(error) bool
+}
+
+var defaultRetryPolicies = map[TaskType]RetryPolicy{
+	GenScript:    {MaxAttempts: 3, BaseDelay: 5 * time.Second, MaxDelay: time.Minute},
+	GenTTSAll:    {MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: time.Minute},
+	GenTTSSingle: {MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: time.Minute},
+
+	// stages that are up to date are skipped, so a retry resumes the episode
+	EpisodeBuild: {MaxAttempts: 3, BaseDelay: 10 * time.Second, MaxDelay: 2 * time.Minute},
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff returns the delay before the next attempt, exponential in attempt
+// with the upper half jittered
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryable classifies err, errors exposing Temporary() decide for
+// themselves, bad payloads and errors marked Permanent are never retried and
+// anything else is assumed transient
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var perm *permanentError
+	if errors.As(err, &perm) {
+		return false
+	}
+
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return false
+	}
+
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) {
+		return temp.Temporary()
+	}
+	return true
+}
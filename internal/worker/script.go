@@ -3,19 +3,28 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"path/filepath"
 	"unicode/utf8"
 
+	"comp0ser/internal/filestore"
 	"comp0ser/prompts"
 )
 
-func (w *worker) handleScriptGen(task *Task) error {
+func (w *worker) handleScriptGen(ctx context.Context, task *Task) error {
 	var p GenScriptPayLoad
 	if err := json.Unmarshal(task.Payload, &p); err != nil {
 		return err
 	}
+	return w.genScript(ctx, task, p)
+}
 
+func (w *worker) genScript(ctx context.Context, task *Task, p GenScriptPayLoad) error {
+	if err := checkProject(p.Subject); err != nil {
+		return err
+	}
 	prompt, err := w.renderer.System(prompts.Config{
 		Subject:  p.Subject,
 		Segments: p.Segments,
@@ -28,7 +37,7 @@ func (w *worker) handleScriptGen(task *Task) error {
 		return err
 	}
 
-	contents, err := w.llm.GenScript(context.Background(), p.Model, p.RawText, prompt)
+	contents, err := w.llm.GenScript(ctx, p.Model, p.RawText, prompt)
 	if err != nil {
 		return err
 	}
@@ -41,17 +50,42 @@ func (w *worker) handleScriptGen(task *Task) error {
 	if err != nil {
 		return err
 	}
+	if _, err := w.fs.UpdateProject(p.Subject, func(pr *filestore.Project) error {
+		pr.Subject = p.Subject
+		pr.Prompt = filestore.Prompt{
+			Model:    p.Model,
+			Segments: p.Segments,
+			MinChars: p.MinChars,
+			MaxChars: p.MaxChars,
+			Focus:    p.Focus,
+			Hook:     p.Hook,
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("update project %s failed: %w", p.Subject, err)
+	}
 
+	// a new script replaces the old one, which also makes a retry after a
+	// failed write start from scratch
+	nars := make([]filestore.Narration, len(contents))
 	for i, content := range contents {
-		id, err := w.fs.Append(p.Subject, fmt.Sprintf("%04d", i), content, nil)
-		if err != nil {
-			return err
+		nars[i] = filestore.Narration{
+			ID:   fmt.Sprintf("%04d", i),
+			Text: content,
 		}
-		slog.Info("append narration ok",
-			"idx", i,
-			"narID", id,
-		)
 	}
+	if _, err := w.fs.Replace(p.Subject, nars); err != nil {
+		if errors.Is(err, filestore.ErrBadEdit) {
+			return Permanent(err)
+		}
+		return err
+	}
+	slog.Info("replace narrations ok",
+		"subject", p.Subject,
+		"count", len(nars),
+	)
+
+	w.reg.addOutputs(task.ID, filepath.Join(doc, "narration.txt"))
 
 	slog.Info("handle genScript ok",
 		"doc_name", doc,
@@ -7,15 +7,20 @@ import (
 	"log/slog"
 )
 
-func (w *worker) handleGensubtitle(task *Task) error {
+func (w *worker) handleGensubtitle(ctx context.Context, task *Task) error {
 	var p GenSubtitlePayload
-
 	if err := json.Unmarshal(task.Payload, &p); err != nil {
 		return err
 	}
+	return w.genSubtitle(ctx, task, p)
+}
 
+func (w *worker) genSubtitle(ctx context.Context, task *Task, p GenSubtitlePayload) error {
 	slog.Info("gen subtitle task start")
 
+	if err := w.resolvePaths(&p.AudioPath, &p.OutputPath); err != nil {
+		return err
+	}
 	cmd, err := w.whisper.GenSubtitle(p.AudioPath, p.OutputPath, p.Lang)
 	if err != nil {
 		return fmt.Errorf("gen subtitle failed: %w", err)
@@ -23,9 +28,10 @@ func (w *worker) handleGensubtitle(task *Task) error {
 
 	fmt.Println(cmd.Args)
 
-	if err := w.runner.Run(context.Background(), cmd); err != nil {
+	if err := w.runner.Run(ctx, cmd); err != nil {
 		return err
 	}
+	w.reg.addOutputs(task.ID, cmd.Outputs...)
 
 	slog.Info("gen subtitle task ok",
 		"audio_path", p.AudioPath,
@@ -35,23 +41,30 @@ func (w *worker) handleGensubtitle(task *Task) error {
 	return nil
 }
 
-func (w *worker) handleBrunSubtitle(task *Task) error {
+func (w *worker) handleBrunSubtitle(ctx context.Context, task *Task) error {
 	var p BrunSubtitlePayLoad
-
 	if err := json.Unmarshal(task.Payload, &p); err != nil {
 		return err
 	}
+	return w.burnSubtitle(ctx, task, p)
+}
 
+func (w *worker) burnSubtitle(ctx context.Context, task *Task, p BrunSubtitlePayLoad) error {
 	slog.Info("brun subtitle task start")
 
+	if err := w.resolvePaths(&p.VideoPath, &p.SubtitlePath, &p.OutputPath); err != nil {
+		return err
+	}
 	cmd, err := w.ff.BurnSubtitle(p.VideoPath, p.SubtitlePath, p.OutputPath)
 	if err != nil {
 		return fmt.Errorf("fetch cmd from brun subtitle failed: %w", err)
 	}
+	cmd.Duration = probeDuration(p.VideoPath)
 
-	if err := w.runner.Run(context.Background(), cmd); err != nil {
+	if err := w.runFFmpeg(ctx, task, cmd); err != nil {
 		return err
 	}
+	w.reg.addOutputs(task.ID, cmd.Outputs...)
 
 	slog.Info("gen subtitle task ok",
 		"vedio_path", p.VideoPath,
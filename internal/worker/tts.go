@@ -2,41 +2,74 @@ package worker
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"path/filepath"
+
+	"comp0ser/internal/cmd"
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/tts"
 )
 
-func (w *worker) handleTTSAll(task *Task) error {
+// errNarrationChanged is returned when a narration was edited while its
+// audio was synthesized, a retry synthesizes the new text
+var errNarrationChanged = errors.New("narration changed during synthesis")
+
+func (w *worker) handleTTSAll(ctx context.Context, task *Task) error {
 	var p GenTTSPayLoad
 	if err := json.Unmarshal(task.Payload, &p); err != nil {
 		return err
 	}
+	return w.ttsAll(ctx, task, p)
+}
 
+func (w *worker) ttsAll(ctx context.Context, task *Task, p GenTTSPayLoad) error {
 	if p.Folder == "" {
-		return fmt.Errorf("empty foler")
+		return Permanent(fmt.Errorf("empty foler"))
+	}
+	if err := checkProject(p.Folder); err != nil {
+		return err
 	}
 
 	nars, err := w.fs.List(p.Folder)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	for i, nar := range nars {
-		b, err := w.tts.Synthesize(nar["text"].(string))
-		if err != nil {
-			return fmt.Errorf("tts failed idx = %s: %w", nar["id"].(string), err)
+	provider, client, err := w.ttsClient(p.Folder, p.TTS)
+	if err != nil {
+		return err
+	}
+	voice := w.projectVoice(p.Folder)
+
+	dir := filepath.Join(w.fs.Dir(), p.Folder)
+	for _, nar := range nars {
+		// segments whose text, voice and provider did not change since they
+		// were synthesized are kept, which also lets a retry resume where the
+		// last attempt failed, an invalidated segment is synthesized again
+		// whatever its text
+		v := narrationVoice(nar, voice)
+		step, fp := ttsStep(nar), ttsFingerprint(provider, v, nar.Text)
+		if outs, ok := w.builds.upToDate(dir, step, fp); ok && nar.AudioID != "" {
+			for _, out := range outs {
+				w.reg.addSegment(task.ID, nar.ID, out)
+			}
+			continue
 		}
-		id := fmt.Sprintf("%04d", i)
 
-		audioID, dst, err := w.fs.Save(p.Folder, id, ".wav", bytes.NewReader(b))
+		audioID, dst, err := w.synthesize(ctx, task, client, p.Folder, nar, v)
 		if err != nil {
-			return fmt.Errorf("save wav failed: %w", err)
+			return err
 		}
-		fmt.Printf("id = %s, folder = %s\n", id, p.Folder)
-		if err := w.fs.Add(p.Folder, id, map[string]any{"audio_id": id}, nil); err != nil {
-			return fmt.Errorf("add field into %s's narrations failed: %w", p.Folder, err)
+		if err := w.builds.record(dir, step, fp, []string{dst}); err != nil {
+			return fmt.Errorf("record build step %s failed: %w", step, err)
 		}
+		w.reg.addSegment(task.ID, nar.ID, dst)
 
 		slog.Info("save wav ok",
 			"folder", p.Folder,
@@ -48,45 +81,52 @@ func (w *worker) handleTTSAll(task *Task) error {
 	return nil
 }
 
-func (w *worker) handleTTSSingle(task *Task) error {
+func (w *worker) handleTTSSingle(ctx context.Context, task *Task) error {
 	var p GenTTSSinglePayLoad
-
 	if err := json.Unmarshal(task.Payload, &p); err != nil {
 		return err
 	}
+	return w.ttsSingle(ctx, task, p)
+}
 
+func (w *worker) ttsSingle(ctx context.Context, task *Task, p GenTTSSinglePayLoad) error {
 	if p.Folder == "" {
-		return fmt.Errorf("empty folder")
+		return Permanent(fmt.Errorf("empty folder"))
+	}
+	if err := checkProject(p.Folder); err != nil {
+		return err
 	}
 
+	provider, client, err := w.ttsClient(p.Folder, p.TTS)
+	if err != nil {
+		return err
+	}
+	voice := w.projectVoice(p.Folder)
+
 	nars, err := w.fs.List(p.Folder)
 	slog.Debug("fetch nars list from local store",
 		"folder", p.Folder,
 		"nars", nars,
 	)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	for i, nar := range nars {
-		if nar["id"].(string) != p.NarID {
+	for _, nar := range nars {
+		if nar.ID != p.NarID {
 			continue
 		}
 
-		b, err := w.tts.Synthesize(nar["text"].(string))
+		v := narrationVoice(nar, voice)
+		audioID, dst, err := w.synthesize(ctx, task, client, p.Folder, nar, v)
 		if err != nil {
-			return fmt.Errorf("tts failed idx = %s: %w", nar["id"].(string), err)
+			return err
 		}
-		id := fmt.Sprintf("%04d", i)
-
-		audioID, dst, err := w.fs.Save(p.Folder, id, ".wav", bytes.NewReader(b))
-		if err != nil {
-			return fmt.Errorf("save wav failed: %w", err)
-		}
-		fmt.Printf("id = %s, folder = %s\n", id, p.Folder)
-		if err := w.fs.Add(p.Folder, id, map[string]any{"audio_id": id}, nil); err != nil {
-			return fmt.Errorf("add field into %s's narrations failed: %w", p.Folder, err)
+		dir := filepath.Join(w.fs.Dir(), p.Folder)
+		if err := w.builds.record(dir, ttsStep(nar), ttsFingerprint(provider, v, nar.Text), []string{dst}); err != nil {
+			return fmt.Errorf("record build step failed: %w", err)
 		}
+		w.reg.addSegment(task.ID, p.NarID, dst)
 
 		slog.Info("save wav ok",
 			"folder", p.Folder,
@@ -97,3 +137,127 @@ func (w *worker) handleTTSSingle(task *Task) error {
 
 	return nil
 }
+
+// ttsClient picks the provider named by the payload, else the one of the
+// project, else the default
+func (w *worker) ttsClient(folder, name string) (string, tts.Client, error) {
+	if name == "" {
+		p, err := w.fs.Project(folder)
+		if err != nil {
+			return "", nil, err
+		}
+		name = p.TTS
+	}
+	client, err := w.tts.Client(name)
+	if err != nil {
+		return "", nil, Permanent(err)
+	}
+	return w.tts.Resolve(name), client, nil
+}
+
+// projectVoice is the voice of the project, empty when it has none or its
+// metadata cannot be read
+func (w *worker) projectVoice(folder string) string {
+	p, err := w.fs.Project(folder)
+	if err != nil {
+		return ""
+	}
+	return p.Voice
+}
+
+// narrationVoice is the voice of nar, else the one of its project
+func narrationVoice(nar filestore.Narration, projectVoice string) string {
+	if nar.Voice != "" {
+		return nar.Voice
+	}
+	return projectVoice
+}
+
+// synthesize stores the wav of a narration spoken by voice. A streaming
+// client writes the chunks to disk as they come and reports the seconds
+// received as progress
+func (w *worker) synthesize(ctx context.Context, task *Task, client tts.Client, folder string, nar filestore.Narration, voice string) (string, string, error) {
+	st, ok := client.(tts.Streamer)
+	if !ok {
+		b, err := client.Synthesize(ctx, nar.Text, voice)
+		if err != nil {
+			w.markNarrationFailed(folder, nar.ID)
+			return "", "", fmt.Errorf("tts failed idx = %s: %w", nar.ID, err)
+		}
+		return w.saveNarrationAudio(folder, nar, bytes.NewReader(b))
+	}
+
+	f, err := os.CreateTemp(filepath.Join(w.fs.Dir(), folder, "audio"), "."+nar.ID+".stream-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	err = st.Stream(ctx, nar.Text, voice, f, func(p tts.Progress) {
+		w.reg.setProgress(task.ID, &cmd.Progress{OutTime: p.Seconds, Percent: -1})
+	})
+	if err != nil {
+		w.markNarrationFailed(folder, nar.ID)
+		return "", "", fmt.Errorf("tts failed idx = %s: %w", nar.ID, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	return w.saveNarrationAudio(folder, nar, f)
+}
+
+// saveNarrationAudio stores the wav synthesized from nar and records it on
+// the narration line, unless the line was edited meanwhile
+func (w *worker) saveNarrationAudio(folder string, synthesized filestore.Narration, wav io.Reader) (string, string, error) {
+	narID := synthesized.ID
+	audioID, dst, err := w.fs.Save(folder, narID, ".wav", wav)
+	if err != nil {
+		return "", "", fmt.Errorf("save wav failed: %w", err)
+	}
+	if _, err := w.fs.Update(folder, narID, func(nar *filestore.Narration) error {
+		if nar.Text != synthesized.Text || nar.Voice != synthesized.Voice {
+			// the edit invalidated the line, it stays pending
+			return fmt.Errorf("%w: %s", errNarrationChanged, narID)
+		}
+		nar.AudioID = audioID
+		nar.Duration = probeDuration(dst)
+		nar.Status = filestore.NarrationSynthesized
+		return nil
+	}); err != nil {
+		return "", "", fmt.Errorf("update %s's narration %s failed: %w", folder, narID, err)
+	}
+	return audioID, dst, nil
+}
+
+func (w *worker) markNarrationFailed(folder, narID string) {
+	if _, err := w.fs.Update(folder, narID, func(nar *filestore.Narration) error {
+		nar.Status = filestore.NarrationFailed
+		return nil
+	}); err != nil {
+		slog.Warn("mark narration failed",
+			"folder", folder,
+			"nar_id", narID,
+			"err", err,
+		)
+	}
+}
+
+func ttsStep(nar filestore.Narration) string {
+	return "tts/" + nar.ID
+}
+
+// ttsFingerprint leaves volc and an empty voice out, segments synthesized
+// before there were providers and voices stay up to date
+func ttsFingerprint(provider, voice, text string) string {
+	fp := newFingerprint("tts").param("text", text)
+	if provider != tts.ProviderVolc {
+		fp = fp.param("provider", provider)
+	}
+	if voice != "" {
+		fp = fp.param("voice", voice)
+	}
+	return fp.sum()
+}
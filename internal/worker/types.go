@@ -3,6 +3,9 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"time"
+
+	"comp0ser/internal/cmd"
 )
 
 type GenScriptPayLoad struct {
@@ -27,7 +30,7 @@ type GenSubtitlePayload struct {
 }
 
 type RenderPayLoad struct {
-	Folder  string  `jsonm:"foler"`
+	Folder  string  `json:"folder"`
 	Dur     float64 `json:"dur"`     // 目标总时长（秒）
 	TailCut float64 `json:"tailCut"` // 每段末尾剪掉秒数（比如 10）
 	Loop    bool    `json:"loop"`    // 不够 dur 是否循环补足
@@ -47,31 +50,57 @@ type MergePayLoad struct {
 }
 
 type ConcatPayLoad struct {
-	Folder string `jsonm:"foler"`
+	Folder string `json:"folder"`
 }
 
 type GenTTSPayLoad struct {
 	Folder string `json:"folder"`
+	// TTS overrides the provider of the project
+	TTS string `json:"tts,omitempty"`
 }
 
 type GenTTSSinglePayLoad struct {
 	Folder string `json:"folder"`
 	NarID  string `json:"narId"`
+	TTS    string `json:"tts,omitempty"`
 }
 
 type MixdownPayLoad struct {
 	AudioPath string  `json:"audioPath"`
-	BGMPath   string  `json:"BGMPath"`
+	BGMPath   string  `json:"bgmPath"`
 	Filename  string  `json:"filename"`
 	Volume    float64 `json:"volume"`
 	Loop      bool    `json:"loop"`
 }
 
-type Narration struct {
-	ID      int    `json:"id"`
-	Text    string `json:"text"`
-	AudioID string `json:"audio_id"`
+type EpisodePayLoad struct {
+	GenScriptPayLoad
+
+	// TTS overrides the provider of the project
+	TTS string `json:"tts,omitempty"`
+
+	Render   EpisodeRender    `json:"render"`
+	Mix      EpisodeMix       `json:"mix"`
+	Subtitle *EpisodeSubtitle `json:"subtitle,omitempty"` // nil skips subtitles
+}
+
+type EpisodeRender struct {
+	Dur     float64 `json:"dur"` // 目标总时长（秒），为 0 时取旁白时长
+	TailCut float64 `json:"tailCut"`
+	Loop    bool    `json:"loop"`
+}
+
+type EpisodeMix struct {
+	BGM    string  `json:"bgm"` // 相对项目目录的 BGM 路径
+	Volume float64 `json:"volume"`
+	Loop   bool    `json:"loop"`
+}
+
+type EpisodeSubtitle struct {
+	Lang string `json:"lang"`
+	Burn bool   `json:"burn"`
 }
+
 type Cmd struct{}
 
 type TaskType string
@@ -86,12 +115,116 @@ const (
 	Merge        TaskType = "m4a.merge.mp4"
 	GenSrt       TaskType = "audio.gen.srt"
 	Brun         TaskType = "mp4.brun.sub"
+	EpisodeBuild TaskType = "episode.build"
 )
 
+// Valid reports whether t is one of the built-in task types
+func (t TaskType) Valid() bool {
+	switch t {
+	case GenScript, GenTTSAll, GenTTSSingle, Mixdown, Concat, Render, Merge, GenSrt, Brun, EpisodeBuild:
+		return true
+	}
+	return false
+}
+
 type Task struct {
 	ID      string
 	Type    TaskType
 	Payload json.RawMessage
+
+	Priority Priority
+
+	// callback is the completion webhook url given on submission
+	callback string
+
+	// idempotencyKey and payloadHash detect duplicate submissions
+	idempotencyKey string
+	payloadHash    string
+
+	// ctx is derived from the worker lifecycle and cancelled by Worker.Cancel
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
+type TaskState string
+
+const (
+	TaskQueued    TaskState = "queued"
+	TaskRunning   TaskState = "running"
+	TaskSucceeded TaskState = "succeeded"
+	TaskFailed    TaskState = "failed"
+	TaskCancelled TaskState = "cancelled"
+
+	// TaskInterrupted marks a task that was running when the server stopped
+	TaskInterrupted TaskState = "interrupted"
+	// TaskWaiting marks a task held until the tasks it depends on succeed
+	TaskWaiting TaskState = "waiting"
+)
+
+// Done reports whether the state is terminal
+func (s TaskState) Done() bool {
+	return s == TaskSucceeded || s == TaskFailed || s == TaskCancelled
+}
+
+// TaskStatus is a snapshot of a task kept by the worker registry
+type TaskStatus struct {
+	ID      string    `json:"id"`
+	Type    TaskType  `json:"type"`
+	State   TaskState `json:"state"`
+	Error   string    `json:"error,omitempty"`
+	Outputs []string  `json:"outputs,omitempty"`
+
+	// Stage is the running stage of a pipeline task
+	Stage string `json:"stage,omitempty"`
+
+	Priority Priority `json:"priority"`
+
+	// Progress of the running ffmpeg command, kept in memory only
+	Progress *cmd.Progress `json:"progress,omitempty"`
+
+	// DependsOn lists the tasks that must succeed before this one is queued
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Refs maps the names used in output references of the payload to task
+	// IDs, set for tasks submitted as part of a job
+	Refs map[string]string `json:"refs,omitempty"`
+
+	Attempts int `json:"attempts"`
+	// DeadLetter is set once the task failed for good, until it is
+	// resubmitted or discarded
+	DeadLetter    bool   `json:"deadLetter,omitempty"`
+	ResubmittedAs string `json:"resubmittedAs,omitempty"`
+
+	Callback *CallbackStatus `json:"callback,omitempty"`
+
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	PayloadHash    string `json:"payloadHash,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// TaskFilter selects tasks from the registry, zero fields match everything
+type TaskFilter struct {
+	Type       TaskType
+	State      TaskState
+	DeadLetter bool
+}
+
+// HandlerFunc runs a task type registered through Config.Handlers, a string
+// or []string result is recorded as the task outputs
 type HandlerFunc func(ctx context.Context, payload json.RawMessage) (any, error)
+
+// JobTask is one node of a job graph, see Worker.SubmitJob
+type JobTask struct {
+	// Name identifies the task inside the job
+	Name    string          `json:"name"`
+	Type    TaskType        `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+
+	// DependsOn holds names of other tasks in the job or IDs of tasks that
+	// were submitted before
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	CallbackURL string `json:"callbackUrl,omitempty"`
+}
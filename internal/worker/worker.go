@@ -3,14 +3,18 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"comp0ser/internal/cmd"
 	"comp0ser/internal/filestore"
-	"comp0ser/internal/llm"
+	"comp0ser/internal/sandbox"
 	"comp0ser/internal/tts"
 	"comp0ser/prompts"
 )
@@ -20,50 +24,155 @@ const (
 	defaultQueueCapacity = defaultWorkerCount * 8
 )
 
+var (
+	ErrTaskNotFound     = errors.New("task not found")
+	ErrTaskFinished     = errors.New("task already finished")
+	ErrTaskNotResumable = errors.New("task is not interrupted")
+	ErrNotDeadLetter    = errors.New("task is not a dead letter")
+	ErrWorkerStopped    = errors.New("worker is stopped")
+
+	errDrained = errors.New("interrupted by drain")
+)
+
 type Config struct {
 	WorkerCount   int
 	QueueCapacity int
 
+	// StateDir holds the task journal, empty keeps the queue in memory only
+	StateDir string
+	// ResumeInterrupted re-enqueues tasks that were running when the server
+	// stopped, otherwise they wait in the interrupted state for Resume
+	ResumeInterrupted bool
+
+	// RetryPolicies overrides the default retry policy per task type
+	RetryPolicies map[TaskType]RetryPolicy
+
+	// Handlers registers extra task types next to the built-in ones
+	Handlers map[TaskType]HandlerFunc
+
+	// Concurrency overrides the default cap on running tasks per type, 0
+	// removes the cap
+	Concurrency map[TaskType]int
+	// Priorities overrides the default queue priority per type
+	Priorities map[TaskType]Priority
+
+	// CallbackSecret keys the signature of completion webhooks, they are
+	// sent unsigned without one
+	CallbackSecret string
+	// CallbackHosts allow-lists the hosts completion webhooks go to, see
+	// CheckCallbackHost; empty allows every host
+	CallbackHosts []string
+	// HTTPClient delivers completion webhooks, nil uses a 30s timeout client
+	// that follows redirects to allowed hosts only
+	HTTPClient *http.Client
+
+	// Sandbox confines the paths of payloads, its first root must be the
+	// store dir; nil trusts the paths as given
+	Sandbox *sandbox.Sandbox
+
 	FF      *cmd.FFmpeg
-	Runner  *cmd.Runner
+	Runner  CommandRunner
 	Whisper *cmd.Whisper
 
-	FS       filestore.FileStore
-	LLM      *llm.GeminiClient
-	TTS      tts.Client
+	FS  filestore.FileStore
+	LLM ScriptWriter
+	// TTS holds the providers, a project or payload names the one it uses
+	TTS      *tts.Registry
 	Renderer *prompts.Renderer
 }
 
+// ScriptWriter splits raw text into narration segments, *llm.GeminiClient
+// is the production one
+type ScriptWriter interface {
+	GenScript(ctx context.Context, model, content, prompt string) ([]string, error)
+}
+
+// CommandRunner runs the ffmpeg and whisper commands of the handlers,
+// *cmd.Runner is the production one
+type CommandRunner interface {
+	Run(ctx context.Context, c *cmd.Cmd) error
+}
+
 // Worker defines interface for excutor
 type Worker interface {
 	Start()
+	// Shutdown drains the worker without a deadline
 	Shutdown()
-	Submit(context.Context, TaskType, any) (string, error)
+	// Drain stops accepting submissions and waits for the running tasks, the
+	// queued ones stay in the journal; once ctx is done the running tasks are
+	// interrupted and requeued, and ctx.Err() is returned. A task that fails
+	// with an error of its own meanwhile is not requeued
+	Drain(ctx context.Context) error
+	// Submit enqueues a task, a submission duplicating a pending one returns
+	// the ID of that task with ErrDuplicate
+	Submit(context.Context, TaskType, any, ...SubmitOption) (string, error)
+	// SubmitJob submits a graph of tasks whose payloads may reference the
+	// outputs of their dependencies as ${name.outputs[N]}, it returns the
+	// task ID of every name
+	SubmitJob(ctx context.Context, tasks []JobTask) (map[string]string, error)
+
+	// Get returns the status of task id
+	Get(id string) (TaskStatus, bool)
+	// List returns the status of every task matching f in submission order
+	List(f TaskFilter) []TaskStatus
+	// Cancel stops a queued or running task and kills its child process
+	Cancel(id string) error
+	// Resume re-enqueues an interrupted task
+	Resume(id string) error
+
+	// DeadLetters returns the tasks that failed for good
+	DeadLetters() []TaskStatus
+	// Resubmit enqueues a dead letter again as a new task
+	Resubmit(ctx context.Context, id string) (string, error)
+	// Discard drops a task from the dead letter list
+	Discard(id string) error
+
+	// Subscribe streams the events of task id, or of every task when id is
+	// empty, until the returned func is called or the worker shuts down
+	Subscribe(id string) (<-chan Event, func())
 }
 
 type worker struct {
 	fs       filestore.FileStore
 	ff       *cmd.FFmpeg
-	llm      *llm.GeminiClient
-	tts      tts.Client
+	llm      ScriptWriter
+	tts      *tts.Registry
 	renderer *prompts.Renderer
-	runner   *cmd.Runner
+	runner   CommandRunner
 	whisper  *cmd.Whisper
 
 	workerCount   int
 	queueCapacity int
+	policies      map[TaskType]RetryPolicy
+	handlers      map[TaskType]HandlerFunc
+	priorities    map[TaskType]Priority
+
+	callbackSecret []byte
+	callbackHosts  []string
+	httpClient     *http.Client
 
-	wg        sync.WaitGroup
+	sandbox *sandbox.Sandbox
+
+	wg sync.WaitGroup
+	// bg tracks callback deliveries and the compaction of the registry
+	bg        sync.WaitGroup
 	startOnce sync.Once
 	stopOnce  sync.Once
 
-	mu     sync.RWMutex
-	closed bool
+	// ctx bounds the lifetime of every task, cancelled on Shutdown with
+	// errDrained as the cause when the running tasks are interrupted
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	queue  *queue
+	reg    *registry
+	builds builds
 
-	queue chan *Task
+	// restored holds journal tasks to enqueue once Start creates the queue
+	restored []*Task
 }
 
-func New(conf Config) Worker {
+func New(conf Config) (Worker, error) {
 	wc := conf.WorkerCount
 	if wc <= 0 {
 		wc = defaultWorkerCount
@@ -72,7 +181,10 @@ func New(conf Config) Worker {
 	if qc <= 0 {
 		qc = defaultQueueCapacity
 	}
-	return &worker{
+	ctx, cancel := context.WithCancelCause(context.Background())
+	w := &worker{
+		ctx:           ctx,
+		cancel:        cancel,
 		fs:            conf.FS,
 		runner:        conf.Runner,
 		ff:            conf.FF,
@@ -82,7 +194,99 @@ func New(conf Config) Worker {
 		whisper:       conf.Whisper,
 		workerCount:   wc,
 		queueCapacity: qc,
+		policies:      maps.Clone(defaultRetryPolicies),
+		handlers:      conf.Handlers,
+		priorities:    maps.Clone(defaultPriorities),
+
+		callbackSecret: []byte(conf.CallbackSecret),
+		callbackHosts:  conf.CallbackHosts,
+		httpClient:     conf.HTTPClient,
+
+		sandbox: conf.Sandbox,
+	}
+	if w.httpClient == nil {
+		w.httpClient = &http.Client{
+			Timeout: 30 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				return CheckCallbackHost(conf.CallbackHosts, req.URL.String())
+			},
+		}
+	}
+	maps.Copy(w.policies, conf.RetryPolicies)
+	maps.Copy(w.priorities, conf.Priorities)
+
+	limits := maps.Clone(defaultConcurrency)
+	maps.Copy(limits, conf.Concurrency)
+	w.queue = newQueue(qc, limits)
+
+	if conf.StateDir == "" {
+		w.reg = newRegistry(nil)
+		return w, nil
+	}
+
+	j, recs, err := openJournal(conf.StateDir)
+	if err != nil {
+		cancel(nil)
+		return nil, fmt.Errorf("open task journal: %w", err)
 	}
+	w.reg = newRegistry(j)
+	w.restore(recs, conf.ResumeInterrupted)
+	return w, nil
+}
+
+// restore loads unfinished tasks from the journal, tasks that were running
+// are marked interrupted unless resume is set
+func (w *worker) restore(recs []record, resume bool) {
+	var queued, interrupted int
+	for _, rec := range recs {
+		st := rec.Status
+		if st.State.Done() {
+			w.reg.restore(rec, nil)
+			continue
+		}
+
+		task := w.newTask(st.ID, st.Type, rec.Payload)
+		task.Priority = st.Priority
+		w.reg.restore(rec, task)
+
+		switch st.State {
+		case TaskQueued:
+			queued++
+			w.restored = append(w.restored, task)
+		case TaskWaiting:
+			// re-checked on Start, the dependencies may have finished meanwhile
+		case TaskRunning, TaskInterrupted:
+			interrupted++
+			if resume {
+				w.reg.markQueued(st.ID)
+				w.restored = append(w.restored, task)
+				continue
+			}
+			w.reg.markInterrupted(st.ID)
+		}
+	}
+
+	slog.Info("task journal restored",
+		"tasks", len(recs),
+		"queued", queued,
+		"interrupted", interrupted,
+		"resume", resume,
+	)
+}
+
+func (w *worker) newTask(id string, typ TaskType, payload json.RawMessage) *Task {
+	task := &Task{
+		ID:      id,
+		Type:    typ,
+		Payload: payload,
+
+		Priority: w.priorities[typ],
+	}
+	task.ctx, task.cancel = context.WithCancel(w.ctx)
+	return task
 }
 
 func (w *worker) Start() {
@@ -92,60 +296,313 @@ func (w *worker) Start() {
 			"workers", w.workerCount,
 			"queueCapacity", w.queueCapacity,
 		)
-		w.queue = make(chan *Task, w.queueCapacity)
-
 		for i := 0; i < w.workerCount; i++ {
 			w.wg.Go(func() {
 				w.loop()
 			})
 		}
+		w.bg.Go(func() {
+			w.compactLoop()
+		})
+
+		// the backlog may exceed the queue capacity, don't block Start on it
+		restored := w.restored
+		w.restored = nil
+		go w.enqueue(restored...)
+
+		for _, st := range w.reg.list(TaskFilter{}) {
+			switch {
+			case st.State == TaskWaiting:
+				w.release(st.ID)
+			case st.State.Done():
+				// callbacks cut short by the last shutdown
+				w.notify(st.ID)
+			}
+		}
 	})
 }
 
+// compactLoop forgets the tasks that finished longer than journalRetention
+// ago, which bounds the registry and the journal of a long running server
+func (w *worker) compactLoop() {
+	t := time.NewTicker(compactInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-t.C:
+			if n := w.reg.prune(time.Now().Add(-journalRetention)); n > 0 {
+				slog.Info("pruned finished tasks", "count", n)
+			}
+		}
+	}
+}
+
+// enqueue pushes tasks that are already registered onto the queue, tasks
+// left over by a shutdown stay queued in the journal
+func (w *worker) enqueue(tasks ...*Task) {
+	for _, task := range tasks {
+		if ok, _ := w.queue.push(w.ctx, task); !ok {
+			return
+		}
+	}
+}
+
 func (w *worker) Shutdown() {
+	_ = w.Drain(context.Background())
+}
+
+func (w *worker) Drain(ctx context.Context) error {
+	var err error
 	w.stopOnce.Do(func() {
-		slog.Info("worker shutting down")
+		slog.Info("worker draining")
 
-		w.mu.Lock()
-		w.closed = true
-		if w.queue != nil {
-			close(w.queue)
+		w.queue.close()
+
+		done := make(chan struct{})
+		go func() {
+			w.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+			slog.Warn("drain deadline reached, requeueing running tasks")
+			w.cancel(errDrained)
+			<-done
 		}
-		w.mu.Unlock()
 
-		w.wg.Wait()
+		// stops callback deliveries, they resume on the next start
+		w.cancel(nil)
+		w.bg.Wait()
+		w.reg.events.close()
+		if w.reg.journal != nil {
+			_ = w.reg.journal.close()
+		}
 		slog.Info("worker stopped")
 	})
+	return err
 }
 
-func (w *worker) Submit(ctx context.Context, typ TaskType, payload any) (string, error) {
+func (w *worker) Submit(ctx context.Context, typ TaskType, payload any, opts ...SubmitOption) (string, error) {
 	w.Start()
 
+	var o submitOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	b, err := json.Marshal(payload)
 	if err != nil {
 		return "", err
 	}
-	taskID := fmt.Sprintf("task_%d", time.Now().UnixNano())
+	task := w.newTask(newTaskID(), typ, b)
+	if o.priority != nil {
+		task.Priority = *o.priority
+	}
+	task.callback = o.callback
+	task.idempotencyKey = o.idempotencyKey
+	task.payloadHash = payloadHash(typ, b)
+	if err := w.submitWith(ctx, task, o); err != nil {
+		var dup *duplicateError
+		if errors.As(err, &dup) {
+			slog.Info("duplicate submission",
+				"task_id", dup.id,
+				"task_type", typ,
+			)
+			return dup.id, err
+		}
+		return "", err
+	}
+	return task.ID, nil
+}
 
-	task := &Task{
-		ID:      taskID,
-		Type:    typ,
-		Payload: b,
+var lastTaskID atomic.Int64
+
+// newTaskID derives the id from the clock, bumped when tasks of a job are
+// created within the same nanosecond
+func newTaskID() string {
+	for {
+		last := lastTaskID.Load()
+		n := max(time.Now().UnixNano(), last+1)
+		if lastTaskID.CompareAndSwap(last, n) {
+			return fmt.Sprintf("task_%d", n)
+		}
+	}
+}
+
+// submitWith queues the task right away or holds it until its dependencies
+// succeeded
+func (w *worker) submitWith(ctx context.Context, task *Task, o submitOptions) error {
+	if w.queue.stopped() {
+		return ErrWorkerStopped
+	}
+	if len(o.dependsOn) == 0 {
+		return w.submit(ctx, task)
 	}
-	w.mu.RLock()
-	defer w.mu.RUnlock()
 
-	select {
-	case w.queue <- task:
-		return taskID, nil
-	case <-ctx.Done():
-		return "", ctx.Err()
+	for _, dep := range o.dependsOn {
+		if dep == task.ID {
+			return fmt.Errorf("task depends on itself")
+		}
+		if _, ok := w.reg.get(dep); !ok {
+			return fmt.Errorf("dependency %s: %w", dep, ErrTaskNotFound)
+		}
+	}
+
+	if dup := w.reg.addWaiting(task, o.dependsOn, o.refs); dup != "" {
+		task.cancel()
+		return &duplicateError{id: dup}
+	}
+	w.release(task.ID)
+	return nil
+}
+
+func (w *worker) submit(ctx context.Context, task *Task) error {
+	if dup := w.reg.add(task); dup != "" {
+		task.cancel()
+		return &duplicateError{id: dup}
+	}
+
+	ok, err := w.queue.push(ctx, task)
+	if ok {
+		return nil
+	}
+	if err == nil {
+		err = ErrWorkerStopped
+	}
+	task.cancel()
+	w.reg.remove(task.ID)
+	return err
+}
+
+func (w *worker) Get(id string) (TaskStatus, bool) {
+	return w.reg.get(id)
+}
+
+func (w *worker) List(f TaskFilter) []TaskStatus {
+	return w.reg.list(f)
+}
+
+func (w *worker) Cancel(id string) error {
+	task, ok := w.reg.live(id)
+	if !ok {
+		if _, ok := w.reg.get(id); ok {
+			return ErrTaskFinished
+		}
+		return ErrTaskNotFound
+	}
+
+	slog.Info("cancel task",
+		"task_id", task.ID,
+		"task_type", task.Type,
+	)
+	task.cancel()
+	w.reg.markCancelled(id)
+	w.finished(id)
+	return nil
+}
+
+func (w *worker) Resume(id string) error {
+	st, ok := w.reg.get(id)
+	if !ok {
+		return ErrTaskNotFound
+	}
+	if st.State != TaskInterrupted {
+		return ErrTaskNotResumable
+	}
+	if w.queue.stopped() {
+		return ErrWorkerStopped
 	}
+	task, ok := w.reg.live(id)
+	if !ok {
+		return ErrTaskNotResumable
+	}
+
+	slog.Info("resume task",
+		"task_id", task.ID,
+		"task_type", task.Type,
+	)
+	w.Start()
+	w.reg.markQueued(id)
+	go w.enqueue(task)
+	return nil
+}
+
+func (w *worker) DeadLetters() []TaskStatus {
+	return w.reg.list(TaskFilter{DeadLetter: true})
+}
+
+func (w *worker) Resubmit(ctx context.Context, id string) (string, error) {
+	w.Start()
+
+	newID := newTaskID()
+	st, payload, err := w.reg.takeDeadLetter(id, newID)
+	if err != nil {
+		return "", err
+	}
+
+	task := w.newTask(newID, st.Type, payload)
+	if st.Callback != nil {
+		task.callback = st.Callback.URL
+	}
+	if err := w.submit(ctx, task); err != nil {
+		w.reg.markDeadLetter(id)
+		return "", err
+	}
+
+	slog.Info("resubmit dead letter",
+		"task_id", id,
+		"new_task_id", newID,
+		"task_type", st.Type,
+	)
+	return newID, nil
+}
+
+func (w *worker) Subscribe(id string) (<-chan Event, func()) {
+	return w.reg.events.subscribe(id)
+}
+
+func (w *worker) Discard(id string) error {
+	_, _, err := w.reg.takeDeadLetter(id, "")
+	return err
 }
 
 func (w *worker) loop() {
-	for task := range w.queue {
-		if err := w.runOneSafe(task); err != nil {
+	for {
+		task, ok := w.queue.pop()
+		if !ok {
+			return
+		}
+		if task.ctx.Err() != nil {
+			// cancelled while queued
+			w.queue.done(task)
+			w.reg.markDone(task.ID, task.ctx.Err())
+			w.finished(task.ID)
+			continue
+		}
+
+		w.reg.markRunning(task.ID)
+		err := w.runWithRetry(task)
+		task.cancel()
+		w.queue.done(task)
+
+		if interruptedByDrain(task, err) && w.reg.requeue(task.ID) {
+			slog.Warn("task interrupted by drain, requeued",
+				"task_id", task.ID,
+				"task_type", task.Type,
+			)
+			continue
+		}
+		if err == nil {
+			// before markDone so the manifest is complete once the task is
+			w.recordArtifacts(task.ID)
+		}
+		w.reg.markDone(task.ID, err)
+		w.finished(task.ID)
+		if err != nil {
 			slog.Error("run task failed",
 				"task_id", task.ID,
 				"task_type", task.Type,
@@ -155,6 +612,45 @@ func (w *worker) loop() {
 	}
 }
 
+// interruptedByDrain reports whether err is the cancellation Drain sent to
+// a running task, a task that failed on its own goes the usual way
+func interruptedByDrain(task *Task, err error) bool {
+	return errors.Is(err, context.Canceled) && errors.Is(context.Cause(task.ctx), errDrained)
+}
+
+// runWithRetry runs task until it succeeds, fails with an error the policy
+// of its type does not retry, or runs out of attempts
+func (w *worker) runWithRetry(task *Task) error {
+	policy := w.policies[task.Type]
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		w.reg.markAttempt(task.ID, err)
+
+		err = w.runOneSafe(task)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		slog.Warn("task failed, retrying",
+			"task_id", task.ID,
+			"task_type", task.Type,
+			"attempt", attempt,
+			"delay", delay,
+			"err", err,
+		)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-task.ctx.Done():
+			t.Stop()
+			return task.ctx.Err()
+		}
+	}
+}
+
 func (w *worker) runOneSafe(task *Task) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -166,31 +662,59 @@ func (w *worker) runOneSafe(task *Task) (err error) {
 			)
 		}
 	}()
-	return w.runOne(task)
+	return w.runOne(task.ctx, task)
 }
 
-func (w *worker) runOne(task *Task) error {
+func (w *worker) runOne(ctx context.Context, task *Task) error {
 	fmt.Println(task.Type)
 	switch task.Type {
 	case GenScript:
-		return w.handleScriptGen(task)
+		return w.handleScriptGen(ctx, task)
 	case GenTTSAll:
-		return w.handleTTSAll(task)
+		return w.handleTTSAll(ctx, task)
 	case GenTTSSingle:
-		return w.handleTTSSingle(task)
+		return w.handleTTSSingle(ctx, task)
 	case Mixdown:
-		return w.handleMixdown(task)
+		return w.handleMixdown(ctx, task)
 	case Concat:
-		return w.handleConcat(task)
+		return w.handleConcat(ctx, task)
 	case Render:
-		return w.handleRender(task)
+		return w.handleRender(ctx, task)
 	case Merge:
-		return w.handleMerge(task)
+		return w.handleMerge(ctx, task)
 	case GenSrt:
-		return w.handleGensubtitle(task)
+		return w.handleGensubtitle(ctx, task)
 	case Brun:
-		return w.handleBrunSubtitle(task)
+		return w.handleBrunSubtitle(ctx, task)
+	case EpisodeBuild:
+		return w.handleEpisode(ctx, task)
 	default:
+		if h, ok := w.handlers[task.Type]; ok {
+			return w.runHandler(ctx, task, h)
+		}
 		return fmt.Errorf("unknown task type: %v", task.Type)
 	}
 }
+
+func (w *worker) runHandler(ctx context.Context, task *Task, h HandlerFunc) error {
+	out, err := h(ctx, task.Payload)
+	if err != nil {
+		return err
+	}
+	switch v := out.(type) {
+	case string:
+		w.reg.addOutputs(task.ID, v)
+	case []string:
+		w.reg.addOutputs(task.ID, v...)
+	}
+	return nil
+}
+
+// knownType reports whether the worker has a handler for typ
+func (w *worker) knownType(typ TaskType) bool {
+	if typ.Valid() {
+		return true
+	}
+	_, ok := w.handlers[typ]
+	return ok
+}
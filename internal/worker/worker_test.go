@@ -1,8 +1,1247 @@
 package worker
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"comp0ser/internal/cmd"
+	"comp0ser/internal/filestore"
+	"comp0ser/internal/tts"
+	"comp0ser/prompts"
 )
 
 func TestWorker_Command_BrunSubtitle(t *testing.T) {
 }
+
+func TestRegistry_Lifecycle(t *testing.T) {
+	r := newRegistry(nil)
+	r.add(&Task{ID: "a", Type: Render})
+	r.add(&Task{ID: "b", Type: Concat})
+
+	r.markRunning("a")
+	r.addOutputs("a", "/store/x/out.mp4")
+	r.markDone("a", nil)
+	r.markDone("b", errors.New("boom"))
+
+	a, ok := r.get("a")
+	if !ok || a.State != TaskSucceeded || a.StartedAt == nil || a.FinishedAt == nil {
+		t.Fatalf("unexpected status: %+v", a)
+	}
+	if len(a.Outputs) != 1 || a.Outputs[0] != "/store/x/out.mp4" {
+		t.Fatalf("unexpected outputs: %v", a.Outputs)
+	}
+
+	failed := r.list(TaskFilter{State: TaskFailed})
+	if len(failed) != 1 || failed[0].ID != "b" || failed[0].Error != "boom" {
+		t.Fatalf("unexpected failed list: %+v", failed)
+	}
+	if got := r.list(TaskFilter{Type: Render}); len(got) != 1 || got[0].ID != "a" {
+		t.Fatalf("unexpected render list: %+v", got)
+	}
+	if _, ok := r.get("missing"); ok {
+		t.Fatal("expected missing task")
+	}
+}
+
+func TestRegistry_Cancel(t *testing.T) {
+	r := newRegistry(nil)
+	r.add(&Task{ID: "queued"})
+	r.add(&Task{ID: "running"})
+	r.markRunning("running")
+
+	r.markCancelled("queued")
+	r.markCancelled("running")
+
+	if s, _ := r.get("queued"); s.State != TaskCancelled {
+		t.Fatalf("queued task should be cancelled right away, got %s", s.State)
+	}
+	if _, ok := r.live("queued"); ok {
+		t.Fatal("cancelled task should not be live")
+	}
+	if s, _ := r.get("running"); s.State != TaskRunning {
+		t.Fatalf("running task should keep running until the handler returns, got %s", s.State)
+	}
+
+	r.markDone("running", context.Canceled)
+	if s, _ := r.get("running"); s.State != TaskCancelled || s.Error != "" {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+}
+
+func TestJournal_Restore(t *testing.T) {
+	dir := t.TempDir()
+
+	j, recs, err := openJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 0 {
+		t.Fatalf("expected empty journal, got %d records", len(recs))
+	}
+
+	r := newRegistry(j)
+	r.add(&Task{ID: "queued", Type: Render, Payload: []byte(`{"dur":60}`)})
+	r.add(&Task{ID: "running", Type: Concat})
+	r.add(&Task{ID: "done", Type: Merge})
+	r.add(&Task{ID: "aborted", Type: Merge})
+	r.markRunning("running")
+	r.markRunning("done")
+	r.markDone("done", nil)
+	r.remove("aborted")
+	if err := j.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wk, err := New(Config{StateDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := wk.(*worker)
+
+	want := map[string]TaskState{
+		"queued":  TaskQueued,
+		"running": TaskInterrupted,
+		"done":    TaskSucceeded,
+	}
+	for id, state := range want {
+		st, ok := w.Get(id)
+		if !ok || st.State != state {
+			t.Fatalf("task %s: want %s, got %+v", id, state, st)
+		}
+	}
+	if _, ok := w.Get("aborted"); ok {
+		t.Fatal("removed task should not be restored")
+	}
+	if len(w.restored) != 1 || string(w.restored[0].Payload) != `{"dur":60}` {
+		t.Fatalf("unexpected restored queue: %+v", w.restored)
+	}
+}
+
+func TestRegistry_Prune(t *testing.T) {
+	dir := t.TempDir()
+	j, _, err := openJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRegistry(j)
+	r.add(&Task{ID: "old", Type: Render, payloadHash: "h1"})
+	r.add(&Task{ID: "recent", Type: Render, payloadHash: "h2"})
+	r.add(&Task{ID: "queued", Type: Render, payloadHash: "h3"})
+	r.markDone("old", nil)
+	r.markDone("recent", nil)
+	r.update("old", func(e *entry) {
+		finished := time.Now().Add(-2 * journalRetention)
+		e.status.FinishedAt = &finished
+	})
+
+	if n := r.prune(time.Now().Add(-journalRetention)); n != 1 {
+		t.Fatalf("pruned %d tasks, want 1", n)
+	}
+	if _, ok := r.get("old"); ok || len(r.byHash["h1"]) != 0 {
+		t.Fatal("old task should be pruned")
+	}
+	if got := r.list(TaskFilter{}); len(got) != 2 || got[0].ID != "recent" || got[1].ID != "queued" {
+		t.Fatalf("unexpected list: %+v", got)
+	}
+	if dup := r.add(&Task{ID: "again", Type: Render, payloadHash: "h3"}); dup != "queued" {
+		t.Fatalf("want a duplicate of queued, got %q", dup)
+	}
+
+	lines := func() int {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(dir, journalFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(b), "\n")
+	}
+
+	// the journal holds one line per task left and appends go on after it
+	r.markRunning("queued")
+	if n := lines(); n != 3 {
+		t.Fatalf("journal has %d lines, want 3", n)
+	}
+	// nothing to drop leaves the journal alone, until it grew too long
+	if r.prune(time.Now().Add(-journalRetention)); lines() != 3 {
+		t.Fatalf("journal rewritten without anything pruned")
+	}
+	j.lines += compactLines
+	if r.prune(time.Now().Add(-journalRetention)); lines() != 2 {
+		t.Fatalf("overgrown journal has %d lines, want 2", lines())
+	}
+	if err := j.close(); err != nil {
+		t.Fatal(err)
+	}
+	_, recs, err := openJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[1].Status.State != TaskRunning {
+		t.Fatalf("unexpected records: %+v", recs)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"tts 429", &tts.StatusError{StatusCode: 429}, true},
+		{"tts 503", &tts.StatusError{StatusCode: 503}, true},
+		{"tts bad request", &tts.StatusError{StatusCode: 400}, false},
+		{"tts busy", &tts.StatusError{StatusCode: 200, Code: 3005}, true},
+		{"tts bad text", &tts.StatusError{StatusCode: 200, Code: 3011}, false},
+		{"bad payload", json.Unmarshal([]byte("{"), &GenTTSPayLoad{}), false},
+		{"permanent", Permanent(errors.New("empty folder")), false},
+		{"wrapped", fmt.Errorf("tts failed: %w", &tts.StatusError{StatusCode: 502}), true},
+		{"unknown", errors.New("connection reset"), true},
+	}
+	for _, tc := range cases {
+		if got := IsRetryable(tc.err); got != tc.want {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, max := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		10: time.Second,
+	} {
+		for range 20 {
+			d := p.backoff(attempt)
+			if d < max/2 || d > max {
+				t.Fatalf("attempt %d: delay %v out of [%v, %v]", attempt, d, max/2, max)
+			}
+		}
+	}
+}
+
+func TestWorker_DeadLetter(t *testing.T) {
+	const flaky TaskType = "test.flaky"
+
+	wk, err := New(Config{
+		WorkerCount: 1,
+		RetryPolicies: map[TaskType]RetryPolicy{
+			flaky: {MaxAttempts: 3, BaseDelay: time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wk.Shutdown()
+
+	id, err := wk.Submit(context.Background(), flaky, map[string]any{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := waitDone(t, wk, id)
+	if st.State != TaskFailed || st.Attempts != 3 || !st.DeadLetter {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if dl := wk.DeadLetters(); len(dl) != 1 || dl[0].ID != id {
+		t.Fatalf("unexpected dead letters: %+v", dl)
+	}
+
+	newID, err := wk.Resubmit(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old, _ := wk.Get(id); old.DeadLetter || old.ResubmittedAs != newID {
+		t.Fatalf("unexpected resubmitted status: %+v", old)
+	}
+	if _, err := wk.Resubmit(context.Background(), id); !errors.Is(err, ErrNotDeadLetter) {
+		t.Fatalf("expected ErrNotDeadLetter, got %v", err)
+	}
+	waitDone(t, wk, newID)
+	if err := wk.Discard(newID); err != nil {
+		t.Fatal(err)
+	}
+	if dl := wk.DeadLetters(); len(dl) != 0 {
+		t.Fatalf("expected empty dead letters, got %+v", dl)
+	}
+}
+
+func waitDone(t *testing.T, wk Worker, id string) TaskStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if st, ok := wk.Get(id); ok && st.State.Done() {
+			return st
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("task %s did not finish", id)
+	return TaskStatus{}
+}
+
+func TestBuilds_UpToDate(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	out := filepath.Join(dir, "out.wav")
+	for _, p := range []string{in, out} {
+		if err := os.WriteFile(p, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b builds
+	fp := newFingerprint("concat").file(in).param("loop", true).sum()
+	if _, ok := b.upToDate(dir, "concat", fp); ok {
+		t.Fatal("step should not be up to date before it was recorded")
+	}
+	if err := b.record(dir, "concat", fp, []string{out}); err != nil {
+		t.Fatal(err)
+	}
+	if outs, ok := b.upToDate(dir, "concat", fp); !ok || len(outs) != 1 || outs[0] != out {
+		t.Fatalf("expected up to date step, got %v %v", outs, ok)
+	}
+
+	if fp2 := newFingerprint("concat").file(in).param("loop", false).sum(); fp2 == fp {
+		t.Fatal("param change should change the fingerprint")
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(in, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if fp2 := newFingerprint("concat").file(in).param("loop", true).sum(); fp2 == fp {
+		t.Fatal("touching an input should change the fingerprint")
+	}
+
+	if err := os.Remove(out); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.upToDate(dir, "concat", fp); ok {
+		t.Fatal("step with a missing output should not be up to date")
+	}
+}
+
+func TestWorker_SubmitJob(t *testing.T) {
+	wk, err := New(Config{
+		WorkerCount: 2,
+		Handlers: map[TaskType]HandlerFunc{
+			"test.echo": func(ctx context.Context, payload json.RawMessage) (any, error) {
+				var p struct {
+					Out string `json:"out"`
+				}
+				if err := json.Unmarshal(payload, &p); err != nil {
+					return nil, err
+				}
+				return p.Out, nil
+			},
+			"test.fail": func(ctx context.Context, payload json.RawMessage) (any, error) {
+				return nil, Permanent(errors.New("boom"))
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wk.Shutdown()
+
+	ids, err := wk.SubmitJob(context.Background(), []JobTask{
+		{Name: "c", Type: "test.echo", DependsOn: []string{"a", "b"}, Payload: json.RawMessage(`{"out":"${a.outputs[0]}+${b.outputs[0]}"}`)},
+		{Name: "a", Type: "test.echo", Payload: json.RawMessage(`{"out":"a.wav"}`)},
+		{Name: "b", Type: "test.echo", DependsOn: []string{"a"}, Payload: json.RawMessage(`{"out":"${a.outputs[0]}.mp4"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := waitDone(t, wk, ids["c"])
+	if st.State != TaskSucceeded || len(st.Outputs) != 1 || st.Outputs[0] != "a.wav+a.wav.mp4" {
+		t.Fatalf("c = %+v", st)
+	}
+
+	// a failed parent cancels the whole subtree
+	ids, err = wk.SubmitJob(context.Background(), []JobTask{
+		{Name: "root", Type: "test.fail"},
+		{Name: "child", Type: "test.echo", DependsOn: []string{"root"}},
+		{Name: "grandchild", Type: "test.echo", DependsOn: []string{"child"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := waitDone(t, wk, ids["root"]); st.State != TaskFailed {
+		t.Fatalf("root = %+v", st)
+	}
+	for _, name := range []string{"child", "grandchild"} {
+		if st := waitDone(t, wk, ids[name]); st.State != TaskCancelled || st.Error == "" {
+			t.Fatalf("%s = %+v", name, st)
+		}
+	}
+
+	for name, tasks := range map[string][]JobTask{
+		"cycle": {
+			{Name: "a", Type: "test.echo", DependsOn: []string{"b"}},
+			{Name: "b", Type: "test.echo", DependsOn: []string{"a"}},
+		},
+		"unknown dep":  {{Name: "a", Type: "test.echo", DependsOn: []string{"nope"}}},
+		"unknown type": {{Name: "a", Type: "nope"}},
+		"duplicate":    {{Name: "a", Type: "test.echo"}, {Name: "a", Type: "test.echo"}},
+	} {
+		if _, err := wk.SubmitJob(context.Background(), tasks); !errors.Is(err, ErrInvalidJob) {
+			t.Errorf("%s: err = %v, want ErrInvalidJob", name, err)
+		}
+	}
+}
+
+func TestExpandRefs(t *testing.T) {
+	outputs := map[string][]string{"task_1": {"x.wav"}}
+	refs := map[string]string{"tts": "task_1"}
+
+	got, err := expandRefs(json.RawMessage(`{"a":["${tts.outputs[0]}"],"n":1.50}`), refs, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"a":["x.wav"],"n":1.50}` {
+		t.Fatalf("got %s", got)
+	}
+
+	if _, err := expandRefs(json.RawMessage(`{"a":"${tts.outputs[1]}"}`), refs, outputs); err == nil {
+		t.Fatal("out of range reference expanded")
+	}
+	if _, err := expandRefs(json.RawMessage(`{"a":"${other.outputs[0]}"}`), refs, outputs); err == nil {
+		t.Fatal("reference to a non dependency expanded")
+	}
+}
+
+func TestQueue_PriorityAndLimits(t *testing.T) {
+	q := newQueue(10, map[TaskType]int{Render: 1})
+
+	push := func(id string, typ TaskType, p Priority) {
+		if ok, err := q.push(context.Background(), &Task{ID: id, Type: typ, Priority: p}); !ok || err != nil {
+			t.Fatalf("push %s: %v %v", id, ok, err)
+		}
+	}
+	push("render1", Render, PriorityLow)
+	push("render2", Render, PriorityLow)
+	push("concat", Concat, PriorityNormal)
+	push("single", GenTTSSingle, PriorityHigh)
+
+	var got []string
+	for range 3 {
+		task, _ := q.pop()
+		got = append(got, task.ID)
+	}
+	// render2 is held back by the cap while render1 runs
+	if fmt.Sprint(got) != "[single concat render1]" {
+		t.Fatalf("pop order = %v", got)
+	}
+
+	popped := make(chan string)
+	go func() {
+		task, _ := q.pop()
+		popped <- task.ID
+	}()
+	select {
+	case id := <-popped:
+		t.Fatalf("popped %s past the render cap", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.done(&Task{Type: Render})
+	if id := <-popped; id != "render2" {
+		t.Fatalf("popped %s, want render2", id)
+	}
+
+	push("render3", Render, PriorityLow)
+	q.done(&Task{Type: Render})
+	q.close()
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop on a closed queue")
+	}
+	if ok, _ := q.push(context.Background(), &Task{ID: "late"}); ok {
+		t.Fatal("push on a closed queue")
+	}
+}
+
+func TestParseConcurrency(t *testing.T) {
+	got, err := ParseConcurrency("render.mp4=1, tts.all.gen=8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[Render] != 1 || got[GenTTSAll] != 8 {
+		t.Fatalf("got %v", got)
+	}
+	if _, err := ParseConcurrency("render.mp4"); err == nil {
+		t.Fatal("expected an error for a missing cap")
+	}
+}
+
+func TestParsePriorities(t *testing.T) {
+	got, err := ParsePriorities("render.mp4=high, concat.wav=Low,tts.single.gen=normal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[Render] != PriorityHigh || got[Concat] != PriorityLow || got[GenTTSSingle] != PriorityNormal {
+		t.Fatalf("got %v", got)
+	}
+	for _, s := range []string{"render.mp4", "render.mp4=urgent"} {
+		if _, err := ParsePriorities(s); err == nil {
+			t.Fatalf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestWorker_Subscribe(t *testing.T) {
+	wk, err := New(Config{
+		Handlers: map[TaskType]HandlerFunc{
+			"test.echo": func(ctx context.Context, payload json.RawMessage) (any, error) {
+				return "out.wav", nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, unsubscribe := wk.Subscribe("")
+	defer unsubscribe()
+
+	id, err := wk.Submit(context.Background(), "test.echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case e := <-events:
+			if e.TaskID != id {
+				t.Fatalf("event of another task: %+v", e)
+			}
+			got = append(got, fmt.Sprintf("%s:%s%v", e.Type, e.State, e.Outputs))
+			done = e.State == TaskSucceeded
+		case <-timeout:
+			t.Fatalf("no terminal event, got %v", got)
+		}
+	}
+	want := "[state:queued[] state:running[] output:[out.wav] state:succeeded[out.wav]]"
+	if fmt.Sprint(got) != want {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+
+	wk.Shutdown()
+	if _, ok := <-events; ok {
+		t.Fatal("subscription still open after shutdown")
+	}
+}
+
+func TestWorker_Callback(t *testing.T) {
+	saved := callbackPolicy
+	callbackPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	defer func() { callbackPolicy = saved }()
+
+	var (
+		calls atomic.Int32
+		got   = make(chan CallbackBody, 1)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign([]byte("secret"), body) {
+			t.Errorf("bad signature %q", r.Header.Get(SignatureHeader))
+		}
+		if calls.Add(1) == 1 {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var b CallbackBody
+		if err := json.Unmarshal(body, &b); err != nil {
+			t.Error(err)
+		}
+		got <- b
+	}))
+	defer srv.Close()
+
+	wk, err := New(Config{
+		CallbackSecret: "secret",
+		Handlers: map[TaskType]HandlerFunc{
+			"test.echo": func(ctx context.Context, payload json.RawMessage) (any, error) {
+				return "out.mp4", nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wk.Shutdown()
+
+	id, err := wk.Submit(context.Background(), "test.echo", nil, WithCallback(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case b := <-got:
+		if b.TaskID != id || b.State != TaskSucceeded || len(b.Outputs) != 1 || b.Outputs[0] != "out.mp4" {
+			t.Fatalf("unexpected body %+v", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not delivered")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, _ := wk.Get(id)
+		if cb := st.Callback; cb != nil && cb.Delivered {
+			if len(cb.Attempts) != 2 || cb.Attempts[0].StatusCode != http.StatusBadGateway {
+				t.Fatalf("unexpected attempts %+v", cb.Attempts)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery not recorded: %+v", st.Callback)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCheckCallbackHost(t *testing.T) {
+	hosts := ParseCallbackHosts(" hooks.example.com, .example.org ,")
+	for raw, ok := range map[string]bool{
+		"https://hooks.example.com/done":      true,
+		"https://HOOKS.example.com:8443/done": true,
+		"https://example.org/done":            true,
+		"https://a.b.example.org/done":        true,
+		"https://example.com/done":            false,
+		"https://badexample.org/done":         false,
+		"http://127.0.0.1/done":               false,
+		"http://169.254.169.254/latest":       false,
+	} {
+		if err := CheckCallbackHost(hosts, raw); (err == nil) != ok {
+			t.Errorf("%s: got %v, want allowed %v", raw, err, ok)
+		}
+	}
+	if err := CheckCallbackHost(nil, "http://127.0.0.1/done"); err != nil {
+		t.Errorf("an empty list allows every host: %v", err)
+	}
+}
+
+func TestWorker_CallbackHostsAndSecret(t *testing.T) {
+	saved := callbackPolicy
+	callbackPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	defer func() { callbackPolicy = saved }()
+
+	signed := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, ok := r.Header[SignatureHeader]
+		signed <- ok
+	}))
+	defer srv.Close()
+
+	echo := map[TaskType]HandlerFunc{
+		"test.echo": func(ctx context.Context, payload json.RawMessage) (any, error) {
+			return nil, nil
+		},
+	}
+	waitCallback := func(wk Worker, id string) CallbackStatus {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			st, _ := wk.Get(id)
+			if cb := st.Callback; cb != nil && (cb.Delivered || cb.GaveUp) {
+				return *cb
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("callback not finished: %+v", st.Callback)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// without a secret the body goes out unsigned
+	wk, err := New(Config{Handlers: echo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wk.Shutdown()
+	id, err := wk.Submit(context.Background(), "test.echo", nil, WithCallback(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb := waitCallback(wk, id); !cb.Delivered || <-signed {
+		t.Fatalf("want an unsigned delivery, got %+v", cb)
+	}
+
+	// a host off the allow-list is never posted to
+	wk2, err := New(Config{Handlers: echo, CallbackSecret: "secret", CallbackHosts: []string{"hooks.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wk2.Shutdown()
+	id, err = wk2.Submit(context.Background(), "test.echo", nil, WithCallback(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb := waitCallback(wk2, id); !cb.GaveUp || len(cb.Attempts) != 1 || len(signed) != 0 {
+		t.Fatalf("want one refused attempt, got %+v", cb)
+	}
+}
+
+func TestWorker_Idempotency(t *testing.T) {
+	release := make(chan struct{})
+	wk, err := New(Config{
+		Handlers: map[TaskType]HandlerFunc{
+			"test.block": func(ctx context.Context, payload json.RawMessage) (any, error) {
+				<-release
+				return nil, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wk.Shutdown()
+	ctx := context.Background()
+
+	id, err := wk.Submit(ctx, "test.block", map[string]int{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// same payload while pending
+	if dup, err := wk.Submit(ctx, "test.block", map[string]int{"n": 1}); !errors.Is(err, ErrDuplicate) || dup != id {
+		t.Fatalf("got %q %v, want %q ErrDuplicate", dup, err, id)
+	}
+	other, err := wk.Submit(ctx, "test.block", map[string]int{"n": 2})
+	if err != nil || other == id {
+		t.Fatalf("different payload: %q %v", other, err)
+	}
+
+	keyed, err := wk.Submit(ctx, "test.block", map[string]int{"n": 3}, WithIdempotencyKey("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	for _, id := range []string{id, other, keyed} {
+		waitDone(t, wk, id)
+	}
+
+	// finished, the payload may run again but the key still answers
+	if _, err := wk.Submit(ctx, "test.block", map[string]int{"n": 1}); err != nil {
+		t.Fatalf("rerun after finish: %v", err)
+	}
+	if dup, err := wk.Submit(ctx, "test.block", map[string]int{"n": 4}, WithIdempotencyKey("k1")); !errors.Is(err, ErrDuplicate) || dup != keyed {
+		t.Fatalf("got %q %v, want %q ErrDuplicate", dup, err, keyed)
+	}
+}
+
+func TestWorker_Drain(t *testing.T) {
+	dir := t.TempDir()
+	started := make(chan struct{}, 2)
+	wk, err := New(Config{
+		StateDir:    dir,
+		Concurrency: map[TaskType]int{"test.block": 1},
+		Handlers: map[TaskType]HandlerFunc{
+			"test.block": func(ctx context.Context, payload json.RawMessage) (any, error) {
+				started <- struct{}{}
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			// fails on its own while the drain interrupts it
+			"test.broken": func(ctx context.Context, payload json.RawMessage) (any, error) {
+				started <- struct{}{}
+				<-ctx.Done()
+				return nil, Permanent(errors.New("disk full"))
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wk.Start()
+	bg := context.Background()
+
+	running, err := wk.Submit(bg, "test.block", map[string]int{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := wk.Submit(bg, "test.block", map[string]int{"n": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken, err := wk.Submit(bg, "test.broken", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	<-started
+
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	if err := wk.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("drain: got %v, want deadline exceeded", err)
+	}
+	if _, err := wk.Submit(bg, "test.block", map[string]int{"n": 3}); !errors.Is(err, ErrWorkerStopped) {
+		t.Fatalf("submit after drain: got %v, want ErrWorkerStopped", err)
+	}
+
+	// both come back queued on the next start
+	wk, err = New(Config{StateDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wk.Shutdown()
+	for _, id := range []string{running, queued} {
+		st, ok := wk.Get(id)
+		if !ok || st.State != TaskQueued || st.StartedAt != nil {
+			t.Fatalf("task %s: want queued, got %+v", id, st)
+		}
+	}
+	// a real failure is not retried by the next start
+	if st, _ := wk.Get(broken); st.State != TaskFailed || !st.DeadLetter {
+		t.Fatalf("broken task: want a dead letter, got %+v", st)
+	}
+}
+
+// editingTTS edits the narration it synthesizes the first time, like a PATCH
+// arriving while the provider works
+type editingTTS struct {
+	fs    filestore.FileStore
+	texts []string
+}
+
+func (c *editingTTS) Synthesize(ctx context.Context, content, voice string) ([]byte, error) {
+	c.texts = append(c.texts, content)
+	if len(c.texts) == 1 {
+		nars, _ := c.fs.List("ep1")
+		_, err := c.fs.Update("ep1", nars[0].ID, func(nar *filestore.Narration) error {
+			nar.Text = "fixed"
+			nar.Invalidate()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []byte("RIFF" + content), nil
+}
+
+func TestWorker_TTSEditedMeanwhile(t *testing.T) {
+	fs := filestore.NewFileLocalStore(t.TempDir())
+	if _, err := fs.CreateProject(filestore.Project{Name: "ep1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Append("ep1", filestore.Narration{Text: "typo"}); err != nil {
+		t.Fatal(err)
+	}
+	client := &editingTTS{fs: fs}
+	voices := tts.NewRegistry("stub")
+	voices.Register("stub", client)
+
+	wk, err := New(Config{
+		FS:  fs,
+		TTS: voices,
+		RetryPolicies: map[TaskType]RetryPolicy{
+			GenTTSAll: {MaxAttempts: 2, BaseDelay: time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wk.Start()
+	defer wk.Shutdown()
+
+	id, err := wk.Submit(context.Background(), GenTTSAll, GenTTSPayLoad{Folder: "ep1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := waitDone(t, wk, id); st.State != TaskSucceeded || st.Attempts != 2 {
+		t.Fatalf("tts: %+v", st)
+	}
+	nars, err := fs.List("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the audio of "typo" never lands on the edited line, the retry
+	// synthesizes the new text
+	if !slices.Equal(client.texts, []string{"typo", "fixed"}) || nars[0].Status != filestore.NarrationSynthesized {
+		t.Fatalf("synthesized %q, narration %+v", client.texts, nars[0])
+	}
+}
+
+// voiceTTS records the voice of every call
+type voiceTTS struct {
+	voices []string
+}
+
+func (c *voiceTTS) Synthesize(ctx context.Context, content, voice string) ([]byte, error) {
+	c.voices = append(c.voices, content+":"+voice)
+	return []byte("RIFF" + content), nil
+}
+
+func TestWorker_TTSVoice(t *testing.T) {
+	fs := filestore.NewFileLocalStore(t.TempDir())
+	if _, err := fs.CreateProject(filestore.Project{Name: "ep1", Voice: "nova"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, nar := range []filestore.Narration{{Text: "one"}, {Text: "two", Voice: "amy"}} {
+		if _, err := fs.Append("ep1", nar); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &voiceTTS{}
+	voices := tts.NewRegistry("stub")
+	voices.Register("stub", client)
+
+	wk, err := New(Config{FS: fs, TTS: voices})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wk.Start()
+	defer wk.Shutdown()
+
+	run := func() {
+		t.Helper()
+		id, err := wk.Submit(context.Background(), GenTTSAll, GenTTSPayLoad{Folder: "ep1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st := waitDone(t, wk, id); st.State != TaskSucceeded {
+			t.Fatalf("tts: %+v", st)
+		}
+	}
+	run()
+	if !slices.Equal(client.voices, []string{"one:nova", "two:amy"}) {
+		t.Fatalf("voices %q", client.voices)
+	}
+
+	// a new project voice only redoes the lines that speak with it
+	if _, err := fs.UpdateProject("ep1", func(p *filestore.Project) error {
+		p.Voice = "onyx"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	client.voices = nil
+	run()
+	if !slices.Equal(client.voices, []string{"one:onyx"}) {
+		t.Fatalf("voices after the project changed %q", client.voices)
+	}
+}
+
+func TestListMP4Files(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.mp4", "a.MP4", ".upload-1.mp4", "c.mov"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := listMP4Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "a.MP4"), filepath.Join(dir, "b.mp4")}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestPayload_LegacyKeys(t *testing.T) {
+	// journals and schedules written before the tags were fixed still decode
+	var r RenderPayLoad
+	if err := json.Unmarshal([]byte(`{"Folder": "ep1", "dur": 60}`), &r); err != nil || r.Folder != "ep1" {
+		t.Fatalf("render payload: %+v %v", r, err)
+	}
+	var m MixdownPayLoad
+	if err := json.Unmarshal([]byte(`{"BGMPath": "/store/ep1/bgm.mp3"}`), &m); err != nil || m.BGMPath != "/store/ep1/bgm.mp3" {
+		t.Fatalf("mixdown payload: %+v %v", m, err)
+	}
+}
+
+// stubScript answers GenScript with the raw text split on blank lines
+type stubScript struct{ calls atomic.Int32 }
+
+func (s *stubScript) GenScript(ctx context.Context, model, content, prompt string) ([]string, error) {
+	s.calls.Add(1)
+	return strings.Split(content, "\n\n"), nil
+}
+
+func TestWorker_GenScriptTwice(t *testing.T) {
+	renderer, err := prompts.NewRenderer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := filestore.NewFileLocalStore(t.TempDir())
+	llm := &stubScript{}
+	wk, err := New(Config{FS: fs, LLM: llm, Renderer: renderer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wk.Start()
+	defer wk.Shutdown()
+
+	// a second /gen on the same subject replaces the first script
+	for _, raw := range []string{"one\n\ntwo\n\nthree", "four\n\nfive"} {
+		id, err := wk.Submit(context.Background(), GenScript, GenScriptPayLoad{Subject: "ep1", RawText: raw, Segments: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st := waitDone(t, wk, id); st.State != TaskSucceeded {
+			t.Fatalf("gen %q: %+v", raw, st)
+		}
+	}
+	nars, err := fs.List("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nars) != 2 || nars[0].Text != "four" || nars[1].Text != "five" || llm.calls.Load() != 2 {
+		t.Fatalf("narrations %+v", nars)
+	}
+}
+
+// stubRunner writes the outputs of every command instead of running it and
+// fails the one producing fail
+type stubRunner struct {
+	mu   sync.Mutex
+	ran  []string // base name of the first output of every command
+	fail string
+}
+
+func (r *stubRunner) Run(ctx context.Context, c *cmd.Cmd) error {
+	r.mu.Lock()
+	r.ran = append(r.ran, filepath.Base(c.Outputs[0]))
+	r.mu.Unlock()
+	for _, out := range c.Outputs {
+		if filepath.Base(out) == r.fail {
+			return Permanent(fmt.Errorf("%s: exit status 1", c.Bin))
+		}
+		if err := os.WriteFile(out, []byte(c.String()), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type stubTTS struct{}
+
+func (stubTTS) Synthesize(ctx context.Context, content, voice string) ([]byte, error) {
+	return []byte("RIFF" + content), nil
+}
+
+// newEpisodeWorker sets up project ep1 with a clip and a bgm, ffprobe is
+// replaced by a script reporting 12.5s for every file
+func newEpisodeWorker(t *testing.T, runner *stubRunner) (Worker, string) {
+	t.Helper()
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ffprobe"), []byte("#!/bin/sh\necho 12.5\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	store := t.TempDir()
+	dir := filepath.Join(store, "ep1")
+	if err := os.MkdirAll(filepath.Join(dir, "asset"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"asset/a.mp4", "bgm.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	renderer, err := prompts.NewRenderer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	voices := tts.NewRegistry("stub")
+	voices.Register("stub", stubTTS{})
+
+	wk, err := New(Config{
+		FS:       filestore.NewFileLocalStore(store),
+		LLM:      &stubScript{},
+		TTS:      voices,
+		Renderer: renderer,
+		FF:       cmd.NewFFmpeg(""),
+		Whisper:  cmd.NewWhisper("whisper-cli", "ggml-base.bin"),
+		Runner:   runner,
+		RetryPolicies: map[TaskType]RetryPolicy{
+			EpisodeBuild: {MaxAttempts: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wk.Start()
+	t.Cleanup(wk.Shutdown)
+	return wk, dir
+}
+
+func readEpisodeManifest(t *testing.T, dir string) episodeManifest {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, "episode.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m episodeManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestWorker_Episode(t *testing.T) {
+	tests := []struct {
+		name     string
+		subtitle *EpisodeSubtitle
+		stages   []string
+		ran      []string
+	}{
+		{
+			name:   "no subtitle",
+			stages: []string{StageScript, StageTTS, StageConcat, StageRender, StageMix, StageMerge},
+			ran:    []string{"ep1.wav", "out.mp4", "mix.m4a", "final.mp4"},
+		},
+		{
+			name:     "subtitle",
+			subtitle: &EpisodeSubtitle{Lang: "en"},
+			stages:   []string{StageScript, StageTTS, StageConcat, StageRender, StageMix, StageMerge, StageSubtitle},
+			ran:      []string{"ep1.wav", "out.mp4", "mix.m4a", "final.mp4", "subtitle.srt"},
+		},
+		{
+			name:     "burn",
+			subtitle: &EpisodeSubtitle{Lang: "en", Burn: true},
+			stages:   []string{StageScript, StageTTS, StageConcat, StageRender, StageMix, StageMerge, StageSubtitle, StageBurn},
+			ran:      []string{"ep1.wav", "out.mp4", "mix.m4a", "final.mp4", "subtitle.srt", "final_sub.mp4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &stubRunner{}
+			wk, dir := newEpisodeWorker(t, runner)
+			events, stop := wk.Subscribe("")
+			defer stop()
+
+			id, err := wk.Submit(context.Background(), EpisodeBuild, EpisodePayLoad{
+				GenScriptPayLoad: GenScriptPayLoad{Subject: "ep1", RawText: "one\n\ntwo", Segments: 2},
+				Render:           EpisodeRender{Dur: 30},
+				Mix:              EpisodeMix{BGM: "bgm.mp3", Volume: 0.3},
+				Subtitle:         tt.subtitle,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st := waitDone(t, wk, id); st.State != TaskSucceeded {
+				t.Fatalf("episode: %+v", st)
+			}
+
+			// every stage is reported in order, then the stage is cleared
+			var reported []string
+			for len(events) > 0 {
+				if ev := <-events; ev.Type == EventStage && ev.TaskID == id {
+					reported = append(reported, ev.Stage)
+				}
+			}
+			if want := append(slices.Clone(tt.stages), ""); !slices.Equal(reported, want) {
+				t.Errorf("reported stages %q, want %q", reported, want)
+			}
+			if !slices.Equal(runner.ran, tt.ran) {
+				t.Errorf("ran %q, want %q", runner.ran, tt.ran)
+			}
+
+			m := readEpisodeManifest(t, dir)
+			var names []string
+			for _, st := range m.Stages {
+				names = append(names, st.Name)
+				if st.State != TaskSucceeded || st.Skipped || st.FinishedAt == nil {
+					t.Errorf("stage %s: %+v", st.Name, st)
+				}
+			}
+			if m.TaskID != id || !slices.Equal(names, tt.stages) {
+				t.Errorf("manifest stages %q, want %q", names, tt.stages)
+			}
+		})
+	}
+}
+
+func TestWorker_EpisodeProjectLanguage(t *testing.T) {
+	runner := &stubRunner{}
+	wk, dir := newEpisodeWorker(t, runner)
+	fs := filestore.NewFileLocalStore(filepath.Dir(dir))
+	if _, err := fs.UpdateProject("ep1", func(p *filestore.Project) error {
+		p.Language = "fr"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := wk.Submit(context.Background(), EpisodeBuild, EpisodePayLoad{
+		GenScriptPayLoad: GenScriptPayLoad{Subject: "ep1", RawText: "un\n\ndeux", Segments: 2},
+		Render:           EpisodeRender{Dur: 30},
+		Mix:              EpisodeMix{BGM: "bgm.mp3"},
+		Subtitle:         &EpisodeSubtitle{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := waitDone(t, wk, id); st.State != TaskSucceeded {
+		t.Fatalf("episode: %+v", st)
+	}
+	// the stub runner writes the command line into the output
+	b, err := os.ReadFile(filepath.Join(dir, "subtitle.srt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "-l fr") {
+		t.Fatalf("whisper ran %q", b)
+	}
+}
+
+func TestWorker_EpisodeFailedStage(t *testing.T) {
+	runner := &stubRunner{fail: "mix.m4a"}
+	wk, dir := newEpisodeWorker(t, runner)
+
+	id, err := wk.Submit(context.Background(), EpisodeBuild, EpisodePayLoad{
+		GenScriptPayLoad: GenScriptPayLoad{Subject: "ep1", RawText: "one\n\ntwo", Segments: 2},
+		Render:           EpisodeRender{Dur: 30},
+		Mix:              EpisodeMix{BGM: "bgm.mp3"},
+		Subtitle:         &EpisodeSubtitle{Lang: "en", Burn: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := waitDone(t, wk, id)
+	if st.State != TaskFailed || !strings.Contains(st.Error, "episode stage mix") {
+		t.Fatalf("episode: %+v", st)
+	}
+	if want := []string{"ep1.wav", "out.mp4", "mix.m4a"}; !slices.Equal(runner.ran, want) {
+		t.Fatalf("ran %q after the failure, want %q", runner.ran, want)
+	}
+
+	want := map[string]TaskState{
+		StageScript: TaskSucceeded, StageTTS: TaskSucceeded, StageConcat: TaskSucceeded, StageRender: TaskSucceeded,
+		StageMix:   TaskFailed,
+		StageMerge: TaskQueued, StageSubtitle: TaskQueued, StageBurn: TaskQueued,
+	}
+	m := readEpisodeManifest(t, dir)
+	if len(m.Stages) != len(want) {
+		t.Fatalf("manifest stages %+v", m.Stages)
+	}
+	for _, s := range m.Stages {
+		if s.State != want[s.Name] {
+			t.Errorf("stage %s is %s, want %s", s.Name, s.State, want[s.Name])
+		}
+		if failed := s.Name == StageMix; failed != strings.Contains(s.Error, "exit status 1") {
+			t.Errorf("stage %s error %q", s.Name, s.Error)
+		}
+		if s.State == TaskQueued && s.StartedAt != nil {
+			t.Errorf("stage %s started after the failure", s.Name)
+		}
+	}
+}